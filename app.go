@@ -2,21 +2,44 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+
 	"MoodStack/app"
 )
 
+// Auto-lock
+const (
+	// idleCheckInterval is how often the idle timeout is checked
+	idleCheckInterval = 15 * time.Second
+	// lockedEvent is emitted to the frontend when the app locks itself
+	lockedEvent = "app:locked"
+)
+
 // App struct
 type App struct {
-	ctx            context.Context
+	ctx context.Context
+
+	// The unlocked user, session and master key, guarded by keyMu. Bindings
+	// hold the read lock for the whole call, so the key is not wiped while
+	// in use; logging in and out and locking take the write lock. keyMu is
+	// always acquired before lockMu.
+	keyMu          sync.RWMutex
 	currentUser    *app.User
 	currentSession *app.Session
 	encryptionKey  []byte
+
+	// Auto-lock state, guarded by lockMu
+	lockMu          sync.Mutex
+	autoLockMinutes int
+	lastActivity    time.Time
+	lockedUser      *app.User
+	lockedSession   *app.Session
 }
 
 // NewApp creates a new App application struct
@@ -48,27 +71,126 @@ func (a *App) startup(ctx context.Context) {
 	if err := app.CleanupExpiredSessions(); err != nil {
 		fmt.Printf("Failed to cleanup expired sessions: %v\n", err)
 	}
+
+	// Permanently delete diaries whose trash retention has passed
+	if _, err := app.PurgeExpiredTrash(); err != nil {
+		fmt.Printf("Failed to purge expired trash: %v\n", err)
+	}
+
+	// Load the idle timeout and start watching for inactivity
+	minutes, err := app.GetAutoLockMinutes()
+	if err != nil {
+		fmt.Printf("Failed to load auto-lock setting: %v\n", err)
+		minutes = app.DefaultAutoLockMinutes
+	}
+	a.autoLockMinutes = minutes
+	go a.watchIdle()
 }
 
 // GetEmotionAnalysisHistory returns emotion analysis history for current user
 func (a *App) GetEmotionAnalysisHistory() ([]app.EmotionAnalysis, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("user not authenticated")
 	}
 
-	return app.GetUserEmotionTrends(a.currentUser.ID, 0)
+	return app.GetUserEmotionTrends(a.currentUser.ID, 0, a.encryptionKey)
 }
 
 // GetDiariesList returns all diary entries
 func (a *App) GetDiariesList() ([]app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return app.GetDiariesList() // Fallback to file-based storage
 	}
 	return app.GetEncryptedDiariesList(a.currentUser.ID, a.encryptionKey)
 }
 
+// ListDiaries returns a page of lightweight diary summaries, sorted and filtered by opts
+func (a *App) ListDiaries(opts app.DiaryListOptions) (*app.DiaryPage, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.ListDiaries(a.currentUser.ID, a.encryptionKey, opts)
+}
+
+// GetDiaryCalendar returns per-day diary counts and emotions of a month, or of the whole year when month is 0
+func (a *App) GetDiaryCalendar(year int, month int) ([]app.CalendarDay, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.GetDiaryCalendar(a.currentUser.ID, year, month, a.encryptionKey)
+}
+
+// GetDiariesOnDate returns the diaries written on a date (YYYY-MM-DD)
+func (a *App) GetDiariesOnDate(date string) ([]app.DiarySummary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.GetDiariesOnDate(a.currentUser.ID, date, a.encryptionKey)
+}
+
+// GetOnThisDay returns diaries written on the same day in earlier years; date defaults to today
+func (a *App) GetOnThisDay(date string) ([]app.OnThisDayGroup, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.GetOnThisDay(a.currentUser.ID, date, a.encryptionKey)
+}
+
 // GetDiaryByID returns a specific diary by ID
 func (a *App) GetDiaryByID(id string) (*app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return app.GetDiaryByID(id) // Fallback to file-based storage
 	}
@@ -77,6 +199,14 @@ func (a *App) GetDiaryByID(id string) (*app.Diary, error) {
 
 // UploadDiary uploads a diary file and converts it to markdown
 func (a *App) UploadDiary(filename string, content []byte) (*app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+
 	// Check file type
 	ext := strings.ToLower(filepath.Ext(filename))
 	if !app.IsFileTypeSupported(ext) {
@@ -128,6 +258,14 @@ func (a *App) UploadDiary(filename string, content []byte) (*app.Diary, error) {
 
 // CreateDiaryWithEncryption creates a new diary entry with specified encryption options
 func (a *App) CreateDiaryWithEncryption(title, content string, encryptionOptions app.DiaryEncryptionOptions) (*app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+
 	// Generate unique ID
 	id, err := app.GenerateID()
 	if err != nil {
@@ -170,6 +308,14 @@ func (a *App) CreateDiaryWithEncryption(title, content string, encryptionOptions
 
 // CreateDiary creates a new empty diary entry
 func (a *App) CreateDiary(title, content string) (*app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+
 	// Generate unique ID
 	id, err := app.GenerateID()
 	if err != nil {
@@ -212,6 +358,14 @@ func (a *App) CreateDiary(title, content string) (*app.Diary, error) {
 
 // UpdateDiary updates an existing diary entry
 func (a *App) UpdateDiary(diary app.Diary) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+
 	// Check if diary exists
 	if a.currentUser != nil {
 		_, err := app.GetEncryptedDiaryByID(diary.ID, a.currentUser.ID, a.encryptionKey)
@@ -241,29 +395,191 @@ func (a *App) Greet(name string) string {
 	return fmt.Sprintf("Hello %s, It's show time!", name)
 }
 
-// SearchDiaries searches for diaries by a query string
-func (a *App) SearchDiaries(query string) ([]app.Diary, error) {
+// SearchDiaries searches for diaries by a query string and returns a page of them, best match first
+func (a *App) SearchDiaries(query string, opts app.SearchOptions) ([]app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	var diaries []app.Diary
+	var err error
+	if a.currentUser == nil {
+		diaries, _, err = app.SearchDiaries(query, opts)
+	} else {
+		diaries, _, err = app.SearchEncryptedDiaries(query, a.currentUser.ID, a.encryptionKey, opts)
+	}
+	return diaries, err
+}
+
+// SearchDiariesWithContext searches for diaries and returns a page of detailed search results
+// with context, along with the number of matching diaries
+func (a *App) SearchDiariesWithContext(query string, opts app.SearchOptions) (*app.SearchPage, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return app.SearchDiariesWithContext(query, opts)
+	}
+	return app.SearchEncryptedDiariesWithContext(query, a.currentUser.ID, a.encryptionKey, opts)
+}
+
+// QueryDiaries searches diaries with the structured query language
+// (phrases, AND/OR/NOT, tag:, emotion:, sentiment:, before:, after:, mode:, type:)
+func (a *App) QueryDiaries(query string) ([]app.SearchResult, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.QueryEncryptedDiaries(query, a.currentUser.ID, a.encryptionKey)
+}
+
+// Tag methods
+
+// GetDiariesListByTag returns all diaries that have the given tag
+func (a *App) GetDiariesListByTag(tag string) ([]app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.GetEncryptedDiariesByTag(a.currentUser.ID, tag, a.encryptionKey)
+}
+
+// ListTags returns all tags with the number of diaries using them
+func (a *App) ListTags() ([]app.TagCount, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.ListTags(a.currentUser.ID, a.encryptionKey)
+}
+
+// TagDiary adds a tag to a diary
+func (a *App) TagDiary(diaryID string, tag string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	return app.TagDiary(diaryID, a.currentUser.ID, tag, a.encryptionKey)
+}
+
+// UntagDiary removes a tag from a diary
+func (a *App) UntagDiary(diaryID string, tag string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	return app.UntagDiary(diaryID, a.currentUser.ID, tag, a.encryptionKey)
+}
+
+// RenameTag renames a tag on all diaries, merging it into an existing tag of the new name
+func (a *App) RenameTag(oldName string, newName string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
-		return app.SearchDiaries(query)
+		return fmt.Errorf("用户未登录")
 	}
-	return app.SearchEncryptedDiaries(query, a.currentUser.ID, a.encryptionKey)
+
+	return app.RenameTag(a.currentUser.ID, oldName, newName, a.encryptionKey)
 }
 
-// SearchDiariesWithContext searches for diaries and returns detailed search results with context
-func (a *App) SearchDiariesWithContext(query string) ([]app.SearchResult, error) {
+// MergeTags replaces the source tags with the target tag on all diaries
+func (a *App) MergeTags(sources []string, target string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
-		return app.SearchDiariesWithContext(query)
+		return fmt.Errorf("用户未登录")
 	}
-	return app.SearchEncryptedDiariesWithContext(query, a.currentUser.ID, a.encryptionKey)
+
+	return app.MergeTags(a.currentUser.ID, sources, target, a.encryptionKey)
+}
+
+// SuggestTags suggests tags for a diary from its content, emotion keywords and existing tags
+func (a *App) SuggestTags(diaryID string) ([]app.TagSuggestion, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.SuggestTags(diaryID, a.currentUser.ID, a.encryptionKey, 0)
+}
+
+// GetAutoTagEnabled reports whether suggested tags are applied to untagged diaries on save
+func (a *App) GetAutoTagEnabled() (bool, error) {
+	return app.GetAutoTagEnabled()
+}
+
+// SetAutoTagEnabled enables or disables applying suggested tags on save
+func (a *App) SetAutoTagEnabled(enabled bool) error {
+	return app.SetAutoTagEnabled(enabled)
 }
 
 // Authentication and security methods
 
 // AuthStatusResult represents the authentication status
 type AuthStatusResult struct {
-	IsAuthenticated bool `json:"isAuthenticated"`
-	HasUsers        bool `json:"hasUsers"`
-	RequireSetup    bool `json:"requireSetup"`
+	IsAuthenticated bool   `json:"isAuthenticated"`
+	HasUsers        bool   `json:"hasUsers"`
+	RequireSetup    bool   `json:"requireSetup"`
+	IsLocked        bool   `json:"isLocked"`
+	LockedUsername  string `json:"lockedUsername,omitempty"`
 }
 
 // CheckAuthStatus checks if user is authenticated
@@ -273,15 +589,29 @@ func (a *App) CheckAuthStatus() (*AuthStatusResult, error) {
 		return nil, err
 	}
 
-	return &AuthStatusResult{
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	status := &AuthStatusResult{
 		IsAuthenticated: a.currentUser != nil,
 		HasUsers:        hasUsers,
 		RequireSetup:    !hasUsers,
-	}, nil
+	}
+
+	a.lockMu.Lock()
+	if a.currentUser == nil && a.lockedUser != nil {
+		status.IsLocked = true
+		status.LockedUsername = a.lockedUser.Username
+	}
+	a.lockMu.Unlock()
+
+	return status, nil
 }
 
-// CreateFirstUser creates the first user in the system
-func (a *App) CreateFirstUser(username, password string) (*app.AuthResult, error) {
+// CreateFirstUser creates the first user in the system. When withRecoveryKey
+// is set, a recovery key is generated and returned once in the result; it can
+// also be created later with RegenerateRecoveryKey.
+func (a *App) CreateFirstUser(username, password string, withRecoveryKey bool) (*app.AuthResult, error) {
 	// Check if any users exist
 	hasUsers, err := app.HasAnyUsers()
 	if err != nil {
@@ -305,7 +635,86 @@ func (a *App) CreateFirstUser(username, password string) (*app.AuthResult, error
 	}
 
 	// Authenticate immediately
-	return a.AuthenticateUser(username, password)
+	result, err := a.AuthenticateUser(username, password)
+	if err != nil || !result.Success {
+		return result, err
+	}
+
+	if !withRecoveryKey {
+		return result, nil
+	}
+
+	// Generate the recovery key, shown to the user once
+	a.keyMu.RLock()
+	recoveryKey, err := app.CreateRecoveryKey(a.currentUser.ID, a.encryptionKey)
+	a.keyMu.RUnlock()
+	if err != nil {
+		fmt.Printf("Failed to create recovery key: %v\n", err)
+	}
+	result.RecoveryKey = recoveryKey
+
+	return result, nil
+}
+
+// RecoverAccount resets a forgotten password with the recovery key and logs in
+func (a *App) RecoverAccount(recoveryKey, newPassword string) (*app.AuthResult, error) {
+	user, err := app.RecoverAccount(recoveryKey, newPassword)
+	if err != nil {
+		return &app.AuthResult{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return a.AuthenticateUser(user.Username, newPassword)
+}
+
+// HasRecoveryKey reports whether the current user has a recovery key
+func (a *App) HasRecoveryKey() (bool, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return false, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return false, fmt.Errorf("用户未登录")
+	}
+
+	return app.HasRecoveryKey(a.currentUser.ID)
+}
+
+// RegenerateRecoveryKey replaces the current user's recovery key and returns the new one
+func (a *App) RegenerateRecoveryKey(password string) (string, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return "", err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return "", fmt.Errorf("用户未登录")
+	}
+
+	return app.RegenerateRecoveryKey(a.currentUser.ID, password)
+}
+
+// RemoveRecoveryKey disables account recovery for the current user
+func (a *App) RemoveRecoveryKey() error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	return app.RemoveRecoveryKey(a.currentUser.ID)
 }
 
 // AuthenticateUser authenticates with username and password
@@ -316,18 +725,20 @@ func (a *App) AuthenticateUser(username, password string) (*app.AuthResult, erro
 	}
 
 	if result.Success {
-		a.currentUser = result.User
-		a.currentSession = result.Session
-
-		// Derive encryption key from password
-		salt, err := base64.StdEncoding.DecodeString(result.User.Salt)
+		// Unlock the master data key with the password
+		masterKey, err := app.UnlockMasterKey(result.User, password)
 		if err != nil {
 			return &app.AuthResult{
 				Success: false,
-				Message: "解析用户数据失败",
+				Message: "解锁加密密钥失败",
 			}, nil
 		}
-		a.encryptionKey = app.DeriveKey(password, salt)
+
+		a.setUnlocked(result.User, result.Session, masterKey)
+
+		if err := app.RunUserMigrations(result.User.ID, masterKey); err != nil {
+			fmt.Printf("Failed to run user migrations: %v\n", err)
+		}
 	}
 
 	return result, nil
@@ -341,24 +752,19 @@ func (a *App) AuthenticateWithBiometric(username string) (*app.AuthResult, error
 	}
 
 	if result.Success {
-		a.currentUser = result.User
-		a.currentSession = result.Session
-
-		// For biometric auth, we need to derive key from stored biometric key
-		// This uses the same key derivation as password-based auth for unified encryption
-		if result.User.BiometricKey != "" {
-			bioKey, err := base64.StdEncoding.DecodeString(result.User.BiometricKey)
-			if err == nil {
-				a.encryptionKey = bioKey
-			}
-		} else {
-			// If no biometric key is stored, use the user's salt to generate a key
-			// This maintains compatibility with existing encrypted diaries
-			salt, err := base64.StdEncoding.DecodeString(result.User.Salt)
-			if err == nil {
-				// Use a default key derivation for biometric mode
-				a.encryptionKey = app.DeriveKey("biometric_default", salt)
-			}
+		// Unlock the master data key with the biometric unlock secret
+		masterKey, err := app.UnlockMasterKeyWithBiometric(result.User)
+		if err != nil {
+			return &app.AuthResult{
+				Success: false,
+				Message: "解锁加密密钥失败，请使用密码登录",
+			}, nil
+		}
+
+		a.setUnlocked(result.User, result.Session, masterKey)
+
+		if err := app.RunUserMigrations(result.User.ID, masterKey); err != nil {
+			fmt.Printf("Failed to run user migrations: %v\n", err)
 		}
 	}
 
@@ -367,21 +773,395 @@ func (a *App) AuthenticateWithBiometric(username string) (*app.AuthResult, error
 
 // Logout logs out the current user
 func (a *App) Logout() error {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
 	if a.currentSession != nil {
 		if err := app.DeleteSession(a.currentSession.ID); err != nil {
 			return err
 		}
 	}
 
+	a.lockMu.Lock()
+	a.lockedUser = nil
+	a.lockedSession = nil
+	a.lockMu.Unlock()
+
+	a.clearUnlocked()
+	return nil
+}
+
+// RememberSession keeps the current session so the app can log in automatically
+// after a restart. The returned session carries the token the client must store.
+func (a *App) RememberSession() (*app.Session, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil || a.currentSession == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	if err := app.RememberSession(a.currentSession, a.encryptionKey); err != nil {
+		return nil, fmt.Errorf("保存会话失败: %v", err)
+	}
+
+	return a.currentSession, nil
+}
+
+// ResumeSession logs in with a remembered session token
+func (a *App) ResumeSession(sessionID, token string) (*app.AuthResult, error) {
+	result, masterKey, err := app.ResumeSession(sessionID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Success {
+		a.setUnlocked(result.User, result.Session, masterKey)
+
+		if err := app.RunUserMigrations(result.User.ID, masterKey); err != nil {
+			fmt.Printf("Failed to run user migrations: %v\n", err)
+		}
+	}
+
+	return result, nil
+}
+
+// ListSessions returns the active sessions of the current user
+func (a *App) ListSessions() ([]app.Session, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	sessions, err := app.ListSessions(a.currentUser.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = a.currentSession != nil && sessions[i].ID == a.currentSession.ID
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the current user's sessions.
+// Revoking the current session logs the user out.
+func (a *App) RevokeSession(sessionID string) error {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	if err := app.RevokeSession(a.currentUser.ID, sessionID); err != nil {
+		return err
+	}
+
+	if a.currentSession != nil && a.currentSession.ID == sessionID {
+		a.clearUnlocked()
+	}
+
+	return nil
+}
+
+// GetAuthAttempts returns the most recent password attempts of the current user
+func (a *App) GetAuthAttempts(limit int) ([]app.AuthAttempt, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.ListAuthAttempts(a.currentUser.ID, limit)
+}
+
+// ReportActivity records user activity and postpones the auto-lock. The
+// frontend reports input throttled; opening and saving diaries count as well.
+func (a *App) ReportActivity() {
+	a.lockMu.Lock()
+	a.lastActivity = time.Now()
+	a.lockMu.Unlock()
+}
+
+// GetAutoLockTimeout returns the idle timeout in minutes; 0 means disabled
+func (a *App) GetAutoLockTimeout() int {
+	a.lockMu.Lock()
+	defer a.lockMu.Unlock()
+	return a.autoLockMinutes
+}
+
+// SetAutoLockTimeout sets the idle timeout in minutes; 0 disables auto-lock
+func (a *App) SetAutoLockTimeout(minutes int) error {
+	if err := app.SetAutoLockMinutes(minutes); err != nil {
+		return err
+	}
+
+	a.lockMu.Lock()
+	a.autoLockMinutes = minutes
+	a.lastActivity = time.Now()
+	a.lockMu.Unlock()
+	return nil
+}
+
+// Lock locks the app immediately
+func (a *App) Lock() error {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+	a.lockMu.Lock()
+	defer a.lockMu.Unlock()
+
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	a.lock()
+	return nil
+}
+
+// UnlockWithPassword unlocks the app for the locked user with the password
+func (a *App) UnlockWithPassword(password string) (*app.AuthResult, error) {
+	username, err := a.lockedUsername()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.AuthenticateUser(username, password)
+	if err != nil || !result.Success {
+		return result, err
+	}
+
+	a.finishUnlock()
+	return result, nil
+}
+
+// UnlockWithBiometric unlocks the app for the locked user with biometric
+func (a *App) UnlockWithBiometric() (*app.AuthResult, error) {
+	username, err := a.lockedUsername()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.AuthenticateWithBiometric(username)
+	if err != nil || !result.Success {
+		return result, err
+	}
+
+	a.finishUnlock()
+	return result, nil
+}
+
+// watchIdle locks the app once the user has been idle longer than the timeout
+func (a *App) watchIdle() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			a.lockMu.Lock()
+			idle := a.idle()
+			a.lockMu.Unlock()
+			if !idle {
+				continue
+			}
+
+			// Wait for running bindings to finish with the key, then check again
+			a.keyMu.Lock()
+			a.lockMu.Lock()
+			if a.currentUser != nil && a.idle() {
+				a.lock()
+			}
+			a.lockMu.Unlock()
+			a.keyMu.Unlock()
+		}
+	}
+}
+
+// idle reports whether the user has been idle longer than the timeout.
+// Callers must hold lockMu.
+func (a *App) idle() bool {
+	timeout := time.Duration(a.autoLockMinutes) * time.Minute
+	return timeout > 0 && time.Since(a.lastActivity) >= timeout
+}
+
+// lock wipes the in-memory key and notifies the frontend. The session is kept
+// so the same user can unlock again. Callers must hold keyMu for writing and lockMu.
+func (a *App) lock() {
+	a.lockedUser = a.currentUser
+	a.lockedSession = a.currentSession
+	a.clearUnlocked()
+
+	runtime.EventsEmit(a.ctx, lockedEvent, a.lockedUser.Username)
+}
+
+// setUnlocked installs the user, session and master key of a login
+func (a *App) setUnlocked(user *app.User, session *app.Session, key []byte) {
+	a.keyMu.Lock()
+	a.currentUser = user
+	a.currentSession = session
+	a.encryptionKey = key
+	a.keyMu.Unlock()
+
+	a.ReportActivity()
+}
+
+// clearUnlocked wipes the master key and forgets the user and session.
+// Callers must hold keyMu for writing.
+func (a *App) clearUnlocked() {
+	app.WipeKey(a.encryptionKey)
 	a.currentUser = nil
 	a.currentSession = nil
 	a.encryptionKey = nil
+}
+
+// lockedUsername returns the username of the locked user
+func (a *App) lockedUsername() (string, error) {
+	a.lockMu.Lock()
+	defer a.lockMu.Unlock()
+
+	if a.lockedUser == nil {
+		return "", fmt.Errorf("应用未锁定")
+	}
+	return a.lockedUser.Username, nil
+}
+
+// finishUnlock replaces the session that was active before locking with the new one
+func (a *App) finishUnlock() {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	a.lockMu.Lock()
+	lockedSession := a.lockedSession
+	a.lockedUser = nil
+	a.lockedSession = nil
+	a.lockMu.Unlock()
+
+	if lockedSession == nil {
+		return
+	}
+
+	// Keep "remember me" working with the new session token
+	if lockedSession.RememberMe {
+		if err := app.RememberSession(a.currentSession, a.encryptionKey); err != nil {
+			fmt.Printf("Failed to remember session: %v\n", err)
+		}
+	}
+
+	if err := app.DeleteSession(lockedSession.ID); err != nil {
+		fmt.Printf("Failed to delete locked session: %v\n", err)
+	}
+}
+
+// requireUnlocked prevents falling back to unencrypted file storage while locked
+func (a *App) requireUnlocked() error {
+	a.lockMu.Lock()
+	defer a.lockMu.Unlock()
+
+	if a.currentUser == nil && a.lockedUser != nil {
+		return fmt.Errorf("应用已锁定，请先解锁")
+	}
+	return nil
+}
+
+// copyUnlockedKey returns the current user's ID and a copy of the encryption
+// key, so long-running work such as AI analysis does not hold keyMu and block
+// auto-lock. The caller must wipe the copy when done.
+func (a *App) copyUnlockedKey() (uint, []byte, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return 0, nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return 0, nil, fmt.Errorf("用户未登录")
+	}
+	return a.currentUser.ID, append([]byte(nil), a.encryptionKey...), nil
+}
+
+// ChangePassword changes the current user's password
+func (a *App) ChangePassword(oldPassword, newPassword string) error {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	sessionID := ""
+	if a.currentSession != nil {
+		sessionID = a.currentSession.ID
+	}
+
+	masterKey, err := app.ChangePassword(a.currentUser.ID, sessionID, oldPassword, newPassword)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.GetUserByID(a.currentUser.ID)
+	if err != nil {
+		return fmt.Errorf("获取用户信息失败: %v", err)
+	}
+
+	a.currentUser = user
+	a.encryptionKey = masterKey
+	return nil
+}
+
+// SetMetadataEncryption enables or disables encryption of diary titles, tags and file names
+func (a *App) SetMetadataEncryption(enabled bool) error {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	if err := app.SetMetadataEncryption(a.currentUser.ID, a.encryptionKey, enabled); err != nil {
+		return fmt.Errorf("更新元数据加密设置失败: %v", err)
+	}
 
+	a.currentUser.EncryptMetadata = enabled
 	return nil
 }
 
 // GetCurrentUser returns the current authenticated user
 func (a *App) GetCurrentUser() *app.User {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
 	return a.currentUser
 }
 
@@ -392,6 +1172,13 @@ func (a *App) CheckBiometricSupport() (*app.BiometricInfo, error) {
 
 // EnableBiometric enables biometric authentication for current user
 func (a *App) EnableBiometric(password string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return fmt.Errorf("用户未登录")
 	}
@@ -401,6 +1188,13 @@ func (a *App) EnableBiometric(password string) error {
 
 // DisableBiometric disables biometric authentication for current user
 func (a *App) DisableBiometric() error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return fmt.Errorf("用户未登录")
 	}
@@ -424,6 +1218,13 @@ func (a *App) CheckMigrationStatus() (*app.MigrationStatus, error) {
 
 // MigrateData migrates existing file-based diaries to encrypted database
 func (a *App) MigrateData() error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return fmt.Errorf("用户未登录")
 	}
@@ -437,17 +1238,153 @@ func (a *App) MigrateData() error {
 
 // Flexible encryption methods
 
+// ListDiaryRevisions returns the saved revisions of a diary, newest first
+func (a *App) ListDiaryRevisions(diaryID string) ([]app.DiaryRevisionInfo, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.ListDiaryRevisions(diaryID, a.currentUser.ID, a.encryptionKey)
+}
+
+// DiffDiaryRevisions returns a line-level diff between two revisions of a diary
+func (a *App) DiffDiaryRevisions(diaryID, fromRevisionID, toRevisionID string) ([]app.DiffLine, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.DiffDiaryRevisions(diaryID, a.currentUser.ID, fromRevisionID, toRevisionID, a.encryptionKey)
+}
+
+// RestoreDiaryRevision restores an older revision as the current content of a diary
+func (a *App) RestoreDiaryRevision(diaryID, revisionID string) (*app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.RestoreDiaryRevision(diaryID, a.currentUser.ID, revisionID, a.encryptionKey)
+}
+
+// DeleteDiary moves a diary to the trash
+func (a *App) DeleteDiary(diaryID string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	return app.DeleteEncryptedDiary(diaryID, a.currentUser.ID)
+}
+
+// RestoreDiary moves a diary out of the trash
+func (a *App) RestoreDiary(diaryID string) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return fmt.Errorf("用户未登录")
+	}
+
+	return app.RestoreEncryptedDiary(diaryID, a.currentUser.ID)
+}
+
+// ListTrash returns the diaries in the trash
+func (a *App) ListTrash() ([]app.TrashedDiary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.ListTrashedDiaries(a.currentUser.ID, a.encryptionKey)
+}
+
+// EmptyTrash permanently deletes all diaries in the trash and returns how many were deleted
+func (a *App) EmptyTrash() (int, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return 0, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return 0, fmt.Errorf("用户未登录")
+	}
+
+	return app.EmptyTrash(a.currentUser.ID)
+}
+
+// GetTrashRetentionDays returns how many days deleted diaries are kept
+func (a *App) GetTrashRetentionDays() (int, error) {
+	return app.GetTrashRetentionDays()
+}
+
+// SetTrashRetentionDays sets how many days deleted diaries are kept
+func (a *App) SetTrashRetentionDays(days int) error {
+	return app.SetTrashRetentionDays(days)
+}
+
 // GetDiaryWithPassword retrieves a diary using an individual password
 func (a *App) GetDiaryWithPassword(diaryID, password string) (*app.Diary, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("用户未登录")
 	}
 
-	return app.GetEncryptedDiaryWithPassword(diaryID, a.currentUser.ID, password)
+	return app.GetEncryptedDiaryWithPassword(diaryID, a.currentUser.ID, password, a.encryptionKey)
 }
 
 // GetDiaryEncryptionInfo returns encryption information for a diary
 func (a *App) GetDiaryEncryptionInfo(diaryID string) (*app.DiaryEncryptionInfo, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("用户未登录")
 	}
@@ -457,6 +1394,13 @@ func (a *App) GetDiaryEncryptionInfo(diaryID string) (*app.DiaryEncryptionInfo,
 
 // UpdateDiaryWithEncryption updates an existing diary with new encryption options
 func (a *App) UpdateDiaryWithEncryption(diary app.Diary, encryptionOptions app.DiaryEncryptionOptions) error {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return fmt.Errorf("用户未登录")
 	}
@@ -472,45 +1416,115 @@ func (a *App) UpdateDiaryWithEncryption(diary app.Diary, encryptionOptions app.D
 
 // AnalyzeDiaryEmotion analyzes emotion for a specific diary
 func (a *App) AnalyzeDiaryEmotion(diaryID string, useAI bool, ollamaURL string) (*app.EmotionAnalysisResult, error) {
-	if a.currentUser == nil {
-		return nil, fmt.Errorf("用户未登录")
+	userID, key, err := a.copyUnlockedKey()
+	if err != nil {
+		return nil, err
 	}
+	defer app.WipeKey(key)
 
 	// Get the diary content
-	diary, err := app.GetEncryptedDiaryByID(diaryID, a.currentUser.ID, a.encryptionKey)
+	diary, err := app.GetEncryptedDiaryByID(diaryID, userID, key)
 	if err != nil {
 		return nil, fmt.Errorf("获取日记失败: %v", err)
 	}
 
 	// Analyze emotion
-	return app.AnalyzeDiaryEmotion(diaryID, a.currentUser.ID, diary.Content, useAI, ollamaURL)
+	return app.AnalyzeDiaryEmotion(a.ctx, diaryID, userID, diary.Content, useAI, ollamaURL, key)
+}
+
+// ListEmotionAnalyzers returns the registered emotion analyzers
+func (a *App) ListEmotionAnalyzers() []app.AnalyzerInfo {
+	return app.ListEmotionAnalyzers()
+}
+
+// GetEmotionPipeline returns the analyzers used for emotion analysis and how they are combined
+func (a *App) GetEmotionPipeline() (*app.EmotionPipelineConfig, error) {
+	return app.GetEmotionPipeline()
+}
+
+// SetEmotionPipeline sets the analyzers used for emotion analysis and how they are combined
+func (a *App) SetEmotionPipeline(config app.EmotionPipelineConfig) error {
+	return app.SetEmotionPipeline(config)
 }
 
 // GetDiaryEmotionAnalysis gets existing emotion analysis for a diary
 func (a *App) GetDiaryEmotionAnalysis(diaryID string) (*app.EmotionAnalysis, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("用户未登录")
 	}
 
-	return app.GetEmotionAnalysis(diaryID, a.currentUser.ID)
+	return app.GetEmotionAnalysis(diaryID, a.currentUser.ID, a.encryptionKey)
+}
+
+// GetDiaryEmotionTimeline returns the sentence-by-sentence emotion of a diary's last analysis
+func (a *App) GetDiaryEmotionTimeline(diaryID string) ([]app.EmotionSegment, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.GetDiaryEmotionTimeline(diaryID, a.currentUser.ID, a.encryptionKey)
 }
 
 // GetUserEmotionTrends gets emotion trends for the current user
 func (a *App) GetUserEmotionTrends(days int) ([]app.EmotionAnalysis, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("用户未登录")
 	}
 
-	return app.GetUserEmotionTrends(a.currentUser.ID, days)
+	return app.GetUserEmotionTrends(a.currentUser.ID, days, a.encryptionKey)
 }
 
 // GetUserEmotionStatistics gets aggregated emotion statistics for the current user
 func (a *App) GetUserEmotionStatistics(days int) (map[string]interface{}, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
 	if a.currentUser == nil {
 		return nil, fmt.Errorf("用户未登录")
 	}
 
-	return app.GetEmotionStatistics(a.currentUser.ID, days)
+	return app.GetEmotionStatistics(a.currentUser.ID, days, a.encryptionKey)
+}
+
+// GetWritingStatistics returns writing streaks, word counts and activity patterns
+func (a *App) GetWritingStatistics() (*app.WritingStatistics, error) {
+	a.keyMu.RLock()
+	defer a.keyMu.RUnlock()
+
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	a.ReportActivity()
+	if a.currentUser == nil {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	return app.GetWritingStatistics(a.currentUser.ID, a.encryptionKey)
 }
 
 // AnalyzeAllDiariesEmotion analyzes emotion for all user's diaries
@@ -520,12 +1534,14 @@ func (a *App) AnalyzeAllDiariesEmotion(useAI bool, ollamaURL string) (map[string
 
 // AnalyzeAllDiariesEmotionWithForce analyzes emotion for all user's diaries with force option
 func (a *App) AnalyzeAllDiariesEmotionWithForce(useAI bool, ollamaURL string, force bool) (map[string]interface{}, error) {
-	if a.currentUser == nil {
-		return nil, fmt.Errorf("用户未登录")
+	userID, key, err := a.copyUnlockedKey()
+	if err != nil {
+		return nil, err
 	}
+	defer app.WipeKey(key)
 
 	// Get all diaries
-	diaries, err := app.GetEncryptedDiariesList(a.currentUser.ID, a.encryptionKey)
+	diaries, err := app.GetEncryptedDiariesList(userID, key)
 	if err != nil {
 		return nil, fmt.Errorf("获取日记列表失败: %v", err)
 	}
@@ -549,7 +1565,7 @@ func (a *App) AnalyzeAllDiariesEmotionWithForce(useAI bool, ollamaURL string, fo
 	// Analyze each diary
 	for _, diary := range diaries {
 		// Check if already analyzed (skip only if not forcing)
-		existing, err := app.GetEmotionAnalysis(diary.ID, a.currentUser.ID)
+		existing, err := app.GetEmotionAnalysis(diary.ID, userID, key)
 		if err == nil && existing != nil && !force {
 			// Already analyzed, count as success but don't re-analyze
 			successCount++
@@ -564,7 +1580,7 @@ func (a *App) AnalyzeAllDiariesEmotionWithForce(useAI bool, ollamaURL string, fo
 		}
 
 		// Analyze emotion
-		result, err := app.AnalyzeDiaryEmotion(diary.ID, a.currentUser.ID, diary.Content, useAI, ollamaURL)
+		result, err := app.AnalyzeDiaryEmotion(a.ctx, diary.ID, userID, diary.Content, useAI, ollamaURL, key)
 		if err != nil {
 			failureCount++
 			lastError = err
@@ -2,11 +2,15 @@ package app
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"time"
 
+	"golang.org/x/crypto/hkdf"
 	"gorm.io/gorm"
 )
 
@@ -17,6 +21,7 @@ type AuthResult struct {
 	Session      *Session `json:"session,omitempty"`
 	Message      string   `json:"message"`
 	RequireSetup bool     `json:"requireSetup"`
+	RecoveryKey  string   `json:"recoveryKey,omitempty"` // Only set when a recovery key was just generated
 }
 
 // CreateUser creates a new user with password
@@ -35,7 +40,10 @@ func CreateUser(username, password string) (*User, error) {
 	}
 
 	// Hash password
-	passwordHash := HashPassword(password, salt)
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
 	saltStr := base64.StdEncoding.EncodeToString(salt)
 
 	// Create user using GORM
@@ -44,6 +52,7 @@ func CreateUser(username, password string) (*User, error) {
 		PasswordHash:     passwordHash,
 		Salt:             saltStr,
 		BiometricEnabled: false,
+		EncryptMetadata:  true,
 	}
 
 	if err := gormDB.Create(user).Error; err != nil {
@@ -55,10 +64,26 @@ func CreateUser(username, password string) (*User, error) {
 
 // AuthenticateWithPassword authenticates user with password
 func AuthenticateWithPassword(username, password string) (*AuthResult, error) {
+	// Refuse attempts while the user is locked out
+	subject := userThrottleSubject(username)
+	remaining, err := throttleRemaining(subject)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return &AuthResult{
+			Success: false,
+			Message: lockoutMessage(remaining),
+		}, nil
+	}
+
 	// Get user from database
 	user, err := GetUserByUsername(username)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
+			if _, err := recordFailedAttempt(subject, 0, "unknown user"); err != nil {
+				return nil, err
+			}
 			return &AuthResult{
 				Success: false,
 				Message: "用户不存在",
@@ -74,13 +99,34 @@ func AuthenticateWithPassword(username, password string) (*AuthResult, error) {
 	}
 
 	// Verify password
-	if !VerifyPassword(password, user.PasswordHash, salt) {
+	valid, needsRehash := VerifyPassword(password, user.PasswordHash, salt)
+	if !valid {
+		lockout, err := recordFailedAttempt(subject, user.ID, "wrong password")
+		if err != nil {
+			return nil, err
+		}
+
+		message := "密码错误"
+		if lockout > 0 {
+			message = lockoutMessage(lockout)
+		}
 		return &AuthResult{
 			Success: false,
-			Message: "密码错误",
+			Message: message,
 		}, nil
 	}
 
+	if err := recordSuccessfulAttempt(subject, user.ID); err != nil {
+		return nil, err
+	}
+
+	// Transparently upgrade legacy or outdated password hashes
+	if needsRehash {
+		if err := upgradePasswordHash(user, password); err != nil {
+			fmt.Printf("Failed to upgrade password hash for user %d: %v\n", user.ID, err)
+		}
+	}
+
 	// Create session
 	session, err := CreateSession(user.ID)
 	if err != nil {
@@ -95,6 +141,137 @@ func AuthenticateWithPassword(username, password string) (*AuthResult, error) {
 	}, nil
 }
 
+// upgradePasswordHash replaces the stored password hash with one using the current algorithm
+func upgradePasswordHash(user *User, password string) error {
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := gormDB.Model(&User{}).Where("id = ?", user.ID).Update("password_hash", passwordHash).Error; err != nil {
+		return fmt.Errorf("failed to update password hash: %v", err)
+	}
+
+	user.PasswordHash = passwordHash
+	return nil
+}
+
+// ChangePassword changes a user's password and re-keys all unified and biometric diaries.
+// Everything happens in one transaction; other sessions of the user are invalidated.
+// The master data key is returned so callers can keep the current session unlocked.
+func ChangePassword(userID uint, currentSessionID, oldPassword, newPassword string) ([]byte, error) {
+	if newPassword == "" {
+		return nil, fmt.Errorf("新密码不能为空")
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	// Old-password checks share the login counter, so they cannot be used to
+	// guess the password around the login lockout
+	subject := userThrottleSubject(user.Username)
+	remaining, err := throttleRemaining(subject)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("%s", lockoutMessage(remaining))
+	}
+
+	oldSalt, err := base64.StdEncoding.DecodeString(user.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %v", err)
+	}
+
+	if valid, _ := VerifyPassword(oldPassword, user.PasswordHash, oldSalt); !valid {
+		lockout, err := recordFailedAttempt(subject, user.ID, "wrong old password")
+		if err != nil {
+			return nil, err
+		}
+		if lockout > 0 {
+			return nil, fmt.Errorf("%s", lockoutMessage(lockout))
+		}
+		return nil, fmt.Errorf("原密码错误")
+	}
+
+	if err := recordSuccessfulAttempt(subject, user.ID); err != nil {
+		return nil, err
+	}
+
+	masterKey, err := UnlockMasterKey(user, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the new key-encryption key and password hash
+	newSalt := make([]byte, saltSize)
+	if _, err := rand.Read(newSalt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	passwordHash, err := HashPassword(newPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	wrappedMasterKey, err := WrapKey(masterKey, DeriveKey(newPassword, newSalt))
+	if err != nil {
+		return nil, err
+	}
+
+	err = gormDB.Transaction(func(tx *gorm.DB) error {
+		var encDiaries []EncryptedDiary
+		if err := tx.Unscoped().Where("user_id = ? AND encryption_mode IN ?", userID, []string{"unified", "biometric"}).Find(&encDiaries).Error; err != nil {
+			return fmt.Errorf("failed to query diaries: %v", err)
+		}
+
+		// Re-encrypt every diary under a fresh data key
+		for _, encDiary := range encDiaries {
+			diary, err := openEncryptedDiary(&encDiary, masterKey)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt diary %s: %v", encDiary.ID, err)
+			}
+
+			sealed, err := sealDiary(diary, userID, encDiary.EncryptionMode, masterKey, encDiary.MetadataIV != "")
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Unscoped().Model(&EncryptedDiary{}).Where("id = ?", encDiary.ID).Updates(sealed.columns()).Error; err != nil {
+				return fmt.Errorf("failed to update diary %s: %v", encDiary.ID, err)
+			}
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"password_hash":      passwordHash,
+			"salt":               base64.StdEncoding.EncodeToString(newSalt),
+			"wrapped_master_key": wrappedMasterKey,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update password: %v", err)
+		}
+
+		// Refresh the biometric unlock secret
+		if user.BiometricEnabled {
+			if err := enrollBiometricKey(tx, user, masterKey); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("user_id = ? AND id <> ?", userID, currentSessionID).Delete(&Session{}).Error; err != nil {
+			return fmt.Errorf("failed to invalidate sessions: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("修改密码失败: %v", err)
+	}
+
+	return masterKey, nil
+}
+
 // GetUserByUsername retrieves user by username
 func GetUserByUsername(username string) (*User, error) {
 	var user User
@@ -125,51 +302,178 @@ func HasAnyUsers() (bool, error) {
 	return count > 0, nil
 }
 
-// CreateSession creates a new authentication session
+// Session lifetimes
+const (
+	sessionLifetime    = 7 * 24 * time.Hour
+	rememberMeLifetime = 30 * 24 * time.Hour
+)
+
+// sessionTokenHashPrefix marks hashed session tokens in the session_key column
+const sessionTokenHashPrefix = "sha256:"
+
+// CreateSession creates a new authentication session.
+// The returned session carries the clear-text token once; only its hash is stored.
 func CreateSession(userID uint) (*Session, error) {
-	// Generate session ID and key
+	// Generate session ID and token
 	sessionID, err := GenerateID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate session ID: %v", err)
 	}
 
-	sessionKeyBytes := make([]byte, 32)
-	if _, err := rand.Read(sessionKeyBytes); err != nil {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
 		return nil, fmt.Errorf("failed to generate session key: %v", err)
 	}
-	sessionKey := hex.EncodeToString(sessionKeyBytes)
+	token := hex.EncodeToString(tokenBytes)
 
-	// Session expires in 7 days
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
+	now := time.Now()
 
 	// Create session using GORM
 	session := &Session{
 		ID:         sessionID,
 		UserID:     userID,
-		SessionKey: sessionKey,
-		ExpiresAt:  expiresAt,
+		SessionKey: hashSessionToken(token),
+		ExpiresAt:  now.Add(sessionLifetime),
+		LastUsedAt: now,
 	}
 
 	if err := gormDB.Create(session).Error; err != nil {
 		return nil, fmt.Errorf("failed to create session: %v", err)
 	}
 
+	session.Token = token
 	return session, nil
 }
 
-// ValidateSession validates a session and returns the user
-func ValidateSession(sessionID, sessionKey string) (*User, error) {
+// RememberSession stores the master key wrapped by the session token so the
+// session can be resumed after a restart, and extends its lifetime
+func RememberSession(session *Session, masterKey []byte) error {
+	if session.Token == "" {
+		return fmt.Errorf("session token is not available")
+	}
+
+	wrappingKey, err := sessionWrappingKey(session.Token)
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := WrapKey(masterKey, wrappingKey)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(rememberMeLifetime)
+	if err := gormDB.Model(&Session{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+		"remember_me": true,
+		"wrapped_key": wrappedKey,
+		"expires_at":  expiresAt,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to remember session: %v", err)
+	}
+
+	session.RememberMe = true
+	session.WrappedKey = wrappedKey
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// ValidateSession validates a session token and returns the user and session
+func ValidateSession(sessionID, token string) (*User, *Session, error) {
 	var session Session
-	if err := gormDB.Where("id = ? AND session_key = ? AND expires_at > ?", sessionID, sessionKey, time.Now()).First(&session).Error; err != nil {
-		return nil, err
+	if err := gormDB.Where("id = ? AND expires_at > ?", sessionID, time.Now()).First(&session).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(session.SessionKey), []byte(hashSessionToken(token))) != 1 {
+		return nil, nil, fmt.Errorf("invalid session token")
 	}
 
 	var user User
 	if err := gormDB.First(&user, session.UserID).Error; err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &user, nil
+	return &user, &session, nil
+}
+
+// ResumeSession validates a remembered session and recovers the master key from it
+func ResumeSession(sessionID, token string) (*AuthResult, []byte, error) {
+	user, session, err := ValidateSession(sessionID, token)
+	if err != nil {
+		return &AuthResult{
+			Success: false,
+			Message: "会话已失效，请重新登录",
+		}, nil, nil
+	}
+
+	if !session.RememberMe || session.WrappedKey == "" {
+		return &AuthResult{
+			Success: false,
+			Message: "该会话不支持自动登录",
+		}, nil, nil
+	}
+
+	wrappingKey, err := sessionWrappingKey(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	masterKey, err := UnwrapKey(session.WrappedKey, wrappingKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unlock session key: %v", err)
+	}
+
+	now := time.Now()
+	if err := gormDB.Model(&Session{}).Where("id = ?", session.ID).Update("last_used_at", now).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to update session: %v", err)
+	}
+	session.LastUsedAt = now
+
+	return &AuthResult{
+		Success: true,
+		User:    user,
+		Session: session,
+		Message: "自动登录成功",
+	}, masterKey, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func ListSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	if err := gormDB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession deletes one of the user's sessions
+func RevokeSession(userID uint, sessionID string) error {
+	result := gormDB.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&Session{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke session: %v", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// hashSessionToken returns the stored form of a session token
+func hashSessionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return sessionTokenHashPrefix + hex.EncodeToString(hash[:])
+}
+
+// sessionWrappingKey derives the key that wraps the master key for a remembered session
+func sessionWrappingKey(token string) ([]byte, error) {
+	key := make([]byte, keySize)
+	reader := hkdf.New(sha256.New, []byte(token), nil, []byte("moodstack/session-wrap"))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return nil, fmt.Errorf("failed to derive session key: %v", err)
+	}
+	return key, nil
 }
 
 // DeleteSession deletes a session (logout)
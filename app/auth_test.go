@@ -0,0 +1,186 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// setupTestDatabase opens a fresh database in a temporary working directory
+func setupTestDatabase(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		CloseDatabase()
+		os.Chdir(wd)
+	})
+
+	if err := InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestUser creates a user and returns it with its unlocked master key
+func createTestUser(t *testing.T, username, password string) (*User, []byte) {
+	t.Helper()
+
+	user, err := CreateUser(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterKey, err := UnlockMasterKey(user, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, masterKey
+}
+
+func TestChangePasswordRejects(t *testing.T) {
+	setupTestDatabase(t)
+	user, _ := createTestUser(t, "alice", "password123")
+
+	tests := []struct {
+		name        string
+		oldPassword string
+		newPassword string
+	}{
+		{"wrong password", "wrong-password", "new-password"},
+		{"empty new password", "password123", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ChangePassword(user.ID, "", tt.oldPassword, tt.newPassword); err == nil {
+				t.Fatal("ChangePassword succeeded, want an error")
+			}
+
+			unchanged, err := GetUserByID(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := UnlockMasterKey(unchanged, "password123"); err != nil {
+				t.Errorf("old password no longer unlocks: %v", err)
+			}
+		})
+	}
+}
+
+func TestChangePasswordSharesLoginThrottle(t *testing.T) {
+	setupTestDatabase(t)
+	user, _ := createTestUser(t, "alice", "password123")
+
+	for i := 0; i < throttleFreeAttempts+1; i++ {
+		if _, err := ChangePassword(user.ID, "", "wrong-password", "new-password"); err == nil {
+			t.Fatal("ChangePassword succeeded with a wrong password")
+		}
+	}
+
+	if _, err := ChangePassword(user.ID, "", "password123", "new-password"); err == nil {
+		t.Error("ChangePassword succeeded while the user is locked out")
+	}
+
+	result, err := AuthenticateWithPassword("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Success {
+		t.Error("login succeeded after failed old-password checks locked the user out")
+	}
+}
+
+func TestChangePasswordRekeys(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+
+	diaries := []*Diary{
+		{ID: "unified", Title: "统一", Content: "统一加密的日记", Tags: []string{}},
+		{ID: "biometric", Title: "生物识别", Content: "生物识别模式的日记", Tags: []string{}},
+	}
+	modes := []string{"unified", "biometric"}
+	for i, diary := range diaries {
+		if err := SaveEncryptedDiaryWithOptions(diary, user.ID, masterKey, &DiaryEncryptionOptions{Mode: modes[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wrappedBefore := wrappedDiaryKeys(t, user.ID)
+
+	current, err := AuthenticateWithPassword("alice", "password123")
+	if err != nil || !current.Success {
+		t.Fatalf("AuthenticateWithPassword: %v %v", err, current)
+	}
+	other, err := AuthenticateWithPassword("alice", "password123")
+	if err != nil || !other.Success {
+		t.Fatalf("AuthenticateWithPassword: %v %v", err, other)
+	}
+
+	newKey, err := ChangePassword(user.ID, current.Session.ID, "password123", "new-password")
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if !bytes.Equal(newKey, masterKey) {
+		t.Error("ChangePassword changed the master key")
+	}
+
+	changed, err := GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnlockMasterKey(changed, "password123"); err == nil {
+		t.Error("old password still unlocks the master key")
+	}
+	unlocked, err := UnlockMasterKey(changed, "new-password")
+	if err != nil {
+		t.Fatalf("new password does not unlock: %v", err)
+	}
+	if !bytes.Equal(unlocked, masterKey) {
+		t.Error("new password unlocks a different master key")
+	}
+
+	wrappedAfter := wrappedDiaryKeys(t, user.ID)
+	for _, diary := range diaries {
+		if wrappedAfter[diary.ID] == wrappedBefore[diary.ID] {
+			t.Errorf("diary %s kept its data key", diary.ID)
+		}
+		opened, err := GetEncryptedDiaryByID(diary.ID, user.ID, unlocked)
+		if err != nil {
+			t.Fatalf("diary %s: %v", diary.ID, err)
+		}
+		if opened.Content != diary.Content {
+			t.Errorf("diary %s content = %q, want %q", diary.ID, opened.Content, diary.Content)
+		}
+	}
+
+	var sessions []Session
+	if err := gormDB.Where("user_id = ?", user.ID).Find(&sessions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != current.Session.ID {
+		t.Errorf("sessions after change = %v, want only the current session", sessions)
+	}
+}
+
+// wrappedDiaryKeys returns the wrapped data keys of a user's diaries by ID
+func wrappedDiaryKeys(t *testing.T, userID uint) map[string]string {
+	t.Helper()
+
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Where("user_id = ?", userID).Find(&encDiaries).Error; err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]string, len(encDiaries))
+	for _, encDiary := range encDiaries {
+		keys[encDiary.ID] = encDiary.WrappedKey
+	}
+	return keys
+}
@@ -11,8 +11,21 @@ type BiometricInfo struct {
 	Message   string `json:"message"`
 }
 
-// EnableBiometricForUser enables biometric authentication for a user
+// EnableBiometricForUser enables biometric authentication for a user.
+// It is refused when the unlock secret could only be kept by the file key
+// protector, whose key sits next to the data it protects.
 func EnableBiometricForUser(userID uint, password string) error {
+	support, err := BiometricSupport()
+	if err != nil {
+		return err
+	}
+	if !support.Supported {
+		return fmt.Errorf("当前系统不支持生物识别: %s", support.Message)
+	}
+	if !GetKeyProtector().OSBacked() {
+		return fmt.Errorf("当前系统无法安全保存生物识别密钥，不能启用生物识别")
+	}
+
 	user, err := GetUserByID(userID)
 	if err != nil {
 		return fmt.Errorf("用户不存在: %v", err)
@@ -24,18 +37,17 @@ func EnableBiometricForUser(userID uint, password string) error {
 		return fmt.Errorf("解码salt失败: %v", err)
 	}
 
-	if !VerifyPassword(password, user.PasswordHash, salt) {
+	if valid, _ := VerifyPassword(password, user.PasswordHash, salt); !valid {
 		return fmt.Errorf("密码验证失败")
 	}
 
-	// Store the current encryption key (derived from password) as the biometric key
-	encryptionKey := DeriveKey(password, salt)
-	bioKeyStr := base64.StdEncoding.EncodeToString(encryptionKey)
+	// Wrap the master data key with a new biometric unlock secret
+	masterKey, err := UnlockMasterKey(user, password)
+	if err != nil {
+		return fmt.Errorf("解锁主密钥失败: %v", err)
+	}
 
-	if err := gormDB.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
-		"biometric_enabled": true,
-		"biometric_key":     bioKeyStr,
-	}).Error; err != nil {
+	if err := enrollBiometricKey(gormDB, user, masterKey); err != nil {
 		return fmt.Errorf("更新用户生物识别设置失败: %v", err)
 	}
 
@@ -45,8 +57,10 @@ func EnableBiometricForUser(userID uint, password string) error {
 // DisableBiometricForUser disables biometric authentication for a user
 func DisableBiometricForUser(userID uint) error {
 	if err := gormDB.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
-		"biometric_enabled": false,
-		"biometric_key":     "",
+		"biometric_enabled":       false,
+		"biometric_key":           "",
+		"biometric_wrapped_key":   "",
+		"biometric_protected_key": "",
 	}).Error; err != nil {
 		return fmt.Errorf("禁用生物识别失败: %v", err)
 	}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Calendar views
+//
+// Days are calendar days in local time. The per-day emotion of the calendar
+// is the most common dominant emotion among the day's analyzed diaries.
+
+// CalendarDay is a day with diaries, for a heatmap calendar
+type CalendarDay struct {
+	Date            string  `json:"date"` // YYYY-MM-DD
+	Count           int     `json:"count"`
+	DominantEmotion string  `json:"dominantEmotion,omitempty"`
+	AvgSentiment    float64 `json:"avgSentiment"`
+	Analyzed        int     `json:"analyzed"` // Diaries of the day with an emotion analysis
+}
+
+// OnThisDayGroup holds the diaries written on the same day in an earlier year
+type OnThisDayGroup struct {
+	Year     int            `json:"year"`
+	YearsAgo int            `json:"yearsAgo"`
+	Entries  []DiarySummary `json:"entries"`
+}
+
+// GetDiaryCalendar returns the days of a month with diaries, in date order.
+// A month of 0 returns the whole year.
+func GetDiaryCalendar(userID uint, year, month int, masterKey []byte) ([]CalendarDay, error) {
+	if month < 0 || month > 12 {
+		return nil, fmt.Errorf("无效的月份: %d", month)
+	}
+
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(1, 0, 0)
+	if month > 0 {
+		start = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+		end = start.AddDate(0, 1, 0)
+	}
+
+	inRange := gormDB.Model(&EncryptedDiary{}).Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, start, end)
+
+	var encDiaries []EncryptedDiary
+	if err := inRange.Session(&gorm.Session{}).Select("id, created_at").Find(&encDiaries).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	var analyses []EmotionAnalysis
+	if err := gormDB.Where("user_id = ? AND diary_id IN (?)", userID, inRange.Session(&gorm.Session{}).Select("id")).
+		Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to query emotion analyses: %v", err)
+	}
+	analysisByDiary := make(map[string]*EmotionAnalysis, len(analyses))
+	for i := range analyses {
+		if err := openEmotionAnalysis(&analyses[i], masterKey); err != nil {
+			continue
+		}
+		analysisByDiary[analyses[i].DiaryID] = &analyses[i]
+	}
+
+	days := make(map[string]*CalendarDay)
+	emotions := make(map[string]map[string]int)
+	for _, encDiary := range encDiaries {
+		date := encDiary.CreatedAt.Local().Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &CalendarDay{Date: date}
+			days[date] = day
+			emotions[date] = make(map[string]int)
+		}
+		day.Count++
+
+		if analysis, ok := analysisByDiary[encDiary.ID]; ok {
+			day.Analyzed++
+			day.AvgSentiment += analysis.SentimentScore
+			if analysis.DominantEmotion != "" {
+				emotions[date][analysis.DominantEmotion]++
+			}
+		}
+	}
+
+	calendar := make([]CalendarDay, 0, len(days))
+	for date, day := range days {
+		if day.Analyzed > 0 {
+			day.AvgSentiment /= float64(day.Analyzed)
+		}
+		day.DominantEmotion = mostCommon(emotions[date])
+		calendar = append(calendar, *day)
+	}
+	sort.Slice(calendar, func(i, j int) bool {
+		return calendar[i].Date < calendar[j].Date
+	})
+
+	return calendar, nil
+}
+
+// mostCommon returns the key with the highest count, the alphabetically first on ties
+func mostCommon(counts map[string]int) string {
+	best := ""
+	for key, count := range counts {
+		if best == "" || count > counts[best] || (count == counts[best] && key < best) {
+			best = key
+		}
+	}
+	return best
+}
+
+// GetDiariesOnDate returns the summaries of the diaries written on a date (YYYY-MM-DD), oldest first
+func GetDiariesOnDate(userID uint, date string, masterKey []byte) ([]DiarySummary, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("无效的日期 %s，请使用 YYYY-MM-DD 格式", date)
+	}
+
+	var rows []EncryptedDiary
+	if err := gormDB.Select(diarySummaryColumns).
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, day, day.AddDate(0, 0, 1)).
+		Order("created_at ASC").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	page := &DiaryPage{Items: []DiarySummary{}}
+	if err := fillDiarySummaries(page, rows, userID, masterKey); err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// GetOnThisDay returns the diaries written on the same month and day as date
+// (YYYY-MM-DD, today when empty) in earlier years, most recent year first.
+// On February 28 of a common year, diaries of February 29 are included.
+func GetOnThisDay(userID uint, date string, masterKey []byte) ([]OnThisDayGroup, error) {
+	day := time.Now()
+	if date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("无效的日期 %s，请使用 YYYY-MM-DD 格式", date)
+		}
+		day = parsed
+	}
+
+	includeLeapDay := day.Month() == time.February && day.Day() == 28 &&
+		time.Date(day.Year(), time.February, 29, 0, 0, 0, 0, time.Local).Month() != time.February
+
+	// Only IDs and dates are loaded to find the matching days
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Select("id, created_at").
+		Where("user_id = ? AND created_at < ?", userID, time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.Local)).
+		Find(&encDiaries).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	var ids []string
+	for _, encDiary := range encDiaries {
+		created := encDiary.CreatedAt.Local()
+		if created.Month() != day.Month() {
+			continue
+		}
+		if created.Day() == day.Day() || (includeLeapDay && created.Day() == 29) {
+			ids = append(ids, encDiary.ID)
+		}
+	}
+
+	groups := []OnThisDayGroup{}
+	if len(ids) == 0 {
+		return groups, nil
+	}
+
+	var rows []EncryptedDiary
+	if err := gormDB.Select(diarySummaryColumns).Where("id IN ?", ids).Order("created_at DESC").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	page := &DiaryPage{Items: []DiarySummary{}}
+	if err := fillDiarySummaries(page, rows, userID, masterKey); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range page.Items {
+		year := entry.CreatedAt.Local().Year()
+		if len(groups) == 0 || groups[len(groups)-1].Year != year {
+			groups = append(groups, OnThisDayGroup{Year: year, YearsAgo: day.Year() - year})
+		}
+		group := &groups[len(groups)-1]
+		group.Entries = append(group.Entries, entry)
+	}
+
+	return groups, nil
+}
@@ -17,7 +17,8 @@ var gormDB *gorm.DB
 
 // InitDatabase initializes the SQLite database
 func InitDatabase() error {
-	dbPath := filepath.Join("data", "moodstack.db")
+	// secure_delete overwrites deleted content so removed diaries do not linger in free pages
+	dbPath := filepath.Join("data", "moodstack.db") + "?_secure_delete=on"
 
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll("data", 0755); err != nil {
@@ -62,6 +63,13 @@ func AutoMigrateModels() error {
 		&Session{},
 		&AppSetting{},
 		&EmotionAnalysis{},
+		&Tag{},
+		&DiaryTag{},
+		&DiaryRevision{},
+		&SearchPosting{},
+		&SearchDocument{},
+		&AuthThrottle{},
+		&AuthAttempt{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to auto-migrate models: %v", err)
@@ -0,0 +1,541 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Diary listing
+//
+// Listings are built from the diary rows without their content. Each diary
+// carries a small summary envelope (excerpt and word count) sealed with its
+// data key, so a page of summaries only unwraps the data keys and opens the
+// summary and metadata envelopes of the diaries on that page. Sorting by
+// title needs the titles of all matching diaries, which may be encrypted, so
+// that sort opens the metadata of every match but still no content.
+const (
+	// defaultListLimit and maxListLimit bound the page size
+	defaultListLimit = 20
+	maxListLimit     = 100
+
+	// excerptLength is the excerpt length in characters
+	excerptLength = 120
+)
+
+// DiaryListOptions controls a diary listing
+type DiaryListOptions struct {
+	Cursor string `json:"cursor,omitempty"` // NextCursor of the previous page
+	Limit  int    `json:"limit,omitempty"`
+	SortBy string `json:"sortBy,omitempty"` // 'created' (default), 'updated', 'title'
+	Order  string `json:"order,omitempty"`  // 'desc' (default for dates), 'asc' (default for title)
+	From   string `json:"from,omitempty"`   // YYYY-MM-DD, created on or after
+	To     string `json:"to,omitempty"`     // YYYY-MM-DD, created on or before
+	Tag    string `json:"tag,omitempty"`
+}
+
+// DiarySummary is a lightweight diary entry for listings
+type DiarySummary struct {
+	ID              string    `json:"id"`
+	Title           string    `json:"title"`
+	Excerpt         string    `json:"excerpt"`
+	WordCount       int       `json:"wordCount"`
+	FileType        string    `json:"fileType"`
+	EncryptionMode  string    `json:"encryptionMode"`
+	Locked          bool      `json:"locked"` // Individually encrypted; excerpt and word count are unavailable
+	Tags            []string  `json:"tags"`
+	DominantEmotion string    `json:"dominantEmotion,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// DiaryPage is a page of diary summaries
+type DiaryPage struct {
+	Items      []DiarySummary `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"` // Empty on the last page
+	Total      int64          `json:"total"`                // Diaries matching the filters
+}
+
+// diarySummaryPayload is the sealed summary envelope of a diary
+type diarySummaryPayload struct {
+	Excerpt   string `json:"excerpt"`
+	WordCount int    `json:"wordCount"`
+}
+
+// listCursor is the decoded position after the last item of a page
+type listCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// diarySummaryColumns are the diary columns a listing needs; the content is left out
+var diarySummaryColumns = []string{
+	"id", "user_id", "title", "file_name", "file_type", "tags", "encryption_mode", "wrapped_key",
+	"encrypted_metadata", "metadata_iv", "encrypted_summary", "summary_iv", "created_at", "updated_at",
+}
+
+var (
+	markdownImagePattern = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	markdownLinkPattern  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownLinePrefix   = regexp.MustCompile(`^\s*(#{1,6}\s+|>\s*|[-*+]\s+|\d+\.\s+)`)
+)
+
+// diaryExcerpt returns the beginning of a diary's content as plain text
+func diaryExcerpt(content string) string {
+	var parts []string
+	length := 0
+	for _, line := range strings.Split(content, "\n") {
+		line = markdownLinePrefix.ReplaceAllString(line, "")
+		line = markdownImagePattern.ReplaceAllString(line, "")
+		line = markdownLinkPattern.ReplaceAllString(line, "$1")
+		line = strings.NewReplacer("**", "", "__", "", "`", "", "~~", "").Replace(line)
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+
+		parts = append(parts, line)
+		length += utf8.RuneCountInString(line)
+		if length > excerptLength {
+			break
+		}
+	}
+
+	excerpt := []rune(strings.Join(parts, " "))
+	if len(excerpt) > excerptLength {
+		return string(excerpt[:excerptLength]) + "..."
+	}
+	return string(excerpt)
+}
+
+// countWords counts words the way writers expect: every CJK character is a
+// word, and so is every run of letters or digits in other scripts
+func countWords(text string) int {
+	count := 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			count++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'':
+			if !inWord {
+				count++
+				inWord = true
+			}
+		default:
+			inWord = false
+		}
+	}
+	return count
+}
+
+// diarySummaryAAD returns the associated data for a diary's summary envelope
+func diarySummaryAAD(diaryID string, userID uint, mode string) []byte {
+	return append(diaryAAD(diaryID, userID, mode), []byte("|summary")...)
+}
+
+// sealDiarySummary seals the listing summary of a diary's content with its data key
+func sealDiarySummary(content string, diaryID string, userID uint, mode string, dataKey []byte) ([]byte, string, error) {
+	data, err := json.Marshal(diarySummaryPayload{
+		Excerpt:   diaryExcerpt(content),
+		WordCount: countWords(content),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal diary summary: %v", err)
+	}
+
+	ciphertext, iv, err := EncryptDataWithAAD(data, dataKey, diarySummaryAAD(diaryID, userID, mode))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt diary summary: %v", err)
+	}
+	return ciphertext, base64.StdEncoding.EncodeToString(iv), nil
+}
+
+// openDiarySummary builds the summary of a diary row without decrypting its
+// content, unless the row has no summary envelope yet
+func openDiarySummary(encDiary *EncryptedDiary, kek []byte) (*DiarySummary, error) {
+	summary := &DiarySummary{
+		ID:             encDiary.ID,
+		Title:          encDiary.Title,
+		FileType:       encDiary.FileType,
+		EncryptionMode: encDiary.EncryptionMode,
+		Tags:           encDiary.GetTags(),
+		CreatedAt:      encDiary.CreatedAt,
+		UpdatedAt:      encDiary.UpdatedAt,
+	}
+
+	if encDiary.EncryptionMode == "individual" {
+		summary.Title = lockedDiary(encDiary).Title
+		summary.Tags = []string{}
+		summary.Locked = true
+		return summary, nil
+	}
+
+	dataKey, err := resolveDiaryKey(encDiary, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if encDiary.MetadataIV != "" {
+		metadata, err := openDiaryMetadata(encDiary, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		summary.Title = metadata.Title
+		summary.Tags = metadata.Tags
+	}
+
+	if encDiary.SummaryIV == "" {
+		// Not migrated yet: fall back to the content, which listings do not load
+		var full EncryptedDiary
+		if err := gormDB.Unscoped().Where("id = ?", encDiary.ID).First(&full).Error; err != nil {
+			return nil, fmt.Errorf("failed to get diary: %v", err)
+		}
+		diary, err := openEncryptedDiary(&full, kek)
+		if err != nil {
+			return nil, err
+		}
+		summary.Excerpt = diaryExcerpt(diary.Content)
+		summary.WordCount = countWords(diary.Content)
+		return summary, nil
+	}
+
+	data, err := decryptDiaryContent(encDiary.EncryptedSummary, encDiary.SummaryIV, dataKey, diarySummaryAAD(encDiary.ID, encDiary.UserID, encDiary.EncryptionMode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt diary summary: %v", err)
+	}
+
+	var payload diarySummaryPayload
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse diary summary: %v", err)
+	}
+
+	summary.Excerpt = payload.Excerpt
+	summary.WordCount = payload.WordCount
+	return summary, nil
+}
+
+// ListDiaries returns a page of diary summaries
+func ListDiaries(userID uint, masterKey []byte, opts DiaryListOptions) (*DiaryPage, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	sortBy := opts.SortBy
+	if sortBy == "" {
+		sortBy = "created"
+	}
+	if sortBy != "created" && sortBy != "updated" && sortBy != "title" {
+		return nil, fmt.Errorf("不支持的排序方式: %s", sortBy)
+	}
+
+	order := strings.ToLower(opts.Order)
+	if order == "" {
+		order = "desc"
+		if sortBy == "title" {
+			order = "asc"
+		}
+	}
+	if order != "asc" && order != "desc" {
+		return nil, fmt.Errorf("不支持的排序顺序: %s", opts.Order)
+	}
+
+	var cursor *listCursor
+	if opts.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err == nil {
+			cursor = &listCursor{}
+			err = json.Unmarshal(data, cursor)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("无效的分页游标")
+		}
+	}
+
+	query, err := filteredDiaries(userID, masterKey, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &DiaryPage{Items: []DiarySummary{}}
+	if err := query.Model(&EncryptedDiary{}).Count(&page.Total).Error; err != nil {
+		return nil, fmt.Errorf("failed to count diaries: %v", err)
+	}
+
+	var rows []EncryptedDiary
+	var nextCursor func(row *EncryptedDiary) listCursor
+
+	if sortBy == "title" {
+		rows, nextCursor, err = diariesByTitle(query, masterKey, order, cursor, limit)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		column := "created_at"
+		if sortBy == "updated" {
+			column = "updated_at"
+		}
+
+		if cursor != nil {
+			position, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, fmt.Errorf("无效的分页游标")
+			}
+			op := "<"
+			if order == "asc" {
+				op = ">"
+			}
+			query = query.Where(fmt.Sprintf("%s %s ? OR (%s = ? AND id %s ?)", column, op, column, op), position, position, cursor.ID)
+		}
+
+		if err := query.Select(diarySummaryColumns).Order(fmt.Sprintf("%s %s, id %s", column, order, order)).
+			Limit(limit + 1).Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to query diaries: %v", err)
+		}
+
+		nextCursor = func(row *EncryptedDiary) listCursor {
+			position := row.CreatedAt
+			if sortBy == "updated" {
+				position = row.UpdatedAt
+			}
+			return listCursor{Value: position.Format(time.RFC3339Nano), ID: row.ID}
+		}
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		data, err := json.Marshal(nextCursor(&rows[limit-1]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode cursor: %v", err)
+		}
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	if err := fillDiarySummaries(page, rows, userID, masterKey); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// filteredDiaries returns a query for the user's diaries matching the date and tag filters
+func filteredDiaries(userID uint, masterKey []byte, opts DiaryListOptions) (*gorm.DB, error) {
+	query := gormDB.Where("user_id = ?", userID)
+
+	if opts.From != "" {
+		from, err := time.ParseInLocation("2006-01-02", opts.From, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("无效的日期 %s，请使用 YYYY-MM-DD 格式", opts.From)
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if opts.To != "" {
+		to, err := time.ParseInLocation("2006-01-02", opts.To, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("无效的日期 %s，请使用 YYYY-MM-DD 格式", opts.To)
+		}
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	if opts.Tag != "" {
+		_, macKey, err := tagKeys(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := findTag(gormDB, userID, opts.Tag, macKey)
+		if err != nil {
+			return nil, err
+		}
+		tagID := ""
+		if tag != nil {
+			tagID = tag.ID
+		}
+		// The tags of individually encrypted diaries are protected, so they never match
+		query = query.Where("encryption_mode <> ? AND id IN (?)", "individual", gormDB.Model(&DiaryTag{}).Select("diary_id").Where("tag_id = ?", tagID))
+	}
+
+	// The query is counted and then paged, so it must be safe to reuse
+	return query.Session(&gorm.Session{}), nil
+}
+
+// diariesByTitle returns the page of rows after cursor in title order. Titles
+// are compared case-insensitively, with the ID breaking ties.
+func diariesByTitle(query *gorm.DB, masterKey []byte, order string, cursor *listCursor, limit int) ([]EncryptedDiary, func(row *EncryptedDiary) listCursor, error) {
+	var rows []EncryptedDiary
+	if err := query.Select([]string{"id", "user_id", "title", "encryption_mode", "wrapped_key", "encrypted_metadata", "metadata_iv"}).
+		Find(&rows).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	titles := make(map[string]string, len(rows))
+	for i := range rows {
+		titles[rows[i].ID] = strings.ToLower(listTitle(&rows[i], masterKey))
+	}
+
+	less := func(a, b *EncryptedDiary) bool {
+		if titles[a.ID] != titles[b.ID] {
+			return titles[a.ID] < titles[b.ID]
+		}
+		return a.ID < b.ID
+	}
+	if order == "desc" {
+		ascending := less
+		less = func(a, b *EncryptedDiary) bool { return ascending(b, a) }
+	}
+	sort.Slice(rows, func(i, j int) bool { return less(&rows[i], &rows[j]) })
+
+	start := 0
+	if cursor != nil {
+		position := &EncryptedDiary{ID: cursor.ID}
+		titles[cursor.ID] = cursor.Value
+		start = sort.Search(len(rows), func(i int) bool { return less(position, &rows[i]) })
+	}
+
+	end := start + limit + 1
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	ids := make([]string, 0, end-start)
+	for _, row := range rows[start:end] {
+		ids = append(ids, row.ID)
+	}
+
+	// Reload the page with all summary columns, keeping the title order
+	var pageRows []EncryptedDiary
+	if len(ids) > 0 {
+		if err := gormDB.Select(diarySummaryColumns).Where("id IN ?", ids).Find(&pageRows).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to query diaries: %v", err)
+		}
+	}
+	positions := make(map[string]int, len(ids))
+	for i, id := range ids {
+		positions[id] = i
+	}
+	sort.Slice(pageRows, func(i, j int) bool { return positions[pageRows[i].ID] < positions[pageRows[j].ID] })
+
+	nextCursor := func(row *EncryptedDiary) listCursor {
+		return listCursor{Value: titles[row.ID], ID: row.ID}
+	}
+	return pageRows, nextCursor, nil
+}
+
+// listTitle returns the title a diary is listed under
+func listTitle(encDiary *EncryptedDiary, masterKey []byte) string {
+	if encDiary.MetadataIV == "" {
+		return encDiary.Title
+	}
+	if encDiary.EncryptionMode == "individual" {
+		return lockedDiary(encDiary).Title
+	}
+
+	dataKey, err := resolveDiaryKey(encDiary, masterKey)
+	if err != nil {
+		return ""
+	}
+	metadata, err := openDiaryMetadata(encDiary, dataKey)
+	if err != nil {
+		return ""
+	}
+	return metadata.Title
+}
+
+// fillDiarySummaries opens the summaries of a page of rows with their tags and dominant emotions
+func fillDiarySummaries(page *DiaryPage, rows []EncryptedDiary, userID uint, masterKey []byte) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(rows))
+	for i := range rows {
+		ids[i] = rows[i].ID
+	}
+
+	tags, err := diaryTagNames(userID, ids, masterKey)
+	if err != nil {
+		return err
+	}
+
+	var analyses []EmotionAnalysis
+	if err := gormDB.Where("user_id = ? AND diary_id IN ?", userID, ids).Find(&analyses).Error; err != nil {
+		return fmt.Errorf("failed to query emotion analyses: %v", err)
+	}
+	emotions := make(map[string]string, len(analyses))
+	for i := range analyses {
+		if err := openEmotionAnalysis(&analyses[i], masterKey); err != nil {
+			continue
+		}
+		emotions[analyses[i].DiaryID] = analyses[i].DominantEmotion
+	}
+
+	for i := range rows {
+		summary, err := openDiarySummary(&rows[i], masterKey)
+		if err != nil {
+			fmt.Printf("Failed to open diary %s: %v\n", rows[i].ID, err)
+			continue
+		}
+		if diaryTags, ok := tags[summary.ID]; ok {
+			summary.Tags = diaryTags
+		}
+		summary.DominantEmotion = emotions[summary.ID]
+		page.Items = append(page.Items, *summary)
+	}
+
+	return nil
+}
+
+// migrateDiarySummaries adds summary envelopes to unified and biometric
+// diaries saved before summaries existed. Individually encrypted diaries get
+// theirs the next time they are saved with their password.
+func migrateDiarySummaries(userID uint, masterKey []byte) error {
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Unscoped().Where("user_id = ? AND encryption_mode IN ?", userID, []string{"unified", "biometric"}).
+		Where("summary_iv IS NULL OR summary_iv = ''").Find(&encDiaries).Error; err != nil {
+		return fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	if len(encDiaries) == 0 {
+		return nil
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		for _, encDiary := range encDiaries {
+			diary, err := openEncryptedDiary(&encDiary, masterKey)
+			if err != nil {
+				fmt.Printf("Failed to summarize diary %s: %v\n", encDiary.ID, err)
+				continue
+			}
+
+			// Legacy rows without a wrapped data key are sealed with the master key itself
+			dataKey, err := resolveDiaryKey(&encDiary, masterKey)
+			if err != nil {
+				return err
+			}
+
+			encryptedSummary, summaryIV, err := sealDiarySummary(diary.Content, encDiary.ID, userID, encDiary.EncryptionMode, dataKey)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Unscoped().Model(&EncryptedDiary{}).Where("id = ?", encDiary.ID).Updates(map[string]interface{}{
+				"encrypted_summary": encryptedSummary,
+				"summary_iv":        summaryIV,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to update diary %s: %v", encDiary.ID, err)
+			}
+		}
+		return nil
+	})
+}
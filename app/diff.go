@@ -0,0 +1,107 @@
+package app
+
+import "strings"
+
+// maxDiffCells caps the LCS table of the lines between the common prefix and
+// suffix. Larger changes are shown as the old lines replaced by the new ones.
+const maxDiffCells = 1 << 20
+
+// DiffLine is one line of a line-level diff
+type DiffLine struct {
+	Type    string `json:"type"` // "equal", "insert" or "delete"
+	Text    string `json:"text"`
+	OldLine int    `json:"oldLine"` // 1-based line in the old text, 0 for inserted lines
+	NewLine int    `json:"newLine"` // 1-based line in the new text, 0 for deleted lines
+}
+
+// diffLines computes a line-level diff between two texts using the longest
+// common subsequence of their lines, or replaces all changed lines when they
+// are too many to compare
+func diffLines(oldText, newText string) []DiffLine {
+	oldLines := splitLines(oldText)
+	newLines := splitLines(newText)
+
+	// Strip the common prefix and suffix to keep the LCS table small
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+
+	result := make([]DiffLine, 0, len(oldLines)+len(newLines))
+	for i := 0; i < prefix; i++ {
+		result = append(result, DiffLine{Type: "equal", Text: oldLines[i], OldLine: i + 1, NewLine: i + 1})
+	}
+
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for i, line := range a {
+			result = append(result, DiffLine{Type: "delete", Text: line, OldLine: prefix + i + 1})
+		}
+		for j, line := range b {
+			result = append(result, DiffLine{Type: "insert", Text: line, NewLine: prefix + j + 1})
+		}
+	} else {
+		result = appendLCSDiff(result, a, b, prefix)
+	}
+
+	for k := 0; k < suffix; k++ {
+		oldLine := len(oldLines) - suffix + k
+		newLine := len(newLines) - suffix + k
+		result = append(result, DiffLine{Type: "equal", Text: oldLines[oldLine], OldLine: oldLine + 1, NewLine: newLine + 1})
+	}
+
+	return result
+}
+
+// appendLCSDiff appends the diff of a and b along their longest common
+// subsequence; offset is the number of lines before them
+func appendLCSDiff(result []DiffLine, a, b []string, offset int) []DiffLine {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			result = append(result, DiffLine{Type: "equal", Text: a[i], OldLine: offset + i + 1, NewLine: offset + j + 1})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			result = append(result, DiffLine{Type: "delete", Text: a[i], OldLine: offset + i + 1})
+			i++
+		default:
+			result = append(result, DiffLine{Type: "insert", Text: b[j], NewLine: offset + j + 1})
+			j++
+		}
+	}
+	return result
+}
+
+// splitLines splits text into lines, treating an empty text as no lines
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
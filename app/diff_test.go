@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    []DiffLine
+	}{
+		{"empty", "", "", []DiffLine{}},
+		{"insert", "a\nc", "a\nb\nc", []DiffLine{
+			{Type: "equal", Text: "a", OldLine: 1, NewLine: 1},
+			{Type: "insert", Text: "b", NewLine: 2},
+			{Type: "equal", Text: "c", OldLine: 2, NewLine: 3},
+		}},
+		{"replace", "a\nb\nc", "a\nx\nc", []DiffLine{
+			{Type: "equal", Text: "a", OldLine: 1, NewLine: 1},
+			{Type: "delete", Text: "b", OldLine: 2},
+			{Type: "insert", Text: "x", NewLine: 2},
+			{Type: "equal", Text: "c", OldLine: 3, NewLine: 3},
+		}},
+		{"reorder", "a\nb\nc", "c\na\nb", []DiffLine{
+			{Type: "insert", Text: "c", NewLine: 1},
+			{Type: "equal", Text: "a", OldLine: 1, NewLine: 2},
+			{Type: "equal", Text: "b", OldLine: 2, NewLine: 3},
+			{Type: "delete", Text: "c", OldLine: 3},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.oldText, tt.newText); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesReplacesLargeChanges(t *testing.T) {
+	var oldLines, newLines []string
+	for i := 0; i < 2000; i++ {
+		oldLines = append(oldLines, fmt.Sprintf("old %d", i))
+		newLines = append(newLines, fmt.Sprintf("new %d", i))
+	}
+	oldText := "same\n" + strings.Join(oldLines, "\n") + "\nend"
+	newText := "same\n" + strings.Join(newLines, "\n") + "\nend"
+
+	diff := diffLines(oldText, newText)
+	if len(diff) != 4002 {
+		t.Fatalf("got %d lines, want 4002", len(diff))
+	}
+	if diff[0].Type != "equal" || diff[len(diff)-1].Type != "equal" {
+		t.Error("common prefix and suffix are not kept")
+	}
+	for i, line := range diff[1:2001] {
+		if line.Type != "delete" || line.OldLine != i+2 {
+			t.Fatalf("line %d = %+v, want deletion of old line %d", i+1, line, i+2)
+		}
+	}
+	for i, line := range diff[2001:4001] {
+		if line.Type != "insert" || line.NewLine != i+2 {
+			t.Fatalf("line %d = %+v, want insertion of new line %d", i+2001, line, i+2)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -13,6 +15,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -34,11 +37,13 @@ type DictionaryData struct {
 }
 
 type EmotionCategory struct {
+	Polarity  *float64        `json:"polarity"` // 1 positive, -1 negative, 0 neutral
 	Keywords  []string        `json:"keywords"`
 	Intensity IntensityLevels `json:"intensity"`
 }
 
 type SentimentCategory struct {
+	Polarity *float64 `json:"polarity"` // 1 for positive words, -1 for negative words
 	Keywords []string `json:"keywords"`
 }
 
@@ -125,6 +130,9 @@ type EmotionAnalysisResult struct {
 	SentimentLabel  string   `json:"sentimentLabel"`
 	Keywords        []string `json:"keywords"`
 	AnalysisMethod  string   `json:"analysisMethod"`
+
+	// Timeline holds the emotion of each sentence, when the engine provides one
+	Timeline []EmotionSegment `json:"timeline,omitempty"`
 }
 
 // AnalyzeEmotionProgrammatically performs rule-based emotion analysis
@@ -272,8 +280,11 @@ func AnalyzeEmotionProgrammatically(content string) (*EmotionAnalysisResult, err
 	}, nil
 }
 
+// ollamaModel is the model used for AI analysis
+const ollamaModel = "qwen2.5:7b" // 使用通义千问模型，你可以根据需要修改
+
 // AnalyzeEmotionWithAI performs AI-based emotion analysis using Ollama
-func AnalyzeEmotionWithAI(content string, ollamaURL string) (*EmotionAnalysisResult, error) {
+func AnalyzeEmotionWithAI(ctx context.Context, content string, ollamaURL string) (*EmotionAnalysisResult, error) {
 	if ollamaURL == "" {
 		ollamaURL = "http://localhost:11434"
 	}
@@ -299,7 +310,7 @@ func AnalyzeEmotionWithAI(content string, ollamaURL string) (*EmotionAnalysisRes
 }`, content)
 
 	reqBody := OllamaRequest{
-		Model:  "qwen2.5:7b", // 使用通义千问模型，你可以根据需要修改
+		Model:  ollamaModel,
 		Prompt: prompt,
 		Stream: false,
 	}
@@ -313,7 +324,13 @@ func AnalyzeEmotionWithAI(content string, ollamaURL string) (*EmotionAnalysisRes
 		Timeout: 60 * time.Second,
 	}
 
-	resp, err := client.Post(ollamaURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call Ollama API: %v", err)
 	}
@@ -340,15 +357,14 @@ func AnalyzeEmotionWithAI(content string, ollamaURL string) (*EmotionAnalysisRes
 	jsonStart := strings.Index(ollamaResp.Response, "{")
 	jsonEnd := strings.LastIndex(ollamaResp.Response, "}") + 1
 
+	// The pipeline falls back to another analyzer when the model returns no usable JSON
 	if jsonStart == -1 || jsonEnd <= jsonStart {
-		// If no JSON found, fall back to programmatic analysis
-		return AnalyzeEmotionProgrammatically(content)
+		return nil, fmt.Errorf("no JSON in Ollama response")
 	}
 
 	jsonStr := ollamaResp.Response[jsonStart:jsonEnd]
 	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
-		// If JSON parsing fails, fall back to programmatic analysis
-		return AnalyzeEmotionProgrammatically(content)
+		return nil, fmt.Errorf("failed to parse Ollama analysis: %v", err)
 	}
 
 	result.AnalysisMethod = "ai"
@@ -386,10 +402,119 @@ func generateAnalysisID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// SaveEmotionAnalysis saves emotion analysis result to database
-func SaveEmotionAnalysis(diaryID string, userID uint, result *EmotionAnalysisResult) error {
+// emotionPayload is the sealed envelope holding an emotion analysis
+type emotionPayload struct {
+	Joy             float64  `json:"joy"`
+	Sadness         float64  `json:"sadness"`
+	Anger           float64  `json:"anger"`
+	Fear            float64  `json:"fear"`
+	Love            float64  `json:"love"`
+	Surprise        float64  `json:"surprise"`
+	Disgust         float64  `json:"disgust"`
+	DominantEmotion string   `json:"dominantEmotion"`
+	Confidence      float64  `json:"confidence"`
+	SentimentScore  float64  `json:"sentimentScore"`
+	SentimentLabel  string   `json:"sentimentLabel"`
+	Keywords        []string `json:"keywords"`
+	AnalysisMethod  string   `json:"analysisMethod"`
+}
+
+// emotionAnalysisAAD binds an analysis ciphertext to its diary and user
+func emotionAnalysisAAD(diaryID string, userID uint) []byte {
+	return []byte(fmt.Sprintf("moodstack/emotion/v%d|%s|%d", ciphertextVersion, diaryID, userID))
+}
+
+// sealEmotionAnalysis encrypts the analysis fields into the payload and clears the plain columns
+func sealEmotionAnalysis(analysis *EmotionAnalysis, key []byte) error {
+	payload := emotionPayload{
+		Joy:             analysis.Joy,
+		Sadness:         analysis.Sadness,
+		Anger:           analysis.Anger,
+		Fear:            analysis.Fear,
+		Love:            analysis.Love,
+		Surprise:        analysis.Surprise,
+		Disgust:         analysis.Disgust,
+		DominantEmotion: analysis.DominantEmotion,
+		Confidence:      analysis.Confidence,
+		SentimentScore:  analysis.SentimentScore,
+		SentimentLabel:  analysis.SentimentLabel,
+		Keywords:        analysis.GetKeywords(),
+		AnalysisMethod:  analysis.AnalysisMethod,
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal emotion analysis: %v", err)
+	}
+
+	ciphertext, iv, err := EncryptDataWithAAD(data, key, emotionAnalysisAAD(analysis.DiaryID, analysis.UserID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt emotion analysis: %v", err)
+	}
+
+	analysis.EncryptedPayload = ciphertext
+	analysis.PayloadIV = base64.StdEncoding.EncodeToString(iv)
+	analysis.Joy, analysis.Sadness, analysis.Anger, analysis.Fear = 0, 0, 0, 0
+	analysis.Love, analysis.Surprise, analysis.Disgust = 0, 0, 0
+	analysis.DominantEmotion = ""
+	analysis.Confidence = 0
+	analysis.SentimentScore = 0
+	analysis.SentimentLabel = ""
+	analysis.Keywords = ""
+	analysis.AnalysisMethod = ""
+	return nil
+}
+
+// openEmotionAnalysis decrypts the payload into the analysis fields.
+// Rows that have not been migrated yet are returned unchanged.
+func openEmotionAnalysis(analysis *EmotionAnalysis, key []byte) error {
+	if analysis.PayloadIV == "" {
+		return nil
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(analysis.PayloadIV)
+	if err != nil {
+		return fmt.Errorf("failed to decode IV: %v", err)
+	}
+
+	data, err := DecryptDataWithAAD(analysis.EncryptedPayload, key, iv, emotionAnalysisAAD(analysis.DiaryID, analysis.UserID))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt emotion analysis: %v", err)
+	}
+
+	var payload emotionPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("failed to parse emotion analysis: %v", err)
+	}
+
+	analysis.Joy = payload.Joy
+	analysis.Sadness = payload.Sadness
+	analysis.Anger = payload.Anger
+	analysis.Fear = payload.Fear
+	analysis.Love = payload.Love
+	analysis.Surprise = payload.Surprise
+	analysis.Disgust = payload.Disgust
+	analysis.DominantEmotion = payload.DominantEmotion
+	analysis.Confidence = payload.Confidence
+	analysis.SentimentScore = payload.SentimentScore
+	analysis.SentimentLabel = payload.SentimentLabel
+	analysis.AnalysisMethod = payload.AnalysisMethod
+	return analysis.SetKeywords(payload.Keywords)
+}
+
+// SaveEmotionAnalysis encrypts and saves emotion analysis result to database
+func SaveEmotionAnalysis(diaryID string, userID uint, result *EmotionAnalysisResult, key []byte) error {
+	// Reuse the existing row so re-analysis does not violate the unique diary index
+	analysisID := generateAnalysisID()
+	createdAt := time.Now()
+	var existing EmotionAnalysis
+	if err := gormDB.Select("id, created_at").Where("diary_id = ? AND user_id = ?", diaryID, userID).First(&existing).Error; err == nil {
+		analysisID = existing.ID
+		createdAt = existing.CreatedAt
+	}
+
 	analysis := &EmotionAnalysis{
-		ID:              generateAnalysisID(),
+		ID:              analysisID,
 		DiaryID:         diaryID,
 		UserID:          userID,
 		Joy:             result.Joy,
@@ -404,7 +529,7 @@ func SaveEmotionAnalysis(diaryID string, userID uint, result *EmotionAnalysisRes
 		SentimentScore:  result.SentimentScore,
 		SentimentLabel:  result.SentimentLabel,
 		AnalysisMethod:  result.AnalysisMethod,
-		CreatedAt:       time.Now(),
+		CreatedAt:       createdAt,
 		UpdatedAt:       time.Now(),
 	}
 
@@ -412,6 +537,13 @@ func SaveEmotionAnalysis(diaryID string, userID uint, result *EmotionAnalysisRes
 		return fmt.Errorf("failed to set keywords: %v", err)
 	}
 
+	if err := sealEmotionAnalysis(analysis, key); err != nil {
+		return err
+	}
+	if err := sealEmotionTimeline(analysis, result.Timeline, key); err != nil {
+		return err
+	}
+
 	// Use GORM's Save method which handles both create and update
 	if err := gormDB.Save(analysis).Error; err != nil {
 		return fmt.Errorf("failed to save emotion analysis: %v", err)
@@ -421,7 +553,7 @@ func SaveEmotionAnalysis(diaryID string, userID uint, result *EmotionAnalysisRes
 }
 
 // GetEmotionAnalysis gets emotion analysis for a diary
-func GetEmotionAnalysis(diaryID string, userID uint) (*EmotionAnalysis, error) {
+func GetEmotionAnalysis(diaryID string, userID uint, key []byte) (*EmotionAnalysis, error) {
 	var analysis EmotionAnalysis
 	if err := gormDB.Where("diary_id = ? AND user_id = ?", diaryID, userID).First(&analysis).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -430,14 +562,20 @@ func GetEmotionAnalysis(diaryID string, userID uint) (*EmotionAnalysis, error) {
 		return nil, fmt.Errorf("failed to get emotion analysis: %v", err)
 	}
 
+	if err := openEmotionAnalysis(&analysis, key); err != nil {
+		return nil, err
+	}
+
 	return &analysis, nil
 }
 
 // GetUserEmotionTrends gets emotion trends for a user over time
-func GetUserEmotionTrends(userID uint, days int) ([]EmotionAnalysis, error) {
+func GetUserEmotionTrends(userID uint, days int, key []byte) ([]EmotionAnalysis, error) {
 	var analyses []EmotionAnalysis
 
-	query := gormDB.Where("user_id = ?", userID)
+	// Leave out analyses of diaries in the trash
+	trashed := gormDB.Unscoped().Model(&EncryptedDiary{}).Select("id").Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	query := gormDB.Where("user_id = ? AND diary_id NOT IN (?)", userID, trashed)
 	if days > 0 {
 		since := time.Now().AddDate(0, 0, -days)
 		query = query.Where("created_at >= ?", since)
@@ -447,12 +585,45 @@ func GetUserEmotionTrends(userID uint, days int) ([]EmotionAnalysis, error) {
 		return nil, fmt.Errorf("failed to get emotion trends: %v", err)
 	}
 
-	return analyses, nil
+	// Decrypt in memory, skipping rows that cannot be opened
+	decrypted := make([]EmotionAnalysis, 0, len(analyses))
+	for _, analysis := range analyses {
+		if err := openEmotionAnalysis(&analysis, key); err != nil {
+			continue
+		}
+		decrypted = append(decrypted, analysis)
+	}
+
+	return decrypted, nil
+}
+
+// migrateEmotionAnalyses seals emotion analyses that are still stored in plain text
+func migrateEmotionAnalyses(userID uint, key []byte) error {
+	var analyses []EmotionAnalysis
+	if err := gormDB.Where("user_id = ? AND (payload_iv IS NULL OR payload_iv = '')", userID).Find(&analyses).Error; err != nil {
+		return fmt.Errorf("failed to query emotion analyses: %v", err)
+	}
+
+	if len(analyses) == 0 {
+		return nil
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		for _, analysis := range analyses {
+			if err := sealEmotionAnalysis(&analysis, key); err != nil {
+				return err
+			}
+			if err := tx.Save(&analysis).Error; err != nil {
+				return fmt.Errorf("failed to update emotion analysis %s: %v", analysis.ID, err)
+			}
+		}
+		return nil
+	})
 }
 
 // GetEmotionStatistics gets aggregated emotion statistics for a user
-func GetEmotionStatistics(userID uint, days int) (map[string]interface{}, error) {
-	analyses, err := GetUserEmotionTrends(userID, days)
+func GetEmotionStatistics(userID uint, days int, key []byte) (map[string]interface{}, error) {
+	analyses, err := GetUserEmotionTrends(userID, days, key)
 	if err != nil {
 		return nil, err
 	}
@@ -543,88 +714,27 @@ func GetEmotionStatistics(userID uint, days int) (map[string]interface{}, error)
 	}, nil
 }
 
-// AnalyzeDiaryEmotion 分析日记情绪（主要接口，推荐使用）
-// 自动使用最佳的分析方法，提供准确的情绪分析结果
-func AnalyzeDiaryEmotion(diaryID string, userID uint, content string, useAI bool, ollamaURL string) (*EmotionAnalysisResult, error) {
-	// 直接使用增强版分析，它包含了所有优化和改进
-	return AnalyzeDiaryEmotionEnhanced(diaryID, userID, content, useAI, ollamaURL)
-}
-
-// AnalyzeDiaryEmotionBasic 基础版情绪分析（仅在需要简单分析时使用）
-func AnalyzeDiaryEmotionBasic(diaryID string, userID uint, content string, useAI bool, ollamaURL string) (*EmotionAnalysisResult, error) {
-	// Check if analysis already exists
-	existing, err := GetEmotionAnalysis(diaryID, userID)
+// AnalyzeDiaryEmotion 分析日记情绪并保存结果（主要接口）
+// 使用设置中的分析流程，未设置时使用 DefaultEmotionPipeline
+func AnalyzeDiaryEmotion(ctx context.Context, diaryID string, userID uint, content string, useAI bool, ollamaURL string, key []byte) (*EmotionAnalysisResult, error) {
+	config, err := GetEmotionPipeline()
 	if err != nil {
 		return nil, err
 	}
 
-	var result *EmotionAnalysisResult
-
-	if useAI {
-		result, err = AnalyzeEmotionWithAI(content, ollamaURL)
-		if err != nil {
-			// Fall back to programmatic analysis if AI fails
-			result, err = AnalyzeEmotionProgrammatically(content)
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		result, err = AnalyzeEmotionProgrammatically(content)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Save the analysis
-	if err := SaveEmotionAnalysis(diaryID, userID, result); err != nil {
-		return nil, err
-	}
-
-	// If there was an existing analysis, we've updated it
-	if existing != nil {
-		result.AnalysisMethod = fmt.Sprintf("%s (updated)", result.AnalysisMethod)
-	}
-
-	return result, nil
-}
-
-// AnalyzeDiaryEmotionEnhanced 使用增强版分析引擎（推荐使用）
-// 这是主要的情绪分析接口，提供最准确的分析结果
-func AnalyzeDiaryEmotionEnhanced(diaryID string, userID uint, content string, useAI bool, ollamaURL string) (*EmotionAnalysisResult, error) {
-	// 首先尝试使用增强版分析
-	if enhancedResult, err := performEnhancedAnalysis(content, useAI, ollamaURL); err == nil {
-		// 保存分析结果
-		if saveErr := SaveEmotionAnalysis(diaryID, userID, enhancedResult); saveErr != nil {
-			return nil, saveErr
-		}
-		return enhancedResult, nil
-	}
-
-	// 如果增强版失败，回退到基础版本
-	return AnalyzeDiaryEmotionBasic(diaryID, userID, content, useAI, ollamaURL)
-}
-
-// performEnhancedAnalysis 执行增强版情绪分析
-func performEnhancedAnalysis(content string, useAI bool, ollamaURL string) (*EmotionAnalysisResult, error) {
-	// 初始化增强版词典
-	if err := initializeEnhancedDictionary(); err != nil {
+	pipeline, err := NewEmotionPipeline(*config, AnalyzerOptions{UseAI: useAI, OllamaURL: ollamaURL})
+	if err != nil {
 		return nil, err
 	}
 
-	// 执行增强版分析
-	result, err := analyzeWithEnhancedEngine(content)
+	result, err := pipeline.Analyze(ctx, content)
 	if err != nil {
 		return nil, err
 	}
 
-	// 如果启用AI，进行混合分析
-	if useAI {
-		if aiResult, aiErr := AnalyzeEmotionWithAI(content, ollamaURL); aiErr == nil {
-			result = blendResults(result, aiResult)
-		}
+	if err := SaveEmotionAnalysis(diaryID, userID, result, key); err != nil {
+		return nil, err
 	}
-
 	return result, nil
 }
 
@@ -632,12 +742,14 @@ func performEnhancedAnalysis(content string, useAI bool, ollamaURL string) (*Emo
 
 // EnhancedDictionary 增强版情绪词典结构
 type EnhancedDictionary struct {
-	Emotions         map[string]EnhancedCategory `json:"emotions"`
-	ContextModifiers ContextModifiers            `json:"context_modifiers"`
+	Emotions         map[string]EnhancedCategory  `json:"emotions"`
+	Sentiment        map[string]SentimentCategory `json:"sentiment"`
+	ContextModifiers ContextModifiers             `json:"context_modifiers"`
 }
 
 // EnhancedCategory 增强版情绪类别
 type EnhancedCategory struct {
+	Polarity  *float64        `json:"polarity"` // 情绪的情感倾向：1 积极，-1 消极，0 中性；缺失时按中性处理并给出警告
 	Keywords  []string        `json:"keywords"`
 	Intensity IntensityLevels `json:"intensity"`
 }
@@ -651,9 +763,11 @@ type IntensityLevels struct {
 
 // ContextModifiers 上下文修饰符
 type ContextModifiers struct {
-	Negation     []string `json:"negation"`
-	Intensifiers []string `json:"intensifiers"`
-	Diminishers  []string `json:"diminishers"`
+	Negation         []string `json:"negation"`
+	EmphaticNegation []string `json:"emphatic_negation"` // 加强的否定，如"一点也不"
+	Intensifiers     []string `json:"intensifiers"`
+	Diminishers      []string `json:"diminishers"`
+	Window           int      `json:"window"` // 修饰符作用于其后多少个词元内的关键词
 }
 
 // KeywordMatch 关键词匹配结果
@@ -664,11 +778,16 @@ type KeywordMatch struct {
 	Weight    float64 `json:"weight"`
 	Modified  bool    `json:"modified"`
 	Modifier  string  `json:"modifier"`
+	Polarity  float64 `json:"polarity"` // 情感倾向，来自情绪或情感词典
+	Position  int     `json:"position"` // 首个词元的序号
+	Start     int     `json:"start"`    // 在内容中的字符偏移
+	End       int     `json:"end"`
 }
 
 var (
-	enhancedDict *EnhancedDictionary
-	regexCache   = make(map[string]*regexp.Regexp)
+	enhancedDict     *EnhancedDictionary
+	enhancedDictOnce sync.Once
+	regexCache       = make(map[string]*regexp.Regexp)
 
 	// 权重配置
 	intensityWeights = map[string]float64{
@@ -682,30 +801,56 @@ var (
 		"intensifier": 1.5,
 		"diminisher":  0.5,
 	}
+
+	// 情绪关键词作为情感证据时相对情感词典的权重
+	emotionEvidenceWeight = 0.8
 )
 
 // initializeEnhancedDictionary 初始化增强版词典
 func initializeEnhancedDictionary() error {
-	if enhancedDict != nil {
-		return nil // 已经初始化
-	}
+	enhancedDictOnce.Do(func() {
+		// 尝试加载外部词典文件
+		possiblePaths := []string{
+			"emotion_dictionaries/emotion_keywords.json",
+			"./emotion_keywords.json",
+		}
 
-	// 尝试加载外部词典文件
-	possiblePaths := []string{
-		"emotion_dictionaries/emotion_keywords.json",
-		"./emotion_keywords.json",
-	}
+		for _, path := range possiblePaths {
+			if dict, err := loadEnhancedDictionary(path); err == nil {
+				enhancedDict = dict
+				warnMissingPolarities(enhancedDict)
+				return
+			}
+		}
 
-	for _, path := range possiblePaths {
-		if dict, err := loadEnhancedDictionary(path); err == nil {
-			enhancedDict = dict
-			return nil
+		// 使用内置词典
+		enhancedDict = buildEnhancedDictionary()
+		warnMissingPolarities(enhancedDict)
+	})
+	return nil
+}
+
+// warnMissingPolarities 提示词典中未声明情感倾向的类别。
+// 缺少倾向的情绪按中性处理，缺少倾向的情感类别不参与情感计算。
+func warnMissingPolarities(dict *EnhancedDictionary) {
+	for emotion, category := range dict.Emotions {
+		if category.Polarity == nil {
+			fmt.Printf("Warning: emotion %q has no polarity in the dictionary, treating it as neutral\n", emotion)
 		}
 	}
+	for label, category := range dict.Sentiment {
+		if category.Polarity == nil {
+			fmt.Printf("Warning: sentiment %q has no polarity in the dictionary, ignoring its keywords\n", label)
+		}
+	}
+}
 
-	// 使用内置词典
-	enhancedDict = buildEnhancedDictionary()
-	return nil
+// polarityOrNeutral 返回声明的情感倾向，缺失时为中性
+func polarityOrNeutral(polarity *float64) float64 {
+	if polarity == nil {
+		return 0
+	}
+	return *polarity
 }
 
 // loadEnhancedDictionary 加载增强版词典文件
@@ -739,21 +884,25 @@ func buildEnhancedDictionary() *EnhancedDictionary {
 		return &EnhancedDictionary{
 			Emotions: make(map[string]EnhancedCategory),
 			ContextModifiers: ContextModifiers{
-				Negation:     []string{"不", "没", "无", "非", "未", "not", "no", "never", "none"},
-				Intensifiers: []string{"非常", "特别", "极其", "超级", "相当", "很", "十分", "extremely", "very", "really", "quite", "so", "too"},
-				Diminishers:  []string{"有点", "稍微", "略", "轻微", "一点", "slightly", "somewhat", "a bit", "a little", "kind of"},
+				Negation:         []string{"不", "没", "没有", "不是", "无", "非", "未", "not", "no", "never", "none"},
+				EmphaticNegation: []string{"一点也不", "一点都不", "根本不", "完全不", "毫不", "not at all"},
+				Intensifiers:     []string{"非常", "特别", "极其", "超级", "相当", "很", "太", "十分", "extremely", "very", "really", "quite", "so", "too"},
+				Diminishers:      []string{"有点", "稍微", "略", "轻微", "一点", "slightly", "somewhat", "a bit", "a little", "kind of"},
+				Window:           defaultModifierWindow,
 			},
 		}
 	}
 
 	dict := &EnhancedDictionary{
 		Emotions:         make(map[string]EnhancedCategory),
+		Sentiment:        dictionaryData.Sentiment,
 		ContextModifiers: dictionaryData.ContextModifiers,
 	}
 
 	// 直接使用JSON文件中的数据
 	for emotion, category := range dictionaryData.Emotions {
 		dict.Emotions[emotion] = EnhancedCategory{
+			Polarity:  category.Polarity,
 			Keywords:  category.Keywords,
 			Intensity: category.Intensity,
 		}
@@ -768,17 +917,34 @@ func analyzeWithEnhancedEngine(content string) (*EmotionAnalysisResult, error) {
 		return nil, fmt.Errorf("enhanced dictionary not initialized")
 	}
 
-	content = strings.ToLower(content)
-	words := strings.Fields(content)
-	totalWords := len(words)
+	// 在原文上分词，词元位置始终对应原文；匹配时使用小写的词元文本
+	tokens := lowerTokens(getEmotionTokenizer().Tokenize(content))
+	totalWords := wordTokens(tokens)
 
 	if totalWords == 0 {
 		totalWords = 1
 	}
 
-	// 查找关键词匹配
-	matches := findEnhancedMatches(content, words, enhancedDict)
+	// 查找情绪关键词和情感词语
+	matcher := newKeywordMatcher(tokens, enhancedDict)
+	matches := findEnhancedMatches(matcher, enhancedDict)
+	sentimentMatches := findSentimentMatches(matcher, enhancedDict)
 
+	result := scoreEnhancedMatches(matches, sentimentMatches, totalWords)
+	result.Timeline = emotionTimeline(content, tokens, matches, sentimentMatches)
+	return result, nil
+}
+
+// lowerTokens 将词元文本转为小写，位置保持不变
+func lowerTokens(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Text = strings.ToLower(tokens[i].Text)
+	}
+	return tokens
+}
+
+// scoreEnhancedMatches 根据关键词匹配计算情绪和情感分数
+func scoreEnhancedMatches(matches, sentimentMatches []KeywordMatch, totalWords int) *EmotionAnalysisResult {
 	// 计算情绪分数
 	emotions := map[string]float64{
 		"joy": 0, "sadness": 0, "anger": 0, "fear": 0,
@@ -788,8 +954,11 @@ func analyzeWithEnhancedEngine(content string) (*EmotionAnalysisResult, error) {
 	var allKeywords []string
 
 	for _, match := range matches {
-		emotions[match.Emotion] += math.Abs(match.Weight) / float64(totalWords) * 10
-		allKeywords = append(allKeywords, match.Keyword)
+		// 被否定的关键词不计入其情绪，也不作为关键词
+		if match.Weight > 0 {
+			emotions[match.Emotion] += match.Weight / float64(totalWords) * 10
+			allKeywords = append(allKeywords, match.Keyword)
+		}
 	}
 
 	// 标准化分数
@@ -800,7 +969,7 @@ func analyzeWithEnhancedEngine(content string) (*EmotionAnalysisResult, error) {
 	}
 
 	// 计算情感分数
-	sentimentScore := calculateSentiment(matches, totalWords)
+	sentimentScore := calculateSentiment(matches, sentimentMatches, totalWords)
 	var sentimentLabel string
 	if sentimentScore > 0.1 {
 		sentimentLabel = "positive"
@@ -842,63 +1011,145 @@ func analyzeWithEnhancedEngine(content string) (*EmotionAnalysisResult, error) {
 		SentimentLabel:  sentimentLabel,
 		Keywords:        allKeywords,
 		AnalysisMethod:  "enhanced",
-	}, nil
+	}
 }
 
-// findEnhancedMatches 查找增强版关键词匹配
-func findEnhancedMatches(content string, words []string, dict *EnhancedDictionary) []KeywordMatch {
+// keywordMatcher 在分词后的内容中查找关键词，并应用其前的修饰符
+type keywordMatcher struct {
+	tokens    []Token
+	positions map[string][]int // 各词元出现的位置
+	tokenizer Tokenizer
+	modifiers []contextModifier
+	window    int
+}
+
+// newKeywordMatcher 为分词后的内容创建关键词匹配器
+func newKeywordMatcher(tokens []Token, dict *EnhancedDictionary) *keywordMatcher {
+	tokenizer := getEmotionTokenizer()
+	window := dict.ContextModifiers.Window
+	if window <= 0 {
+		window = defaultModifierWindow
+	}
+
+	// 按词元建立位置索引
+	positions := make(map[string][]int)
+	for i, token := range tokens {
+		positions[token.Text] = append(positions[token.Text], i)
+	}
+
+	return &keywordMatcher{
+		tokens:    tokens,
+		positions: positions,
+		tokenizer: tokenizer,
+		modifiers: modifierPhrases(dict.ContextModifiers, tokenizer),
+		window:    window,
+	}
+}
+
+// match 返回关键词的每次出现，template 提供除位置外的字段
+func (m *keywordMatcher) match(keyword string, template KeywordMatch) []KeywordMatch {
+	// 关键词按同样的方式分词，匹配连续的词元序列
+	keywordTokens := m.tokenizer.Tokenize(keyword)
+	if len(keywordTokens) == 0 {
+		return nil
+	}
+
+	var matches []KeywordMatch
+	for _, position := range m.positions[keywordTokens[0].Text] {
+		if !tokensMatchAt(m.tokens, position, keywordTokens) {
+			continue
+		}
+
+		match := template
+		match.Keyword = keyword
+		match.Position = position
+		match.Start = m.tokens[position].Start
+		match.End = m.tokens[position+len(keywordTokens)-1].End
+
+		// 应用上下文修饰符
+		matches = append(matches, applyContextModifiers(match, m.tokens, m.modifiers, m.window))
+	}
+	return matches
+}
+
+// findEnhancedMatches 查找情绪关键词，关键词的每次出现都是一个匹配
+func findEnhancedMatches(matcher *keywordMatcher, dict *EnhancedDictionary) []KeywordMatch {
 	var matches []KeywordMatch
 
-	// 查找情绪关键词
 	for emotion, category := range dict.Emotions {
-		// 检查所有关键词
 		for _, keyword := range category.Keywords {
 			keyword = strings.ToLower(keyword)
 
-			// 使用改进的匹配逻辑
-			var matched bool
-			var positions []int
+			// 确定强度级别
+			intensity := getKeywordIntensity(keyword, category)
 
-			if containsChinese(keyword) || containsChinese(content) {
-				// 对于中文，使用简单的包含匹配
-				if strings.Contains(content, keyword) {
-					matched = true
-					// 找到所有匹配位置（简化处理）
-					positions = append(positions, 0)
-				}
-			} else {
-				// 对于英文，使用词边界匹配
-				pattern := `\b` + regexp.QuoteMeta(keyword) + `\b`
-				if m, _ := regexp.MatchString(pattern, content); m {
-					matched = true
-					positions = append(positions, 0)
-				}
-			}
+			matches = append(matches, matcher.match(keyword, KeywordMatch{
+				Emotion:   emotion,
+				Intensity: intensity,
+				Weight:    intensityWeights[intensity],
+				Polarity:  polarityOrNeutral(category.Polarity),
+			})...)
+		}
+	}
 
-			if matched {
-				for range positions {
-					// 确定强度级别
-					intensity := getKeywordIntensity(keyword, category)
-
-					match := KeywordMatch{
-						Keyword:   keyword,
-						Emotion:   emotion,
-						Intensity: intensity,
-						Weight:    intensityWeights[intensity],
-						Modified:  false,
-					}
-
-					// 应用上下文修饰符（简化版）
-					match = applyContextModifiersSimple(match, content, keyword, dict.ContextModifiers)
-					matches = append(matches, match)
-				}
-			}
+	sortMatches(matches)
+	return matches
+}
+
+// findSentimentMatches 查找情感词典中的词语，情感倾向取自各类别的 polarity
+func findSentimentMatches(matcher *keywordMatcher, dict *EnhancedDictionary) []KeywordMatch {
+	var matches []KeywordMatch
+
+	for _, category := range dict.Sentiment {
+		if category.Polarity == nil {
+			continue
+		}
+		for _, keyword := range category.Keywords {
+			matches = append(matches, matcher.match(strings.ToLower(keyword), KeywordMatch{
+				Intensity: "medium",
+				Weight:    intensityWeights["medium"],
+				Polarity:  *category.Polarity,
+			})...)
 		}
 	}
 
+	sortMatches(matches)
 	return matches
 }
 
+// sortMatches 按出现顺序排列匹配
+func sortMatches(matches []KeywordMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Position != matches[j].Position {
+			return matches[i].Position < matches[j].Position
+		}
+		return matches[i].Emotion < matches[j].Emotion
+	})
+}
+
+// tokensMatchAt 检查词元序列是否从指定位置开始出现
+func tokensMatchAt(tokens []Token, position int, sequence []Token) bool {
+	if position+len(sequence) > len(tokens) {
+		return false
+	}
+	for i, token := range sequence {
+		if tokens[position+i].Text != token.Text {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapsAny 检查匹配是否与其中任一匹配重叠
+func overlapsAny(match KeywordMatch, others []KeywordMatch) bool {
+	for _, other := range others {
+		if match.Start < other.End && other.Start < match.End {
+			return true
+		}
+	}
+	return false
+}
+
 // getKeywordIntensity 获取关键词强度级别
 func getKeywordIntensity(keyword string, category EnhancedCategory) string {
 	for _, highKeyword := range category.Intensity.High {
@@ -914,107 +1165,177 @@ func getKeywordIntensity(keyword string, category EnhancedCategory) string {
 	return "medium"
 }
 
-// applyContextModifiersToMatch 应用上下文修饰符
-func applyContextModifiersToMatch(match KeywordMatch, words []string, position int, modifiers ContextModifiers) KeywordMatch {
-	// 检查否定词
-	if hasModifierNear(words, position, modifiers.Negation, 3) {
-		match.Weight *= modifierWeights["negation"]
-		match.Modified = true
-		match.Modifier = "negation"
-		return match
-	}
+const (
+	// defaultModifierWindow 词典未配置时修饰符的作用范围（词元数）
+	defaultModifierWindow = 4
 
-	// 检查强化词
-	if hasModifierNear(words, position, modifiers.Intensifiers, 2) {
-		match.Weight *= modifierWeights["intensifier"]
-		match.Modified = true
-		match.Modifier = "intensifier"
-		return match
-	}
+	// maxModifiedWeight 叠加修饰后权重绝对值的上限
+	maxModifiedWeight = 2.0
+)
 
-	// 检查弱化词
-	if hasModifierNear(words, position, modifiers.Diminishers, 2) {
-		match.Weight *= modifierWeights["diminisher"]
-		match.Modified = true
-		match.Modifier = "diminisher"
-		return match
-	}
+// clauseBoundaries 结束修饰符作用范围的标点
+var clauseBoundaries = map[string]bool{
+	"，": true, "。": true, "！": true, "？": true, "；": true, "：": true, "…": true,
+	",": true, ".": true, "!": true, "?": true, ";": true, ":": true, "\n": true,
+}
 
-	return match
+// contextModifier 分词后的修饰词
+type contextModifier struct {
+	tokens []string
+	kind   string // negation, emphatic_negation, intensifier, diminisher
 }
 
-// applyContextModifiersSimple 简化版上下文修饰符应用
-func applyContextModifiersSimple(match KeywordMatch, content string, keyword string, modifiers ContextModifiers) KeywordMatch {
-	// 检查否定词
-	for _, negation := range modifiers.Negation {
-		negation = strings.ToLower(negation)
-		// 检查否定词是否在关键词附近
-		if strings.Contains(content, negation+keyword) || strings.Contains(content, negation+" "+keyword) {
-			match.Weight *= modifierWeights["negation"]
-			match.Modified = true
-			match.Modifier = "negation"
-			return match
+// modifierPhrases 将修饰词按情绪引擎的方式分词，较长的在前以便优先匹配
+func modifierPhrases(modifiers ContextModifiers, tokenizer Tokenizer) []contextModifier {
+	lists := []struct {
+		kind  string
+		words []string
+	}{
+		{"emphatic_negation", modifiers.EmphaticNegation},
+		{"negation", modifiers.Negation},
+		{"intensifier", modifiers.Intensifiers},
+		{"diminisher", modifiers.Diminishers},
+	}
+
+	var phrases []contextModifier
+	for _, list := range lists {
+		for _, word := range list.words {
+			var texts []string
+			for _, token := range tokenizer.Tokenize(strings.ToLower(word)) {
+				texts = append(texts, token.Text)
+			}
+			if len(texts) > 0 {
+				phrases = append(phrases, contextModifier{tokens: texts, kind: list.kind})
+			}
 		}
 	}
 
-	// 检查强化词
-	for _, intensifier := range modifiers.Intensifiers {
-		intensifier = strings.ToLower(intensifier)
-		if strings.Contains(content, intensifier+keyword) || strings.Contains(content, intensifier+" "+keyword) {
-			match.Weight *= modifierWeights["intensifier"]
-			match.Modified = true
-			match.Modifier = "intensifier"
-			return match
-		}
-	}
+	sort.SliceStable(phrases, func(i, j int) bool {
+		return len(phrases[i].tokens) > len(phrases[j].tokens)
+	})
+	return phrases
+}
 
-	// 检查弱化词
-	for _, diminisher := range modifiers.Diminishers {
-		diminisher = strings.ToLower(diminisher)
-		if strings.Contains(content, diminisher+keyword) || strings.Contains(content, diminisher+" "+keyword) {
-			match.Weight *= modifierWeights["diminisher"]
-			match.Modified = true
-			match.Modifier = "diminisher"
-			return match
+// modifierEndingAt 返回以第 end 个词元结尾的修饰词
+func modifierEndingAt(tokens []Token, end int, phrases []contextModifier) (contextModifier, bool) {
+	for _, phrase := range phrases {
+		start := end - len(phrase.tokens) + 1
+		if start < 0 {
+			continue
+		}
+		matched := true
+		for i, text := range phrase.tokens {
+			if tokens[start+i].Text != text {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return phrase, true
 		}
 	}
-
-	return match
+	return contextModifier{}, false
 }
 
-// hasModifierNear 检查附近是否有修饰符
-func hasModifierNear(words []string, position int, modifiers []string, distance int) bool {
-	start := maxInt(0, position-distance)
-	end := minInt(len(words), position+distance+1)
-
-	for i := start; i < end; i++ {
-		if i == position {
+// applyContextModifiers 应用关键词前的修饰符
+//
+// 修饰符从关键词向前逐个生效，只作用于紧邻关键词的修饰词：遇到其他词语、
+// 子句边界的标点或超出窗口的词元即止：
+//
+//	非常非常难过         强化词叠加
+//	非常不开心           否定后再强化，更强的反向情绪
+//	不太开心             否定被强化的词，较弱的反向情绪
+//	不是不开心           双重否定，较弱的原情绪
+//	一点也不开心         加强的否定
+//	我不知道他为什么开心  否定的是"知道"，"开心"不受影响
+func applyContextModifiers(match KeywordMatch, tokens []Token, phrases []contextModifier, window int) KeywordMatch {
+	base := math.Abs(match.Weight)
+	weight := match.Weight
+	negated := false
+	var applied []string
+
+	for i, words := match.Position-1, 0; i >= 0 && words < window; {
+		if tokens[i].Punct {
+			if clauseBoundaries[tokens[i].Text] {
+				break
+			}
+			i--
 			continue
 		}
-		for _, modifier := range modifiers {
-			if strings.EqualFold(words[i], modifier) {
-				return true
+
+		// 修饰符的作用范围止于其他词语
+		modifier, ok := modifierEndingAt(tokens, i, phrases)
+		if !ok {
+			break
+		}
+
+		kind := modifier.kind
+		switch kind {
+		case "negation":
+			if negated {
+				kind = "double_negation"
+				weight = -weight * modifierWeights["diminisher"]
+			} else if math.Abs(weight) > base {
+				weight = -base * modifierWeights["diminisher"]
+			} else {
+				weight *= modifierWeights["negation"]
 			}
+			negated = !negated
+		case "emphatic_negation":
+			weight *= modifierWeights["negation"] * modifierWeights["intensifier"]
+			negated = !negated
+		case "intensifier", "diminisher":
+			weight *= modifierWeights[kind]
 		}
+		applied = append(applied, kind)
+
+		i -= len(modifier.tokens)
+		words += len(modifier.tokens)
 	}
-	return false
+
+	if len(applied) == 0 {
+		return match
+	}
+
+	match.Weight = math.Max(-maxModifiedWeight, math.Min(maxModifiedWeight, weight))
+	match.Modified = true
+	match.Modifier = strings.Join(applied, "+")
+	return match
 }
 
 // calculateSentiment 计算情感分数
-func calculateSentiment(matches []KeywordMatch, totalWords int) float64 {
-	positiveScore := 0.0
-	negativeScore := 0.0
-
+//
+// 情感词典中的词语直接计入，情绪关键词按其情绪的情感倾向计入，权重较低。
+// 两者重叠的词语（如"开心"）只按情感词典计算一次；同时属于多种情绪的关键词
+// 取各情绪的平均。修饰符已体现在权重的正负和大小中，否定会反转情感倾向。
+func calculateSentiment(matches, sentimentMatches []KeywordMatch, totalWords int) float64 {
+	score := 0.0
+	for _, match := range sentimentMatches {
+		score += match.Polarity * match.Weight
+	}
+
+	type span struct{ start, end int }
+	evidence := make(map[span][]float64)
+	var spans []span
 	for _, match := range matches {
-		switch match.Emotion {
-		case "joy", "love", "surprise":
-			positiveScore += math.Abs(match.Weight)
-		case "sadness", "anger", "fear", "disgust":
-			negativeScore += math.Abs(match.Weight)
+		if overlapsAny(match, sentimentMatches) {
+			continue
+		}
+		key := span{match.Start, match.End}
+		if _, ok := evidence[key]; !ok {
+			spans = append(spans, key)
 		}
+		evidence[key] = append(evidence[key], match.Polarity*match.Weight)
+	}
+	for _, key := range spans {
+		sum := 0.0
+		for _, value := range evidence[key] {
+			sum += value
+		}
+		score += emotionEvidenceWeight * sum / float64(len(evidence[key]))
 	}
 
-	sentimentScore := (positiveScore - negativeScore) / float64(totalWords) * 10
+	sentimentScore := score / float64(totalWords) * 10
 
 	if sentimentScore > 1 {
 		sentimentScore = 1
@@ -1025,73 +1346,6 @@ func calculateSentiment(matches []KeywordMatch, totalWords int) float64 {
 	return sentimentScore
 }
 
-// blendResults 混合AI和增强版分析结果
-func blendResults(enhancedResult, aiResult *EmotionAnalysisResult) *EmotionAnalysisResult {
-	// 使用加权平均
-	aiWeight := 0.6
-	enhancedWeight := 0.4
-
-	result := &EmotionAnalysisResult{
-		Joy:            enhancedResult.Joy*enhancedWeight + aiResult.Joy*aiWeight,
-		Sadness:        enhancedResult.Sadness*enhancedWeight + aiResult.Sadness*aiWeight,
-		Anger:          enhancedResult.Anger*enhancedWeight + aiResult.Anger*aiWeight,
-		Fear:           enhancedResult.Fear*enhancedWeight + aiResult.Fear*aiWeight,
-		Love:           enhancedResult.Love*enhancedWeight + aiResult.Love*aiWeight,
-		Surprise:       enhancedResult.Surprise*enhancedWeight + aiResult.Surprise*aiWeight,
-		Disgust:        enhancedResult.Disgust*enhancedWeight + aiResult.Disgust*aiWeight,
-		Confidence:     enhancedResult.Confidence*enhancedWeight + aiResult.Confidence*aiWeight,
-		SentimentScore: enhancedResult.SentimentScore*enhancedWeight + aiResult.SentimentScore*aiWeight,
-		AnalysisMethod: "enhanced+ai",
-	}
-
-	// 重新确定主导情绪
-	emotions := map[string]float64{
-		"joy": result.Joy, "sadness": result.Sadness, "anger": result.Anger,
-		"fear": result.Fear, "love": result.Love, "surprise": result.Surprise, "disgust": result.Disgust,
-	}
-
-	var dominantEmotion string
-	var maxScore float64
-	for emotion, score := range emotions {
-		if score > maxScore {
-			maxScore = score
-			dominantEmotion = emotion
-		}
-	}
-
-	if dominantEmotion == "" || maxScore < 0.1 {
-		dominantEmotion = "neutral"
-	}
-
-	result.DominantEmotion = dominantEmotion
-
-	// 确定情感标签
-	if result.SentimentScore > 0.1 {
-		result.SentimentLabel = "positive"
-	} else if result.SentimentScore < -0.1 {
-		result.SentimentLabel = "negative"
-	} else {
-		result.SentimentLabel = "neutral"
-	}
-
-	// 合并关键词
-	keywordSet := make(map[string]bool)
-	for _, keyword := range enhancedResult.Keywords {
-		keywordSet[keyword] = true
-	}
-	for _, keyword := range aiResult.Keywords {
-		keywordSet[keyword] = true
-	}
-
-	var combinedKeywords []string
-	for keyword := range keywordSet {
-		combinedKeywords = append(combinedKeywords, keyword)
-	}
-	result.Keywords = combinedKeywords
-
-	return result
-}
-
 // 辅助函数
 func maxInt(a, b int) int {
 	if a > b {
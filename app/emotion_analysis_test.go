@@ -0,0 +1,179 @@
+package app
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// modifierTestTokenizer knows the words of the modifier examples
+func modifierTestTokenizer() *DictTokenizer {
+	tokenizer := NewDictTokenizer()
+	for _, word := range []string{"非常", "难过", "开心", "不是", "不", "太", "一点", "也", "我", "他", "知道", "为什么"} {
+		tokenizer.AddWord(word, defaultWordFreq)
+	}
+	return tokenizer
+}
+
+func TestApplyContextModifiers(t *testing.T) {
+	tokenizer := modifierTestTokenizer()
+	phrases := modifierPhrases(ContextModifiers{
+		Negation:         []string{"不", "不是"},
+		EmphaticNegation: []string{"一点也不"},
+		Intensifiers:     []string{"非常", "太"},
+		Diminishers:      []string{"一点"},
+	}, tokenizer)
+
+	tests := []struct {
+		text     string
+		keyword  string
+		weight   float64
+		modifier string
+	}{
+		{"非常非常难过", "难过", 1.35, "intensifier+intensifier"},
+		{"非常不开心", "开心", -0.9, "negation+intensifier"},
+		{"不太开心", "开心", -0.3, "intensifier+negation"},
+		{"不是不开心", "开心", 0.3, "negation+double_negation"},
+		{"一点也不开心", "开心", -0.9, "emphatic_negation"},
+		{"我不知道他为什么开心", "开心", 0.6, ""},
+		{"不，开心", "开心", 0.6, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			tokens := tokenizer.Tokenize(tt.text)
+			position := -1
+			for i, token := range tokens {
+				if token.Text == tt.keyword {
+					position = i
+				}
+			}
+			if position < 0 {
+				t.Fatalf("keyword %q not tokenized in %v", tt.keyword, tokens)
+			}
+
+			match := applyContextModifiers(KeywordMatch{Keyword: tt.keyword, Weight: 0.6, Position: position}, tokens, phrases, defaultModifierWindow)
+			if math.Abs(match.Weight-tt.weight) > 1e-9 {
+				t.Errorf("weight = %v, want %v", match.Weight, tt.weight)
+			}
+			if match.Modifier != tt.modifier {
+				t.Errorf("modifier = %q, want %q", match.Modifier, tt.modifier)
+			}
+			if match.Modified != (tt.modifier != "") {
+				t.Errorf("modified = %v, want %v", match.Modified, tt.modifier != "")
+			}
+		})
+	}
+}
+
+func TestScoreEnhancedMatchesSkipsNegatedKeywords(t *testing.T) {
+	matches := []KeywordMatch{
+		{Keyword: "开心", Emotion: "joy", Weight: -0.6, Polarity: 1},
+		{Keyword: "难过", Emotion: "sadness", Weight: 0.6, Polarity: -1, Start: 5, End: 7},
+	}
+
+	result := scoreEnhancedMatches(matches, nil, 4)
+	if len(result.Keywords) != 1 || result.Keywords[0] != "难过" {
+		t.Errorf("keywords = %v, want [难过]", result.Keywords)
+	}
+	if result.Joy != 0 {
+		t.Errorf("joy = %v, want 0", result.Joy)
+	}
+}
+
+func TestEnhancedTimelineOffsetsFollowOriginalText(t *testing.T) {
+	if err := initializeEnhancedDictionary(); err != nil {
+		t.Fatalf("initializeEnhancedDictionary: %v", err)
+	}
+
+	// Segment offsets index the original text, not its lowercased form
+	content := "İİİ Notes说明。今天非常开心。"
+	result, err := analyzeWithEnhancedEngine(content)
+	if err != nil {
+		t.Fatalf("analyzeWithEnhancedEngine: %v", err)
+	}
+	if len(result.Timeline) != 2 {
+		t.Fatalf("timeline has %d segments, want 2", len(result.Timeline))
+	}
+
+	checkTimelineSegments(t, content, result.Timeline, []string{"İİİ Notes说明", "今天非常开心"})
+}
+
+func TestEnhancedTimelineOffsetsAreUTF16(t *testing.T) {
+	if err := initializeEnhancedDictionary(); err != nil {
+		t.Fatalf("initializeEnhancedDictionary: %v", err)
+	}
+
+	// The emoji takes two UTF-16 code units but one code point
+	content := "😀 今天很好。明天非常开心。"
+	result, err := analyzeWithEnhancedEngine(content)
+	if err != nil {
+		t.Fatalf("analyzeWithEnhancedEngine: %v", err)
+	}
+	if len(result.Timeline) != 2 {
+		t.Fatalf("timeline has %d segments, want 2", len(result.Timeline))
+	}
+	if result.Timeline[1].Start != 8 {
+		t.Errorf("second segment starts at %d, want UTF-16 offset 8", result.Timeline[1].Start)
+	}
+
+	checkTimelineSegments(t, content, result.Timeline, []string{"😀 今天很好", "明天非常开心"})
+}
+
+// checkTimelineSegments slices content by the segments' UTF-16 offsets, as the frontend does
+func checkTimelineSegments(t *testing.T, content string, timeline []EmotionSegment, want []string) {
+	t.Helper()
+
+	units := utf16.Encode([]rune(content))
+	for i, segment := range timeline {
+		if segment.Start < 0 || segment.End > len(units) || segment.Start > segment.End {
+			t.Fatalf("segment %d range [%d, %d) is outside the text", i, segment.Start, segment.End)
+		}
+		if text := string(utf16.Decode(units[segment.Start:segment.End])); !strings.Contains(text, want[i]) {
+			t.Errorf("segment %d covers %q, want it to contain %q", i, text, want[i])
+		}
+	}
+}
+
+func TestSentimentPolarityComesFromDictionary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dict.json")
+	data := `{
+  "emotions": {"joy": {"keywords": ["开心"]}},
+  "sentiment": {
+    "upbeat": {"polarity": 1, "keywords": ["顺利"]},
+    "unlabeled": {"keywords": ["糟糕"]}
+  }
+}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	dict, err := loadEnhancedDictionary(path)
+	if err != nil {
+		t.Fatalf("loadEnhancedDictionary: %v", err)
+	}
+	if dict.Emotions["joy"].Polarity != nil {
+		t.Errorf("missing emotion polarity decoded as %v", *dict.Emotions["joy"].Polarity)
+	}
+
+	tokenizer := NewDictTokenizer()
+	for _, word := range []string{"开心", "顺利", "糟糕"} {
+		tokenizer.AddWord(word, defaultWordFreq)
+	}
+	SetEmotionTokenizer(tokenizer)
+	defer SetEmotionTokenizer(nil)
+
+	matcher := newKeywordMatcher(tokenizer.Tokenize("一切顺利，有点糟糕，很开心"), dict)
+	sentiment := findSentimentMatches(matcher, dict)
+	if len(sentiment) != 1 || sentiment[0].Keyword != "顺利" || sentiment[0].Polarity != 1 {
+		t.Errorf("sentiment matches = %+v, want only 顺利 with polarity 1", sentiment)
+	}
+
+	emotions := findEnhancedMatches(matcher, dict)
+	if len(emotions) != 1 || emotions[0].Polarity != 0 {
+		t.Errorf("emotion matches = %+v, want 开心 treated as neutral", emotions)
+	}
+}
@@ -0,0 +1,385 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Emotion analyzers
+//
+// An EmotionAnalyzer turns text into an EmotionAnalysisResult. Analyzers are
+// registered by name and combined by a pipeline, configured in the settings:
+//
+//	fallback   the first analyzer that succeeds provides the result
+//	ensemble   the results of all analyzers that succeed are blended by weight
+//
+// Either way, the pipeline's fallbacks are tried in order when none of its
+// analyzers succeed. An analyzer that is unavailable, such as the Ollama
+// analyzer when AI analysis is off, is left out of the pipeline.
+
+// EmotionAnalyzer analyzes the emotion of a text
+type EmotionAnalyzer interface {
+	Name() string
+	Version() string
+	Analyze(ctx context.Context, text string) (*EmotionAnalysisResult, error)
+}
+
+// AnalyzerOptions are the per-request options passed to analyzer factories
+type AnalyzerOptions struct {
+	UseAI     bool   // Whether analyzers calling an AI model may be used
+	OllamaURL string // Base URL of the Ollama API, the default when empty
+}
+
+// EmotionAnalyzerFactory creates an analyzer, or returns an error when it is unavailable with the options
+type EmotionAnalyzerFactory func(options AnalyzerOptions) (EmotionAnalyzer, error)
+
+// AnalyzerInfo describes a registered analyzer
+type AnalyzerInfo struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	RequireAI bool   `json:"requireAi"` // Only available when AI analysis is enabled
+}
+
+// Pipeline modes
+const (
+	PipelineFallback = "fallback"
+	PipelineEnsemble = "ensemble"
+)
+
+// EmotionPipelineConfig selects and combines the analyzers used for diaries
+type EmotionPipelineConfig struct {
+	Mode      string                `json:"mode"` // 'fallback' or 'ensemble'
+	Analyzers []EmotionPipelineStep `json:"analyzers"`
+	Fallbacks []string              `json:"fallbacks"` // Tried in order when no analyzer succeeds
+}
+
+// EmotionPipelineStep is an analyzer of a pipeline with its blending weight
+type EmotionPipelineStep struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"` // Ensemble weight, relative to the other analyzers
+}
+
+// DefaultEmotionPipeline blends the AI model with the enhanced engine when AI
+// analysis is on, and falls back to the basic engine
+var DefaultEmotionPipeline = EmotionPipelineConfig{
+	Mode: PipelineEnsemble,
+	Analyzers: []EmotionPipelineStep{
+		{Name: "ollama", Weight: 0.6},
+		{Name: "enhanced", Weight: 0.4},
+	},
+	Fallbacks: []string{"basic"},
+}
+
+// copyEmotionPipeline returns a copy of config that shares no slices with it
+func copyEmotionPipeline(config EmotionPipelineConfig) EmotionPipelineConfig {
+	config.Analyzers = append([]EmotionPipelineStep(nil), config.Analyzers...)
+	config.Fallbacks = append([]string(nil), config.Fallbacks...)
+	return config
+}
+
+// registeredAnalyzer is an analyzer factory with the description of its analyzers
+type registeredAnalyzer struct {
+	info    AnalyzerInfo
+	factory EmotionAnalyzerFactory
+}
+
+var (
+	emotionAnalyzersMu sync.RWMutex
+
+	// emotionAnalyzers holds the registered analyzers by name
+	emotionAnalyzers = map[string]registeredAnalyzer{
+		"basic":    {AnalyzerInfo{Name: "basic", Version: basicAnalyzerVersion}, newBasicAnalyzer},
+		"enhanced": {AnalyzerInfo{Name: "enhanced", Version: enhancedAnalyzerVersion}, newEnhancedAnalyzer},
+		"ollama":   {AnalyzerInfo{Name: "ollama", Version: ollamaModel, RequireAI: true}, newOllamaAnalyzer},
+	}
+)
+
+// RegisterEmotionAnalyzer registers an analyzer factory under info.Name, replacing any analyzer of the same name
+func RegisterEmotionAnalyzer(info AnalyzerInfo, factory EmotionAnalyzerFactory) {
+	emotionAnalyzersMu.Lock()
+	defer emotionAnalyzersMu.Unlock()
+	emotionAnalyzers[info.Name] = registeredAnalyzer{info: info, factory: factory}
+}
+
+// emotionAnalyzerFactory returns the factory registered under name
+func emotionAnalyzerFactory(name string) (EmotionAnalyzerFactory, bool) {
+	emotionAnalyzersMu.RLock()
+	defer emotionAnalyzersMu.RUnlock()
+	registered, ok := emotionAnalyzers[name]
+	return registered.factory, ok
+}
+
+// ListEmotionAnalyzers returns the registered analyzers by name
+func ListEmotionAnalyzers() []AnalyzerInfo {
+	emotionAnalyzersMu.RLock()
+	infos := make([]AnalyzerInfo, 0, len(emotionAnalyzers))
+	for _, registered := range emotionAnalyzers {
+		infos = append(infos, registered.info)
+	}
+	emotionAnalyzersMu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// validateEmotionPipeline checks that a pipeline has a known mode and only registered analyzers
+func validateEmotionPipeline(config *EmotionPipelineConfig) error {
+	if config.Mode != PipelineFallback && config.Mode != PipelineEnsemble {
+		return fmt.Errorf("无效的分析模式: %s", config.Mode)
+	}
+	if len(config.Analyzers) == 0 {
+		return fmt.Errorf("至少需要一个情绪分析器")
+	}
+
+	for _, step := range config.Analyzers {
+		if _, ok := emotionAnalyzerFactory(step.Name); !ok {
+			return fmt.Errorf("未知的情绪分析器: %s", step.Name)
+		}
+		if step.Weight < 0 {
+			return fmt.Errorf("分析器 %s 的权重不能为负数", step.Name)
+		}
+	}
+	for _, name := range config.Fallbacks {
+		if _, ok := emotionAnalyzerFactory(name); !ok {
+			return fmt.Errorf("未知的情绪分析器: %s", name)
+		}
+	}
+	return nil
+}
+
+// EmotionPipeline runs the analyzers of a pipeline configuration
+type EmotionPipeline struct {
+	mode      string
+	analyzers []EmotionAnalyzer
+	weights   []float64
+	fallbacks []EmotionAnalyzer
+}
+
+// NewEmotionPipeline creates the analyzers of a configuration, leaving out those unavailable with the options
+func NewEmotionPipeline(config EmotionPipelineConfig, options AnalyzerOptions) (*EmotionPipeline, error) {
+	if err := validateEmotionPipeline(&config); err != nil {
+		return nil, err
+	}
+
+	pipeline := &EmotionPipeline{mode: config.Mode}
+	for _, step := range config.Analyzers {
+		analyzer, err := newRegisteredAnalyzer(step.Name, options)
+		if err != nil {
+			continue
+		}
+		pipeline.analyzers = append(pipeline.analyzers, analyzer)
+		pipeline.weights = append(pipeline.weights, step.Weight)
+	}
+	for _, name := range config.Fallbacks {
+		if analyzer, err := newRegisteredAnalyzer(name, options); err == nil {
+			pipeline.fallbacks = append(pipeline.fallbacks, analyzer)
+		}
+	}
+
+	if len(pipeline.analyzers) == 0 && len(pipeline.fallbacks) == 0 {
+		return nil, fmt.Errorf("没有可用的情绪分析器")
+	}
+	return pipeline, nil
+}
+
+// newRegisteredAnalyzer creates the analyzer registered under name
+func newRegisteredAnalyzer(name string, options AnalyzerOptions) (EmotionAnalyzer, error) {
+	factory, ok := emotionAnalyzerFactory(name)
+	if !ok {
+		return nil, fmt.Errorf("未知的情绪分析器: %s", name)
+	}
+	return factory(options)
+}
+
+// Analyze runs the pipeline on text
+func (p *EmotionPipeline) Analyze(ctx context.Context, text string) (*EmotionAnalysisResult, error) {
+	var lastErr error
+
+	if p.mode == PipelineEnsemble {
+		var results []*EmotionAnalysisResult
+		var weights []float64
+		for i, analyzer := range p.analyzers {
+			result, err := runAnalyzer(ctx, analyzer, text)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			results = append(results, result)
+			weights = append(weights, p.weights[i])
+		}
+		if len(results) > 0 {
+			return blendEmotionResults(results, weights), nil
+		}
+	} else {
+		for _, analyzer := range p.analyzers {
+			result, err := runAnalyzer(ctx, analyzer, text)
+			if err == nil {
+				return result, nil
+			}
+			lastErr = err
+		}
+	}
+
+	for _, analyzer := range p.fallbacks {
+		result, err := runAnalyzer(ctx, analyzer, text)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return nil, fmt.Errorf("情绪分析失败: %v", lastErr)
+}
+
+// runAnalyzer runs an analyzer, naming the method of its result after it when unset
+func runAnalyzer(ctx context.Context, analyzer EmotionAnalyzer, text string) (*EmotionAnalysisResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := analyzer.Analyze(ctx, text)
+	if err != nil {
+		fmt.Printf("Emotion analyzer %s failed: %v\n", analyzer.Name(), err)
+		return nil, err
+	}
+	if result.AnalysisMethod == "" {
+		result.AnalysisMethod = analyzer.Name()
+	}
+	return result, nil
+}
+
+// blendEmotionResults averages results by weight. Weights are relative; when
+// they are all zero the results count equally. The timeline comes from the
+// first result that has one, as timelines cannot be blended.
+func blendEmotionResults(results []*EmotionAnalysisResult, weights []float64) *EmotionAnalysisResult {
+	if len(results) == 1 {
+		return results[0]
+	}
+
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = float64(len(weights))
+	}
+
+	blended := &EmotionAnalysisResult{}
+	var methods []string
+	var keywords []string
+	for i, result := range results {
+		share := weights[i] / total
+		blended.Joy += result.Joy * share
+		blended.Sadness += result.Sadness * share
+		blended.Anger += result.Anger * share
+		blended.Fear += result.Fear * share
+		blended.Love += result.Love * share
+		blended.Surprise += result.Surprise * share
+		blended.Disgust += result.Disgust * share
+		blended.Confidence += result.Confidence * share
+		blended.SentimentScore += result.SentimentScore * share
+
+		methods = append(methods, result.AnalysisMethod)
+		keywords = append(keywords, result.Keywords...)
+		if blended.Timeline == nil && len(result.Timeline) > 0 {
+			blended.Timeline = result.Timeline
+		}
+	}
+
+	// Re-determine the dominant emotion
+	emotions := map[string]float64{
+		"joy": blended.Joy, "sadness": blended.Sadness, "anger": blended.Anger,
+		"fear": blended.Fear, "love": blended.Love, "surprise": blended.Surprise, "disgust": blended.Disgust,
+	}
+	maxScore := 0.0
+	for emotion, score := range emotions {
+		if score > maxScore || (score == maxScore && score > 0 && emotion < blended.DominantEmotion) {
+			maxScore = score
+			blended.DominantEmotion = emotion
+		}
+	}
+	if blended.DominantEmotion == "" || maxScore < 0.1 {
+		blended.DominantEmotion = "neutral"
+	}
+
+	if blended.SentimentScore > 0.1 {
+		blended.SentimentLabel = "positive"
+	} else if blended.SentimentScore < -0.1 {
+		blended.SentimentLabel = "negative"
+	} else {
+		blended.SentimentLabel = "neutral"
+	}
+
+	blended.Keywords = removeDuplicatesStr(keywords)
+	blended.AnalysisMethod = strings.Join(methods, "+")
+	return blended
+}
+
+// Built-in analyzers
+
+// Versions of the built-in engines
+const (
+	basicAnalyzerVersion    = "1.0"
+	enhancedAnalyzerVersion = "2.0"
+)
+
+// basicAnalyzer is the keyword-counting engine
+type basicAnalyzer struct{}
+
+func newBasicAnalyzer(options AnalyzerOptions) (EmotionAnalyzer, error) {
+	return basicAnalyzer{}, nil
+}
+
+func (basicAnalyzer) Name() string    { return "basic" }
+func (basicAnalyzer) Version() string { return basicAnalyzerVersion }
+
+func (basicAnalyzer) Analyze(ctx context.Context, text string) (*EmotionAnalysisResult, error) {
+	return AnalyzeEmotionProgrammatically(text)
+}
+
+// enhancedAnalyzer is the dictionary engine with word segmentation, modifier scopes and a timeline
+type enhancedAnalyzer struct{}
+
+func newEnhancedAnalyzer(options AnalyzerOptions) (EmotionAnalyzer, error) {
+	return enhancedAnalyzer{}, nil
+}
+
+func (enhancedAnalyzer) Name() string    { return "enhanced" }
+func (enhancedAnalyzer) Version() string { return enhancedAnalyzerVersion }
+
+func (enhancedAnalyzer) Analyze(ctx context.Context, text string) (*EmotionAnalysisResult, error) {
+	if err := initializeEnhancedDictionary(); err != nil {
+		return nil, err
+	}
+	return analyzeWithEnhancedEngine(text)
+}
+
+// ollamaAnalyzer asks a local model served by Ollama
+type ollamaAnalyzer struct {
+	url string
+}
+
+func newOllamaAnalyzer(options AnalyzerOptions) (EmotionAnalyzer, error) {
+	if !options.UseAI {
+		return nil, fmt.Errorf("AI analysis is disabled")
+	}
+	return ollamaAnalyzer{url: options.OllamaURL}, nil
+}
+
+func (ollamaAnalyzer) Name() string    { return "ollama" }
+func (ollamaAnalyzer) Version() string { return ollamaModel }
+
+func (a ollamaAnalyzer) Analyze(ctx context.Context, text string) (*EmotionAnalysisResult, error) {
+	return AnalyzeEmotionWithAI(ctx, text, a.url)
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+// countingAnalyzer is a test analyzer whose factory counts its calls
+type countingAnalyzer struct{}
+
+func (countingAnalyzer) Name() string    { return "counting" }
+func (countingAnalyzer) Version() string { return "0.1" }
+
+func (countingAnalyzer) Analyze(ctx context.Context, text string) (*EmotionAnalysisResult, error) {
+	return &EmotionAnalysisResult{DominantEmotion: "joy", Joy: 1}, nil
+}
+
+func registerCountingAnalyzer(t *testing.T) *int {
+	calls := 0
+	RegisterEmotionAnalyzer(AnalyzerInfo{Name: "counting", Version: "0.1", RequireAI: true}, func(options AnalyzerOptions) (EmotionAnalyzer, error) {
+		calls++
+		return countingAnalyzer{}, nil
+	})
+	t.Cleanup(func() {
+		emotionAnalyzersMu.Lock()
+		defer emotionAnalyzersMu.Unlock()
+		delete(emotionAnalyzers, "counting")
+	})
+	return &calls
+}
+
+func TestListEmotionAnalyzersUsesRegisteredInfo(t *testing.T) {
+	calls := registerCountingAnalyzer(t)
+
+	infos := ListEmotionAnalyzers()
+	if *calls != 0 {
+		t.Errorf("listing called the factory %d times", *calls)
+	}
+
+	var found *AnalyzerInfo
+	for i := range infos {
+		if i > 0 && infos[i-1].Name >= infos[i].Name {
+			t.Errorf("analyzers are not sorted by name: %v", infos)
+		}
+		if infos[i].Name == "counting" {
+			found = &infos[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("registered analyzer missing from %v", infos)
+	}
+	if found.Version != "0.1" || !found.RequireAI {
+		t.Errorf("info = %+v, want version 0.1 requiring AI", *found)
+	}
+}
+
+func TestNewEmotionPipelineUsesRegisteredFactory(t *testing.T) {
+	calls := registerCountingAnalyzer(t)
+
+	pipeline, err := NewEmotionPipeline(EmotionPipelineConfig{
+		Mode:      PipelineFallback,
+		Analyzers: []EmotionPipelineStep{{Name: "counting", Weight: 1}},
+	}, AnalyzerOptions{})
+	if err != nil {
+		t.Fatalf("NewEmotionPipeline: %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("factory called %d times, want 1", *calls)
+	}
+
+	result, err := pipeline.Analyze(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if result.AnalysisMethod != "counting" {
+		t.Errorf("analysis method = %q, want counting", result.AnalysisMethod)
+	}
+
+	if _, err := NewEmotionPipeline(EmotionPipelineConfig{
+		Mode:      PipelineFallback,
+		Analyzers: []EmotionPipelineStep{{Name: "missing", Weight: 1}},
+	}, AnalyzerOptions{}); err == nil {
+		t.Error("NewEmotionPipeline accepted an unregistered analyzer")
+	}
+}
+
+func TestGetEmotionPipelineCopiesDefault(t *testing.T) {
+	setupTestDatabase(t)
+
+	config, err := GetEmotionPipeline()
+	if err != nil {
+		t.Fatalf("GetEmotionPipeline: %v", err)
+	}
+	weight := DefaultEmotionPipeline.Analyzers[0].Weight
+	fallback := DefaultEmotionPipeline.Fallbacks[0]
+
+	config.Analyzers[0].Weight = weight + 1
+	config.Fallbacks[0] = "changed"
+
+	if DefaultEmotionPipeline.Analyzers[0].Weight != weight || DefaultEmotionPipeline.Fallbacks[0] != fallback {
+		t.Errorf("changing the returned pipeline changed DefaultEmotionPipeline: %+v", DefaultEmotionPipeline)
+	}
+}
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Emotion timeline
+//
+// The enhanced engine scores every sentence of a diary on its own, giving
+// the emotional arc of the entry. Offsets are in UTF-16 code units of the
+// content as it was analyzed, so the frontend can slice the content string
+// with them directly; re-analyzing the diary after an edit updates them.
+
+// EmotionSegment is the emotion of one sentence of a diary
+type EmotionSegment struct {
+	Index     int `json:"index"`
+	Paragraph int `json:"paragraph"` // Paragraphs are separated by line breaks
+	Start     int `json:"start"`     // UTF-16 offset into the content
+	End       int `json:"end"`       // UTF-16 offset after the sentence
+
+	Joy      float64 `json:"joy"`
+	Sadness  float64 `json:"sadness"`
+	Anger    float64 `json:"anger"`
+	Fear     float64 `json:"fear"`
+	Love     float64 `json:"love"`
+	Surprise float64 `json:"surprise"`
+	Disgust  float64 `json:"disgust"`
+
+	DominantEmotion string   `json:"dominantEmotion"`
+	Confidence      float64  `json:"confidence"`
+	SentimentScore  float64  `json:"sentimentScore"`
+	SentimentLabel  string   `json:"sentimentLabel"`
+	Keywords        []string `json:"keywords"`
+}
+
+// sentenceTerminators end a sentence; closingMarks that follow them still belong to it
+var (
+	sentenceTerminators = map[rune]bool{
+		'。': true, '！': true, '？': true, '…': true, '!': true, '?': true, '.': true, '\n': true,
+	}
+	closingMarks = map[rune]bool{
+		'”': true, '’': true, '」': true, '』': true, '）': true, '》': true, '"': true, '\'': true, ')': true,
+	}
+)
+
+// textSpan is a sentence of a text by code point offsets
+type textSpan struct {
+	Start, End int
+	Paragraph  int
+}
+
+// splitSentences splits text into sentences, trimming the whitespace around
+// them. A period only ends a sentence when followed by whitespace or the end
+// of the text, so decimals and abbreviations like "3.5" stay whole.
+func splitSentences(text string) []textSpan {
+	runes := []rune(text)
+	var spans []textSpan
+
+	paragraph := 0
+	start := 0
+	emit := func(end int) {
+		for start < end && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		trimmed := end
+		for trimmed > start && unicode.IsSpace(runes[trimmed-1]) {
+			trimmed--
+		}
+		if trimmed > start {
+			spans = append(spans, textSpan{Start: start, End: trimmed, Paragraph: paragraph})
+		}
+		start = end
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if !sentenceTerminators[r] {
+			continue
+		}
+		if r == '.' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !closingMarks[runes[i+1]] {
+			continue
+		}
+
+		// Keep runs of terminators ("？！", "...") and closing quotes in the sentence
+		end := i + 1
+		for end < len(runes) && runes[end] != '\n' && (sentenceTerminators[runes[end]] || closingMarks[runes[end]]) {
+			end++
+		}
+		emit(end)
+
+		// A blank line or a single line break both start a new paragraph
+		if r == '\n' || (end < len(runes) && runes[end] == '\n') {
+			if len(spans) > 0 && spans[len(spans)-1].Paragraph == paragraph {
+				paragraph++
+			}
+		}
+		i = end - 1
+	}
+	emit(len(runes))
+
+	return spans
+}
+
+// emotionTimeline scores each sentence of content with the matches found in it
+func emotionTimeline(content string, tokens []Token, matches, sentimentMatches []KeywordMatch) []EmotionSegment {
+	spans := splitSentences(content)
+	timeline := make([]EmotionSegment, 0, len(spans))
+	offsets := utf16Offsets(content)
+
+	t := 0
+	for index, span := range spans {
+		words := 0
+		for ; t < len(tokens) && tokens[t].Start < span.End; t++ {
+			if tokens[t].Start >= span.Start && !tokens[t].Punct {
+				words++
+			}
+		}
+		if words == 0 {
+			words = 1
+		}
+
+		result := scoreEnhancedMatches(matchesWithin(matches, span), matchesWithin(sentimentMatches, span), words)
+		keywords := result.Keywords
+		if keywords == nil {
+			keywords = []string{}
+		}
+		timeline = append(timeline, EmotionSegment{
+			Index:           index,
+			Paragraph:       span.Paragraph,
+			Start:           offsets[span.Start],
+			End:             offsets[span.End],
+			Joy:             result.Joy,
+			Sadness:         result.Sadness,
+			Anger:           result.Anger,
+			Fear:            result.Fear,
+			Love:            result.Love,
+			Surprise:        result.Surprise,
+			Disgust:         result.Disgust,
+			DominantEmotion: result.DominantEmotion,
+			Confidence:      result.Confidence,
+			SentimentScore:  result.SentimentScore,
+			SentimentLabel:  result.SentimentLabel,
+			Keywords:        keywords,
+		})
+	}
+
+	return timeline
+}
+
+// utf16Offsets maps each code point offset of text, and its end, to the
+// matching UTF-16 offset
+func utf16Offsets(text string) []int {
+	offsets := make([]int, 0, len(text)+1)
+	offset := 0
+	for _, r := range text {
+		offsets = append(offsets, offset)
+		offset += utf16.RuneLen(r)
+	}
+	return append(offsets, offset)
+}
+
+// matchesWithin returns the matches that start inside a span
+func matchesWithin(matches []KeywordMatch, span textSpan) []KeywordMatch {
+	var within []KeywordMatch
+	for _, match := range matches {
+		if match.Start >= span.Start && match.Start < span.End {
+			within = append(within, match)
+		}
+	}
+	return within
+}
+
+// emotionTimelineAAD binds a timeline ciphertext to its diary and user
+func emotionTimelineAAD(diaryID string, userID uint) []byte {
+	return []byte(fmt.Sprintf("moodstack/emotion-timeline/v%d|%s|%d", ciphertextVersion, diaryID, userID))
+}
+
+// sealEmotionTimeline encrypts a timeline into the analysis, or clears it when empty
+func sealEmotionTimeline(analysis *EmotionAnalysis, timeline []EmotionSegment, key []byte) error {
+	if len(timeline) == 0 {
+		analysis.EncryptedTimeline = nil
+		analysis.TimelineIV = ""
+		return nil
+	}
+
+	data, err := json.Marshal(timeline)
+	if err != nil {
+		return fmt.Errorf("failed to marshal emotion timeline: %v", err)
+	}
+
+	ciphertext, iv, err := EncryptDataWithAAD(data, key, emotionTimelineAAD(analysis.DiaryID, analysis.UserID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt emotion timeline: %v", err)
+	}
+
+	analysis.EncryptedTimeline = ciphertext
+	analysis.TimelineIV = base64.StdEncoding.EncodeToString(iv)
+	return nil
+}
+
+// openEmotionTimeline decrypts the timeline of an analysis, empty when it has none
+func openEmotionTimeline(analysis *EmotionAnalysis, key []byte) ([]EmotionSegment, error) {
+	timeline := []EmotionSegment{}
+	if analysis.TimelineIV == "" {
+		return timeline, nil
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(analysis.TimelineIV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode IV: %v", err)
+	}
+
+	data, err := DecryptDataWithAAD(analysis.EncryptedTimeline, key, iv, emotionTimelineAAD(analysis.DiaryID, analysis.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt emotion timeline: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &timeline); err != nil {
+		return nil, fmt.Errorf("failed to parse emotion timeline: %v", err)
+	}
+	return timeline, nil
+}
+
+// GetDiaryEmotionTimeline returns the sentence timeline of a diary's emotion
+// analysis, in content order. It is empty when the diary has not been
+// analyzed, or was analyzed by an engine without a timeline.
+func GetDiaryEmotionTimeline(diaryID string, userID uint, key []byte) ([]EmotionSegment, error) {
+	var analysis EmotionAnalysis
+	result := gormDB.Select("id, diary_id, user_id, encrypted_timeline, timeline_iv").
+		Where("diary_id = ? AND user_id = ?", diaryID, userID).Limit(1).Find(&analysis)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get emotion analysis: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return []EmotionSegment{}, nil
+	}
+
+	return openEmotionTimeline(&analysis, key)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"gorm.io/gorm"
 )
@@ -25,14 +24,14 @@ func SaveEncryptedDiary(diary *Diary, userID uint, encryptionKey []byte) error {
 
 // SaveEncryptedDiaryWithOptions saves a diary entry with specified encryption options
 func SaveEncryptedDiaryWithOptions(diary *Diary, userID uint, masterKey []byte, options *DiaryEncryptionOptions) error {
-	var encryptionKey []byte
+	var keyEncryptionKey []byte
 	var encryptionSalt string
 
-	// Determine encryption key based on mode
+	// Determine the key that wraps this diary's data key based on mode
 	switch options.Mode {
 	case "unified":
-		// Use the master key (derived from user password)
-		encryptionKey = masterKey
+		// Use the user's master data key
+		keyEncryptionKey = masterKey
 
 	case "individual":
 		// Generate a new salt for this diary's individual password
@@ -45,53 +44,72 @@ func SaveEncryptedDiaryWithOptions(diary *Diary, userID uint, masterKey []byte,
 			return fmt.Errorf("failed to generate salt: %v", err)
 		}
 
-		encryptionKey = DeriveKey(options.IndividualPassword, salt)
+		keyEncryptionKey = DeriveKey(options.IndividualPassword, salt)
 		encryptionSalt = base64.StdEncoding.EncodeToString(salt)
 
 	case "biometric":
 		// Use the master key but mark as biometric mode
-		encryptionKey = masterKey
+		keyEncryptionKey = masterKey
 
 	default:
 		return fmt.Errorf("unsupported encryption mode: %s", options.Mode)
 	}
 
-	// Encrypt content
-	encryptedContent, iv, err := EncryptData([]byte(diary.Content), encryptionKey)
+	// Fill in suggested tags when auto-tagging is enabled and the diary has
+	// never had tags, so tags the user removed are not added back. Individually
+	// encrypted diaries are skipped, as tags derived from their content would
+	// reveal it without the diary's password.
+	tagsAssigned, err := diaryTagsAssigned(diary.ID, userID)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt diary content: %v", err)
+		return err
 	}
+	if !tagsAssigned && options.Mode != "individual" {
+		if tags := autoTags(diary, userID, masterKey); len(tags) > 0 {
+			diary.Tags = tags
+		}
+	}
+	tagsAssigned = tagsAssigned || len(cleanTags(diary.Tags)) > 0
 
-	// Convert tags to JSON string
-	tagsJSON, err := json.Marshal(diary.Tags)
+	// Encrypt content (and metadata when enabled) with a fresh data key.
+	// Tags are kept in the tag tables instead of the diary row.
+	untagged := *diary
+	untagged.Tags = nil
+	encryptMetadata, err := userEncryptsMetadata(userID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal tags: %v", err)
+		return err
+	}
+	sealed, err := sealDiary(&untagged, userID, options.Mode, keyEncryptionKey, encryptMetadata)
+	if err != nil {
+		return err
 	}
-
-	ivStr := base64.StdEncoding.EncodeToString(iv)
 
 	// Create encrypted diary entry
 	encDiary := &EncryptedDiary{
-		ID:               diary.ID,
-		UserID:           userID,
-		Title:            diary.Title,
-		EncryptedContent: encryptedContent,
-		IV:               ivStr,
-		FileName:         diary.FileName,
-		FileType:         diary.FileType,
-		Tags:             string(tagsJSON),
-		EncryptionMode:   options.Mode,
-		EncryptionSalt:   encryptionSalt,
-		CreatedAt:        diary.CreatedAt,
-		UpdatedAt:        diary.UpdatedAt,
+		ID:             diary.ID,
+		UserID:         userID,
+		EncryptionMode: options.Mode,
+		EncryptionSalt: encryptionSalt,
+		FileType:       diary.FileType,
+		TagsAssigned:   tagsAssigned,
+		CreatedAt:      diary.CreatedAt,
+		UpdatedAt:      diary.UpdatedAt,
 	}
+	sealed.apply(encDiary)
 
-	// Use GORM's Save method which handles both create and update
-	if err := gormDB.Save(encDiary).Error; err != nil {
-		return fmt.Errorf("failed to save encrypted diary: %v", err)
-	}
-
-	return nil
+	// Use GORM's Save method which handles both create and update,
+	// and keep the tags, revision history and search index in step
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(encDiary).Error; err != nil {
+			return fmt.Errorf("failed to save encrypted diary: %v", err)
+		}
+		if err := setDiaryTags(tx, userID, diary.ID, diary.Tags, masterKey); err != nil {
+			return err
+		}
+		if err := recordDiaryRevision(tx, encDiary); err != nil {
+			return err
+		}
+		return indexDiary(tx, diary, userID, options.Mode, masterKey)
+	})
 }
 
 // GetEncryptedDiariesList returns all diary entries for a user (decrypted)
@@ -103,60 +121,33 @@ func GetEncryptedDiariesList(userID uint, encryptionKey []byte) ([]Diary, error)
 
 	var diaries []Diary
 	for _, encDiary := range encDiaries {
-		// Parse tags JSON
-		tagsJSON := encDiary.Tags
-
 		// For 'unified' and 'biometric' modes, we can decrypt with the provided key
 		// For 'individual' mode, we can't decrypt without the specific password
 		if encDiary.EncryptionMode == "individual" {
 			// Create a placeholder diary that shows it needs a password
-			diary := Diary{
-				ID:        encDiary.ID,
-				Title:     encDiary.Title,
-				Content:   "[此日记需要单独密码解锁]",
-				FileName:  encDiary.FileName,
-				FileType:  encDiary.FileType,
-				Tags:      []string{},
-				CreatedAt: encDiary.CreatedAt,
-				UpdatedAt: encDiary.UpdatedAt,
-			}
-			diaries = append(diaries, diary)
+			diaries = append(diaries, *lockedDiary(&encDiary))
 			continue
 		}
 
 		// Decrypt content for unified and biometric modes
-		content, err := decryptDiaryContent(encDiary.EncryptedContent, encDiary.IV, encryptionKey)
+		diary, err := openEncryptedDiary(&encDiary, encryptionKey)
 		if err != nil {
 			continue
 		}
 
-		// Parse tags
-		var tags []string
-		if tagsJSON != "" {
-			if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-				tags = []string{}
-			}
-		}
-
-		diary := Diary{
-			ID:        encDiary.ID,
-			Title:     encDiary.Title,
-			Content:   content,
-			FileName:  encDiary.FileName,
-			FileType:  encDiary.FileType,
-			Tags:      tags,
-			CreatedAt: encDiary.CreatedAt,
-			UpdatedAt: encDiary.UpdatedAt,
-		}
+		diaries = append(diaries, *diary)
+	}
 
-		diaries = append(diaries, diary)
+	if err := attachTags(userID, diaries, encryptionKey); err != nil {
+		return nil, err
 	}
 
 	return diaries, nil
 }
 
-// GetEncryptedDiaryWithPassword returns a diary decrypted with an individual password
-func GetEncryptedDiaryWithPassword(diaryID string, userID uint, password string) (*Diary, error) {
+// GetEncryptedDiaryWithPassword returns a diary decrypted with an individual password.
+// The master key is needed to read the diary's tags.
+func GetEncryptedDiaryWithPassword(diaryID string, userID uint, password string, masterKey []byte) (*Diary, error) {
 	var encDiary EncryptedDiary
 	if err := gormDB.Where("id = ? AND user_id = ?", diaryID, userID).First(&encDiary).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -170,6 +161,16 @@ func GetEncryptedDiaryWithPassword(diaryID string, userID uint, password string)
 		return nil, fmt.Errorf("this diary is not individually encrypted")
 	}
 
+	// Refuse attempts while the diary is locked out
+	subject := diaryThrottleSubject(diaryID)
+	remaining, err := throttleRemaining(subject)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("%s", lockoutMessage(remaining))
+	}
+
 	// Derive the encryption key from the password and stored salt
 	salt, err := base64.StdEncoding.DecodeString(encDiary.EncryptionSalt)
 	if err != nil {
@@ -179,33 +180,62 @@ func GetEncryptedDiaryWithPassword(diaryID string, userID uint, password string)
 	encryptionKey := DeriveKey(password, salt)
 
 	// Decrypt content
-	content, err := decryptDiaryContent(encDiary.EncryptedContent, encDiary.IV, encryptionKey)
+	diary, err := openEncryptedDiary(&encDiary, encryptionKey)
 	if err != nil {
+		lockout, recordErr := recordFailedAttempt(subject, userID, "wrong diary password")
+		if recordErr != nil {
+			return nil, recordErr
+		}
+		if lockout > 0 {
+			return nil, fmt.Errorf("%s", lockoutMessage(lockout))
+		}
 		return nil, fmt.Errorf("failed to decrypt diary content (incorrect password?): %v", err)
 	}
 
-	// Parse tags
-	var tags []string
-	if encDiary.Tags != "" {
-		if err := json.Unmarshal([]byte(encDiary.Tags), &tags); err != nil {
-			tags = []string{}
-		}
+	if err := recordSuccessfulAttempt(subject, userID); err != nil {
+		return nil, err
 	}
 
-	diary := &Diary{
-		ID:        encDiary.ID,
-		Title:     encDiary.Title,
-		Content:   content,
-		FileName:  encDiary.FileName,
-		FileType:  encDiary.FileType,
-		Tags:      tags,
-		CreatedAt: encDiary.CreatedAt,
-		UpdatedAt: encDiary.UpdatedAt,
+	// The password is at hand, so bring the diary's metadata in line with the setting
+	if err := resealIndividualMetadata(&encDiary, diary, encryptionKey); err != nil {
+		fmt.Printf("Failed to reseal metadata of diary %s: %v\n", diaryID, err)
+	}
+
+	// The password unlocks the diary's tags along with its content
+	tags, err := readDiaryTagNames(userID, []string{diaryID}, masterKey, true)
+	if err != nil {
+		return nil, err
+	}
+	if diaryTags, ok := tags[diaryID]; ok {
+		diary.Tags = diaryTags
 	}
 
 	return diary, nil
 }
 
+// resealIndividualMetadata re-seals an individually encrypted diary whose
+// metadata encryption state does not match the user's setting
+func resealIndividualMetadata(encDiary *EncryptedDiary, diary *Diary, kek []byte) error {
+	encryptMetadata, err := userEncryptsMetadata(encDiary.UserID)
+	if err != nil {
+		return err
+	}
+	if encryptMetadata == (encDiary.MetadataIV != "") {
+		return nil
+	}
+
+	untagged := *diary
+	untagged.Tags = nil
+	sealed, err := sealDiary(&untagged, encDiary.UserID, encDiary.EncryptionMode, kek, encryptMetadata)
+	if err != nil {
+		return err
+	}
+	if err := gormDB.Model(&EncryptedDiary{}).Where("id = ? AND user_id = ?", encDiary.ID, encDiary.UserID).Updates(sealed.columns()).Error; err != nil {
+		return fmt.Errorf("failed to update diary: %v", err)
+	}
+	return nil
+}
+
 // GetEncryptedDiaryByID returns a specific diary by ID (decrypted)
 func GetEncryptedDiaryByID(diaryID string, userID uint, encryptionKey []byte) (*Diary, error) {
 	var encDiary EncryptedDiary
@@ -217,42 +247,20 @@ func GetEncryptedDiaryByID(diaryID string, userID uint, encryptionKey []byte) (*
 	}
 
 	// For individually encrypted diaries, we can't decrypt without the specific password
+	var diary *Diary
 	if encDiary.EncryptionMode == "individual" {
-		return &Diary{
-			ID:        encDiary.ID,
-			Title:     encDiary.Title,
-			Content:   "[此日记需要单独密码解锁]",
-			FileName:  encDiary.FileName,
-			FileType:  encDiary.FileType,
-			Tags:      []string{},
-			CreatedAt: encDiary.CreatedAt,
-			UpdatedAt: encDiary.UpdatedAt,
-		}, nil
-	}
-
-	// Decrypt content for unified and biometric modes
-	content, err := decryptDiaryContent(encDiary.EncryptedContent, encDiary.IV, encryptionKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt diary content: %v", err)
-	}
-
-	// Parse tags
-	var tags []string
-	if encDiary.Tags != "" {
-		if err := json.Unmarshal([]byte(encDiary.Tags), &tags); err != nil {
-			tags = []string{}
+		diary = lockedDiary(&encDiary)
+	} else {
+		// Decrypt content for unified and biometric modes
+		opened, err := openEncryptedDiary(&encDiary, encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt diary content: %v", err)
 		}
+		diary = opened
 	}
 
-	diary := &Diary{
-		ID:        encDiary.ID,
-		Title:     encDiary.Title,
-		Content:   content,
-		FileName:  encDiary.FileName,
-		FileType:  encDiary.FileType,
-		Tags:      tags,
-		CreatedAt: encDiary.CreatedAt,
-		UpdatedAt: encDiary.UpdatedAt,
+	if err := attachDiaryTags(userID, diary, encryptionKey); err != nil {
+		return nil, err
 	}
 
 	return diary, nil
@@ -261,7 +269,7 @@ func GetEncryptedDiaryByID(diaryID string, userID uint, encryptionKey []byte) (*
 // GetDiaryEncryptionInfo returns encryption information for a diary
 func GetDiaryEncryptionInfo(diaryID string, userID uint) (*DiaryEncryptionInfo, error) {
 	var encDiary EncryptedDiary
-	if err := gormDB.Select("encryption_mode, encryption_salt").Where("id = ? AND user_id = ?", diaryID, userID).First(&encDiary).Error; err != nil {
+	if err := gormDB.Select("encryption_mode, encryption_salt, metadata_iv").Where("id = ? AND user_id = ?", diaryID, userID).First(&encDiary).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, fmt.Errorf("diary not found")
 		}
@@ -269,108 +277,365 @@ func GetDiaryEncryptionInfo(diaryID string, userID uint) (*DiaryEncryptionInfo,
 	}
 
 	return &DiaryEncryptionInfo{
-		Mode:    encDiary.EncryptionMode,
-		HasSalt: encDiary.EncryptionSalt != "",
+		Mode:              encDiary.EncryptionMode,
+		HasSalt:           encDiary.EncryptionSalt != "",
+		MetadataEncrypted: encDiary.MetadataIV != "",
 	}, nil
 }
 
 // DiaryEncryptionInfo contains encryption information for a diary
 type DiaryEncryptionInfo struct {
-	Mode    string `json:"mode"`    // 'unified', 'individual', 'biometric'
-	HasSalt bool   `json:"hasSalt"` // Whether the diary has its own salt (individual mode)
+	Mode              string `json:"mode"`              // 'unified', 'individual', 'biometric'
+	HasSalt           bool   `json:"hasSalt"`           // Whether the diary has its own salt (individual mode)
+	MetadataEncrypted bool   `json:"metadataEncrypted"` // Whether title, tags and file name are encrypted
+}
+
+// SearchEncryptedDiaries searches the user's diaries through the search index and
+// returns a page of them, best match first, along with the number of matches
+func SearchEncryptedDiaries(query string, userID uint, encryptionKey []byte, opts SearchOptions) ([]Diary, int, error) {
+	return searchIndexedDiaries(query, userID, encryptionKey, opts)
 }
 
-// SearchEncryptedDiaries searches for diaries in encrypted database
-func SearchEncryptedDiaries(query string, userID uint, encryptionKey []byte) ([]Diary, error) {
-	// Get all diaries and search in decrypted content
-	allDiaries, err := GetEncryptedDiariesList(userID, encryptionKey)
+// SearchEncryptedDiariesWithContext searches the user's diaries through the search index
+// and returns a page of results with matched snippets, best match first
+func SearchEncryptedDiariesWithContext(query string, userID uint, encryptionKey []byte, opts SearchOptions) (*SearchPage, error) {
+	if query == "" {
+		return &SearchPage{Results: []SearchResult{}}, nil
+	}
+
+	diaries, total, err := searchIndexedDiaries(query, userID, encryptionKey, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	var matchedDiaries []Diary
-	lowerCaseQuery := strings.ToLower(query)
+	results := make([]SearchResult, 0, len(diaries))
+	for _, diary := range diaries {
+		titleMatch := containsAnyWord(diary.Title, query)
+		contentMatch := !titleMatch || containsAnyWord(diary.Content, query)
+
+		result := SearchResult{
+			Diary:           diary,
+			MatchedSnippets: []string{},
+			MatchType:       "",
+		}
 
-	for _, diary := range allDiaries {
-		if strings.Contains(strings.ToLower(diary.Title), lowerCaseQuery) ||
-			strings.Contains(strings.ToLower(diary.Content), lowerCaseQuery) {
-			matchedDiaries = append(matchedDiaries, diary)
+		// Determine match type
+		if titleMatch && contentMatch {
+			result.MatchType = "both"
+		} else if titleMatch {
+			result.MatchType = "title"
+		} else {
+			result.MatchType = "content"
 		}
+
+		// Extract matched content snippets
+		if contentMatch {
+			result.MatchedSnippets = searchSnippets(diary.Content, query)
+		}
+
+		results = append(results, result)
 	}
 
-	return matchedDiaries, nil
+	return &SearchPage{Results: results, Total: total}, nil
 }
 
-// SearchEncryptedDiariesWithContext searches for diaries with context in encrypted database
-func SearchEncryptedDiariesWithContext(query string, userID uint, encryptionKey []byte) ([]SearchResult, error) {
-	if query == "" {
-		return []SearchResult{}, nil
+// DeleteEncryptedDiary moves a diary to the trash
+func DeleteEncryptedDiary(diaryID string, userID uint) error {
+	result := gormDB.Where("id = ? AND user_id = ?", diaryID, userID).Delete(&EncryptedDiary{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete diary: %v", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("diary not found or not owned by user")
+	}
+
+	return nil
+}
+
+// diaryAAD returns the associated data that binds a diary ciphertext to its row,
+// so content cannot be swapped between diaries, users or encryption modes
+func diaryAAD(diaryID string, userID uint, mode string) []byte {
+	return []byte(fmt.Sprintf("moodstack/diary/v%d|%s|%d|%s", ciphertextVersion, diaryID, userID, mode))
+}
+
+// diaryMetadata is the sealed envelope holding a diary's metadata fields
+type diaryMetadata struct {
+	Title    string   `json:"title"`
+	FileName string   `json:"fileName"`
+	Tags     []string `json:"tags"`
+}
+
+// sealedDiary holds the encrypted columns of a diary row
+type sealedDiary struct {
+	EncryptedContent  []byte
+	IV                string
+	WrappedKey        string
+	EncryptedMetadata []byte
+	MetadataIV        string
+	EncryptedSummary  []byte
+	SummaryIV         string
+	Title             string
+	FileName          string
+	Tags              string
+}
+
+// apply copies the sealed columns into an EncryptedDiary row
+func (sd *sealedDiary) apply(encDiary *EncryptedDiary) {
+	encDiary.EncryptedContent = sd.EncryptedContent
+	encDiary.IV = sd.IV
+	encDiary.WrappedKey = sd.WrappedKey
+	encDiary.EncryptedMetadata = sd.EncryptedMetadata
+	encDiary.MetadataIV = sd.MetadataIV
+	encDiary.EncryptedSummary = sd.EncryptedSummary
+	encDiary.SummaryIV = sd.SummaryIV
+	encDiary.Title = sd.Title
+	encDiary.FileName = sd.FileName
+	encDiary.Tags = sd.Tags
+}
+
+// columns returns the sealed columns as an update map
+func (sd *sealedDiary) columns() map[string]interface{} {
+	return map[string]interface{}{
+		"encrypted_content":  sd.EncryptedContent,
+		"iv":                 sd.IV,
+		"wrapped_key":        sd.WrappedKey,
+		"encrypted_metadata": sd.EncryptedMetadata,
+		"metadata_iv":        sd.MetadataIV,
+		"encrypted_summary":  sd.EncryptedSummary,
+		"summary_iv":         sd.SummaryIV,
+		"title":              sd.Title,
+		"file_name":          sd.FileName,
+		"tags":               sd.Tags,
 	}
+}
+
+// diaryMetadataAAD returns the associated data for a diary's metadata envelope
+func diaryMetadataAAD(diaryID string, userID uint, mode string) []byte {
+	return append(diaryAAD(diaryID, userID, mode), []byte("|metadata")...)
+}
 
-	// Get all diaries and search in decrypted content
-	allDiaries, err := GetEncryptedDiariesList(userID, encryptionKey)
+// sealDiary encrypts a diary's content with a new data key wrapped by kek.
+// When encryptMetadata is set, title, tags and file name are sealed as one envelope
+// with the same data key and the plain columns are left empty. The listing
+// summary is always sealed with the data key.
+func sealDiary(diary *Diary, userID uint, mode string, kek []byte, encryptMetadata bool) (*sealedDiary, error) {
+	dataKey, err := GenerateDataKey()
 	if err != nil {
 		return nil, err
 	}
 
-	var results []SearchResult
-	lowerCaseQuery := strings.ToLower(query)
+	wrappedKey, err := WrapKey(dataKey, kek)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, diary := range allDiaries {
-		titleMatch := strings.Contains(strings.ToLower(diary.Title), lowerCaseQuery)
-		contentMatch := strings.Contains(strings.ToLower(diary.Content), lowerCaseQuery)
+	encryptedContent, iv, err := EncryptDataWithAAD([]byte(diary.Content), dataKey, diaryAAD(diary.ID, userID, mode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt diary content: %v", err)
+	}
 
-		if titleMatch || contentMatch {
-			result := SearchResult{
-				Diary:           diary,
-				MatchedSnippets: []string{},
-				MatchType:       "",
-			}
+	encryptedSummary, summaryIV, err := sealDiarySummary(diary.Content, diary.ID, userID, mode, dataKey)
+	if err != nil {
+		return nil, err
+	}
 
-			// Determine match type
-			if titleMatch && contentMatch {
-				result.MatchType = "both"
-			} else if titleMatch {
-				result.MatchType = "title"
-			} else {
-				result.MatchType = "content"
-			}
+	sealed := &sealedDiary{
+		EncryptedContent: encryptedContent,
+		IV:               base64.StdEncoding.EncodeToString(iv),
+		WrappedKey:       wrappedKey,
+		EncryptedSummary: encryptedSummary,
+		SummaryIV:        summaryIV,
+	}
 
-			// Extract matched content snippets
-			if contentMatch {
-				snippets := extractSnippets(diary.Content, query, 100)
-				result.MatchedSnippets = snippets
-			}
+	tags := diary.Tags
+	if tags == nil {
+		tags = []string{}
+	}
 
-			results = append(results, result)
+	if !encryptMetadata {
+		// Convert tags to JSON string
+		tagsJSON, err := json.Marshal(tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal tags: %v", err)
 		}
+
+		sealed.Title = diary.Title
+		sealed.FileName = diary.FileName
+		sealed.Tags = string(tagsJSON)
+		return sealed, nil
+	}
+
+	metadataJSON, err := json.Marshal(diaryMetadata{
+		Title:    diary.Title,
+		FileName: diary.FileName,
+		Tags:     tags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %v", err)
 	}
 
-	return results, nil
+	encryptedMetadata, metadataIV, err := EncryptDataWithAAD(metadataJSON, dataKey, diaryMetadataAAD(diary.ID, userID, mode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt diary metadata: %v", err)
+	}
+
+	sealed.EncryptedMetadata = encryptedMetadata
+	sealed.MetadataIV = base64.StdEncoding.EncodeToString(metadataIV)
+	return sealed, nil
 }
 
-// DeleteEncryptedDiary deletes a diary from encrypted database
-func DeleteEncryptedDiary(diaryID string, userID uint) error {
-	result := gormDB.Where("id = ? AND user_id = ?", diaryID, userID).Delete(&EncryptedDiary{})
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete diary: %v", result.Error)
+// openEncryptedDiary unwraps the diary's data key with kek and decrypts its content and metadata
+func openEncryptedDiary(encDiary *EncryptedDiary, kek []byte) (*Diary, error) {
+	dataKey, err := resolveDiaryKey(encDiary, kek)
+	if err != nil {
+		return nil, err
 	}
 
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("diary not found or not owned by user")
+	content, err := decryptDiaryContent(encDiary.EncryptedContent, encDiary.IV, dataKey, diaryAAD(encDiary.ID, encDiary.UserID, encDiary.EncryptionMode))
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	diary := &Diary{
+		ID:        encDiary.ID,
+		Title:     encDiary.Title,
+		Content:   content,
+		FileName:  encDiary.FileName,
+		FileType:  encDiary.FileType,
+		Tags:      encDiary.GetTags(),
+		CreatedAt: encDiary.CreatedAt,
+		UpdatedAt: encDiary.UpdatedAt,
+	}
+
+	if encDiary.MetadataIV != "" {
+		metadata, err := openDiaryMetadata(encDiary, dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		diary.Title = metadata.Title
+		diary.FileName = metadata.FileName
+		diary.Tags = metadata.Tags
+	}
+
+	return diary, nil
+}
+
+// openDiaryMetadata decrypts a diary's metadata envelope with its data key
+func openDiaryMetadata(encDiary *EncryptedDiary, dataKey []byte) (*diaryMetadata, error) {
+	metadataJSON, err := decryptDiaryContent(encDiary.EncryptedMetadata, encDiary.MetadataIV, dataKey, diaryMetadataAAD(encDiary.ID, encDiary.UserID, encDiary.EncryptionMode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt diary metadata: %v", err)
+	}
+
+	var metadata diaryMetadata
+	if err := json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse diary metadata: %v", err)
+	}
+
+	if metadata.Tags == nil {
+		metadata.Tags = []string{}
+	}
+	return &metadata, nil
+}
+
+// lockedDiary returns a placeholder for an individually encrypted diary
+func lockedDiary(encDiary *EncryptedDiary) *Diary {
+	title := encDiary.Title
+	if encDiary.MetadataIV != "" {
+		title = "[加密日记]"
+	}
+
+	return &Diary{
+		ID:        encDiary.ID,
+		Title:     title,
+		Content:   "[此日记需要单独密码解锁]",
+		FileName:  "",
+		FileType:  encDiary.FileType,
+		Tags:      []string{},
+		CreatedAt: encDiary.CreatedAt,
+		UpdatedAt: encDiary.UpdatedAt,
+	}
+}
+
+// userEncryptsMetadata reports whether the user wants diary metadata encrypted
+func userEncryptsMetadata(userID uint) (bool, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get metadata encryption setting: %v", err)
+	}
+	return user.EncryptMetadata, nil
+}
+
+// SetMetadataEncryption enables or disables metadata encryption for a user
+// and re-seals the user's unified and biometric diaries accordingly
+func SetMetadataEncryption(userID uint, masterKey []byte, enabled bool) error {
+	if err := gormDB.Model(&User{}).Where("id = ?", userID).Update("encrypt_metadata", enabled).Error; err != nil {
+		return fmt.Errorf("failed to update metadata encryption setting: %v", err)
+	}
+
+	return migrateDiaryMetadata(userID, masterKey)
+}
+
+// migrateDiaryMetadata re-seals unified and biometric diaries whose metadata
+// encryption state does not match the user's setting. Individually encrypted
+// diaries cannot be opened with the master key, so their titles and file names
+// stay as they are until the diary is next opened or saved with its password.
+func migrateDiaryMetadata(userID uint, masterKey []byte) error {
+	encryptMetadata, err := userEncryptsMetadata(userID)
+	if err != nil {
+		return err
+	}
+
+	query := gormDB.Unscoped().Where("user_id = ? AND encryption_mode IN ?", userID, []string{"unified", "biometric"})
+	if encryptMetadata {
+		query = query.Where("metadata_iv IS NULL OR metadata_iv = ''")
+	} else {
+		query = query.Where("metadata_iv IS NOT NULL AND metadata_iv <> ''")
+	}
+
+	var encDiaries []EncryptedDiary
+	if err := query.Find(&encDiaries).Error; err != nil {
+		return fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	if len(encDiaries) == 0 {
+		return nil
+	}
+
+	// A diary that cannot be opened or resealed is skipped rather than failing
+	// the others; it is retried at the next login.
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		for _, encDiary := range encDiaries {
+			diary, err := openEncryptedDiary(&encDiary, masterKey)
+			if err != nil {
+				fmt.Printf("Skipping metadata migration of diary %s: failed to decrypt: %v\n", encDiary.ID, err)
+				continue
+			}
+
+			sealed, err := sealDiary(diary, userID, encDiary.EncryptionMode, masterKey, encryptMetadata)
+			if err != nil {
+				fmt.Printf("Skipping metadata migration of diary %s: %v\n", encDiary.ID, err)
+				continue
+			}
+
+			if err := tx.Unscoped().Model(&EncryptedDiary{}).Where("id = ?", encDiary.ID).Updates(sealed.columns()).Error; err != nil {
+				return fmt.Errorf("failed to update diary %s: %v", encDiary.ID, err)
+			}
+		}
+		return nil
+	})
 }
 
 // decryptDiaryContent decrypts diary content
-func decryptDiaryContent(encryptedContent []byte, ivStr string, encryptionKey []byte) (string, error) {
+func decryptDiaryContent(encryptedContent []byte, ivStr string, encryptionKey []byte, aad []byte) (string, error) {
 	iv, err := base64.StdEncoding.DecodeString(ivStr)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode IV: %v", err)
 	}
 
-	decryptedData, err := DecryptData(encryptedContent, encryptionKey, iv)
+	decryptedData, err := DecryptDataWithAAD(encryptedContent, encryptionKey, iv, aad)
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt content: %v", err)
 	}
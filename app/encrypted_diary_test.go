@@ -0,0 +1,73 @@
+package app
+
+import "testing"
+
+func TestSaveFailsWithoutMetadataSetting(t *testing.T) {
+	setupTestDatabase(t)
+	_, masterKey := createTestUser(t, "alice", "password123")
+
+	diary := &Diary{ID: "orphan", Title: "标题", Content: "内容", Tags: []string{}}
+	if err := SaveEncryptedDiaryWithOptions(diary, 999, masterKey, &DiaryEncryptionOptions{Mode: "unified"}); err == nil {
+		t.Fatal("diary saved although the metadata setting could not be read")
+	}
+
+	var count int64
+	if err := gormDB.Model(&EncryptedDiary{}).Where("id = ?", diary.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("diary row was stored")
+	}
+}
+
+func TestIndividualMetadataSealedWhenOpened(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+	if err := SetMetadataEncryption(user.ID, masterKey, false); err != nil {
+		t.Fatal(err)
+	}
+
+	diary := &Diary{ID: "private", Title: "私密标题", Content: "只有密码才能看", Tags: []string{}}
+	if err := SaveEncryptedDiaryWithOptions(diary, user.ID, masterKey, &DiaryEncryptionOptions{
+		Mode:               "individual",
+		IndividualPassword: "diary-password",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetMetadataEncryption(user.ID, masterKey, true); err != nil {
+		t.Fatalf("SetMetadataEncryption: %v", err)
+	}
+
+	// Without the diary password the title cannot be sealed yet
+	var row EncryptedDiary
+	if err := gormDB.First(&row, "id = ?", diary.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if row.Title != diary.Title || row.MetadataIV != "" {
+		t.Fatalf("individual diary changed without its password: title %q, metadata IV %q", row.Title, row.MetadataIV)
+	}
+
+	opened, err := GetEncryptedDiaryWithPassword(diary.ID, user.ID, "diary-password", masterKey)
+	if err != nil {
+		t.Fatalf("GetEncryptedDiaryWithPassword: %v", err)
+	}
+	if opened.Title != diary.Title {
+		t.Errorf("title = %q, want %q", opened.Title, diary.Title)
+	}
+
+	if err := gormDB.First(&row, "id = ?", diary.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if row.Title != "" || row.MetadataIV == "" {
+		t.Errorf("metadata not sealed after opening: title %q, metadata IV %q", row.Title, row.MetadataIV)
+	}
+	if locked := lockedDiary(&row); locked.Title == diary.Title {
+		t.Error("locked placeholder shows the sealed title")
+	}
+
+	reopened, err := GetEncryptedDiaryWithPassword(diary.ID, user.ID, "diary-password", masterKey)
+	if err != nil || reopened.Title != diary.Title || reopened.Content != diary.Content {
+		t.Errorf("reopened diary = %+v, %v", reopened, err)
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -19,6 +22,20 @@ const (
 	iterations = 100000
 )
 
+// Argon2id parameters for password hashing
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 2
+	argon2KeyLen  = 32
+)
+
+// Versioned ciphertext header written in front of AEAD ciphertexts that bind
+// associated data. Ciphertexts without the header use the original format.
+const ciphertextVersion byte = 2
+
+var ciphertextMagic = []byte("MS")
+
 // EncryptionKey represents an encryption key with salt
 type EncryptionKey struct {
 	Key  []byte
@@ -47,6 +64,60 @@ func DeriveKey(password string, salt []byte) []byte {
 
 // EncryptData encrypts data using AES-256-GCM
 func EncryptData(data []byte, key []byte) ([]byte, []byte, error) {
+	return sealGCM(data, key, nil)
+}
+
+// DecryptData decrypts data using AES-256-GCM
+func DecryptData(ciphertext []byte, key []byte, iv []byte) ([]byte, error) {
+	return openGCM(ciphertext, key, iv, nil)
+}
+
+// EncryptDataWithAAD encrypts data using AES-256-GCM, binding the associated data.
+// The returned ciphertext starts with a versioned header which is authenticated as well.
+func EncryptDataWithAAD(data []byte, key []byte, aad []byte) ([]byte, []byte, error) {
+	header := ciphertextHeader()
+
+	ciphertext, iv, err := sealGCM(data, key, append(header, aad...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(header, ciphertext...), iv, nil
+}
+
+// DecryptDataWithAAD decrypts data produced by EncryptDataWithAAD.
+// Ciphertexts without a version header are decrypted in the legacy format without associated data.
+func DecryptDataWithAAD(ciphertext []byte, key []byte, iv []byte, aad []byte) ([]byte, error) {
+	header := ciphertextHeader()
+	if !HasCiphertextHeader(ciphertext) {
+		return openGCM(ciphertext, key, iv, nil)
+	}
+
+	plaintext, err := openGCM(ciphertext[len(header):], key, iv, append(header, aad...))
+	if err != nil {
+		// A legacy ciphertext may start with the header bytes by chance
+		if legacy, legacyErr := openGCM(ciphertext, key, iv, nil); legacyErr == nil {
+			return legacy, nil
+		}
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// HasCiphertextHeader reports whether the ciphertext uses the current versioned format
+func HasCiphertextHeader(ciphertext []byte) bool {
+	header := ciphertextHeader()
+	return len(ciphertext) > len(header) && string(ciphertext[:len(header)]) == string(header)
+}
+
+// ciphertextHeader returns the magic bytes followed by the format version
+func ciphertextHeader() []byte {
+	return append(append([]byte{}, ciphertextMagic...), ciphertextVersion)
+}
+
+// sealGCM encrypts data with AES-256-GCM and a random IV
+func sealGCM(data []byte, key []byte, aad []byte) ([]byte, []byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create cipher: %v", err)
@@ -64,13 +135,13 @@ func EncryptData(data []byte, key []byte) ([]byte, []byte, error) {
 	}
 
 	// Encrypt data
-	ciphertext := gcm.Seal(nil, iv, data, nil)
+	ciphertext := gcm.Seal(nil, iv, data, aad)
 
 	return ciphertext, iv, nil
 }
 
-// DecryptData decrypts data using AES-256-GCM
-func DecryptData(ciphertext []byte, key []byte, iv []byte) ([]byte, error) {
+// openGCM decrypts data with AES-256-GCM
+func openGCM(ciphertext []byte, key []byte, iv []byte, aad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %v", err)
@@ -81,8 +152,12 @@ func DecryptData(ciphertext []byte, key []byte, iv []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to create GCM: %v", err)
 	}
 
+	if len(iv) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid IV length: %d", len(iv))
+	}
+
 	// Decrypt data
-	plaintext, err := gcm.Open(nil, iv, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, iv, ciphertext, aad)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt data: %v", err)
 	}
@@ -121,14 +196,93 @@ func DecryptString(ciphertext string, ivStr string, key []byte) (string, error)
 	return string(plaintext), nil
 }
 
-// HashPassword creates a SHA-256 hash of the password with salt
-func HashPassword(password string, salt []byte) string {
-	hash := sha256.Sum256(append([]byte(password), salt...))
-	return base64.StdEncoding.EncodeToString(hash[:])
+// HashPassword creates an Argon2id hash of the password.
+// The result uses the PHC string format so the salt and parameters are stored
+// alongside the hash: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyPassword verifies a password against its hash in constant time.
+// The salt is only used for legacy SHA-256 hashes. The second return value
+// reports whether the stored hash should be replaced with a fresh HashPassword.
+func VerifyPassword(password string, hash string, salt []byte) (bool, bool) {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		expectedHash := legacyHashPassword(password, salt)
+		return subtle.ConstantTimeCompare([]byte(expectedHash), []byte(hash)) == 1, true
+	}
+
+	params, hashSalt, expectedHash, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, false
+	}
+
+	actualHash := argon2.IDKey([]byte(password), hashSalt, params.time, params.memory, params.threads, uint32(len(expectedHash)))
+	if subtle.ConstantTimeCompare(actualHash, expectedHash) != 1 {
+		return false, false
+	}
+
+	needsRehash := params.version != argon2.Version ||
+		params.memory != argon2Memory ||
+		params.time != argon2Time ||
+		params.threads != argon2Threads ||
+		len(expectedHash) != argon2KeyLen
+
+	return true, needsRehash
+}
+
+// argon2Params holds the parameters encoded in a stored Argon2id hash
+type argon2Params struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
 }
 
-// VerifyPassword verifies a password against its hash
-func VerifyPassword(password string, hash string, salt []byte) bool {
-	expectedHash := HashPassword(password, salt)
-	return expectedHash == hash
+// decodeArgon2Hash parses a PHC formatted Argon2id hash
+func decodeArgon2Hash(encoded string) (*argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id hash format")
+	}
+
+	params := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &params.version); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id version: %v", err)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id parameters: %v", err)
+	}
+	if params.time == 0 || params.threads == 0 {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode argon2id salt: %v", err)
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode argon2id hash: %v", err)
+	}
+
+	return params, salt, hash, nil
+}
+
+// legacyHashPassword reproduces the original SHA-256 password hash so that
+// existing accounts can still log in and be upgraded
+func legacyHashPassword(password string, salt []byte) string {
+	hash := sha256.Sum256(append([]byte(password), salt...))
+	return base64.StdEncoding.EncodeToString(hash[:])
 }
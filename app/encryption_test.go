@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDataWithAADRoundTrip(t *testing.T) {
+	key := mustGenerateDataKey(t)
+
+	tests := []struct {
+		name string
+		data []byte
+		aad  []byte
+	}{
+		{"text", []byte("今天天气很好"), []byte("moodstack/diary/v2|a|1|unified")},
+		{"empty data", []byte{}, []byte("aad")},
+		{"no associated data", []byte("secret"), nil},
+		{"binary", []byte{0, 1, 2, 255}, []byte{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciphertext, iv, err := EncryptDataWithAAD(tt.data, key, tt.aad)
+			if err != nil {
+				t.Fatalf("EncryptDataWithAAD: %v", err)
+			}
+			if !HasCiphertextHeader(ciphertext) {
+				t.Error("ciphertext has no version header")
+			}
+
+			plaintext, err := DecryptDataWithAAD(ciphertext, key, iv, tt.aad)
+			if err != nil {
+				t.Fatalf("DecryptDataWithAAD: %v", err)
+			}
+			if !bytes.Equal(plaintext, tt.data) {
+				t.Errorf("DecryptDataWithAAD = %q, want %q", plaintext, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecryptDataWithAADRejects(t *testing.T) {
+	key := mustGenerateDataKey(t)
+	aad := diaryAAD("diary-1", 1, "unified")
+
+	ciphertext, iv, err := EncryptDataWithAAD([]byte("今天天气很好"), key, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+
+	downgraded := append([]byte{}, ciphertext...)
+	downgraded[len(ciphertextMagic)] = ciphertextVersion + 1
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		key        []byte
+		aad        []byte
+	}{
+		{"other diary", ciphertext, key, diaryAAD("diary-2", 1, "unified")},
+		{"other user", ciphertext, key, diaryAAD("diary-1", 2, "unified")},
+		{"other mode", ciphertext, key, diaryAAD("diary-1", 1, "biometric")},
+		{"no associated data", ciphertext, key, nil},
+		{"wrong key", ciphertext, mustGenerateDataKey(t), aad},
+		{"tampered ciphertext", tampered, key, aad},
+		{"changed version", downgraded, key, aad},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecryptDataWithAAD(tt.ciphertext, tt.key, iv, tt.aad); err == nil {
+				t.Error("DecryptDataWithAAD succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDecryptDataWithAADLegacy(t *testing.T) {
+	key := mustGenerateDataKey(t)
+
+	ciphertext, iv, err := EncryptData([]byte("旧格式"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := DecryptDataWithAAD(ciphertext, key, iv, []byte("ignored"))
+	if err != nil {
+		t.Fatalf("DecryptDataWithAAD: %v", err)
+	}
+	if string(plaintext) != "旧格式" {
+		t.Errorf("DecryptDataWithAAD = %q, want %q", plaintext, "旧格式")
+	}
+}
+
+func TestOpenEncryptedDiaryRejectsMovedCiphertext(t *testing.T) {
+	masterKey := mustGenerateDataKey(t)
+	diary := &Diary{ID: "diary-1", Title: "标题", Content: "内容"}
+
+	sealed, err := sealDiary(diary, 1, "unified", masterKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		userID uint
+		mode   string
+		ok     bool
+	}{
+		{"same row", "diary-1", 1, "unified", true},
+		{"other diary", "diary-2", 1, "unified", false},
+		{"other user", "diary-1", 2, "unified", false},
+		{"other mode", "diary-1", 1, "biometric", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encDiary := &EncryptedDiary{ID: tt.id, UserID: tt.userID, EncryptionMode: tt.mode}
+			sealed.apply(encDiary)
+
+			_, err := openEncryptedDiary(encDiary, masterKey)
+			if tt.ok && err != nil {
+				t.Errorf("openEncryptedDiary: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("openEncryptedDiary succeeded, want an error")
+			}
+		})
+	}
+}
@@ -28,6 +28,9 @@ type SearchResult struct {
 	Diary           Diary    `json:"diary"`
 	MatchedSnippets []string `json:"matchedSnippets"`
 	MatchType       string   `json:"matchType"` // "title" or "content" or "both"
+
+	// Matched ranges in the content, only set by structured queries
+	Highlights []TextRange `json:"highlights,omitempty"`
 }
 
 // FileInfo represents information about an uploaded file
@@ -157,10 +160,11 @@ func IsFileTypeSupported(fileType string) bool {
 }
 
 // SearchDiaries searches for diaries by a query string in title and content
-func SearchDiaries(query string) ([]Diary, error) {
+// and returns a page of them along with the number of matches
+func SearchDiaries(query string, opts SearchOptions) ([]Diary, int, error) {
 	allDiaries, err := GetDiariesList()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var matchedDiaries []Diary
@@ -172,13 +176,13 @@ func SearchDiaries(query string) ([]Diary, error) {
 		}
 	}
 
-	return matchedDiaries, nil
+	return searchPageOf(matchedDiaries, opts), len(matchedDiaries), nil
 }
 
-// SearchDiariesWithContext searches for diaries and returns detailed search results with context
-func SearchDiariesWithContext(query string) ([]SearchResult, error) {
+// SearchDiariesWithContext searches for diaries and returns a page of detailed search results with context
+func SearchDiariesWithContext(query string, opts SearchOptions) (*SearchPage, error) {
 	if query == "" {
-		return []SearchResult{}, nil
+		return &SearchPage{Results: []SearchResult{}}, nil
 	}
 
 	allDiaries, err := GetDiariesList()
@@ -186,7 +190,7 @@ func SearchDiariesWithContext(query string) ([]SearchResult, error) {
 		return nil, err
 	}
 
-	var results []SearchResult
+	results := []SearchResult{}
 	lowerCaseQuery := strings.ToLower(query)
 
 	for _, diary := range allDiaries {
@@ -219,7 +223,7 @@ func SearchDiariesWithContext(query string) ([]SearchResult, error) {
 		}
 	}
 
-	return results, nil
+	return &SearchPage{Results: searchPageOf(results, opts), Total: len(results)}, nil
 }
 
 // extractSnippets extracts text snippets around the matched query
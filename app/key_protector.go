@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// KeyProtector protects small secrets (such as the biometric unlock secret)
+// so that they are never stored in clear text in the database
+type KeyProtector interface {
+	// Name identifies the protector; it prefixes every protected blob
+	Name() string
+	// Protect wraps the secret and returns an opaque string safe to persist
+	Protect(secret []byte) (string, error)
+	// Unprotect recovers a secret produced by Protect
+	Unprotect(protected string) ([]byte, error)
+	// OSBacked reports whether the secrets are guarded by the operating system
+	// rather than by a key stored alongside the application data
+	OSBacked() bool
+}
+
+var (
+	keyProtector     KeyProtector
+	keyProtectorOnce sync.Once
+)
+
+// GetKeyProtector returns the key protector for the current platform
+func GetKeyProtector() KeyProtector {
+	keyProtectorOnce.Do(func() {
+		if keyProtector == nil {
+			keyProtector = newPlatformKeyProtector()
+		}
+	})
+	return keyProtector
+}
+
+// SetKeyProtector overrides the platform key protector
+func SetKeyProtector(protector KeyProtector) {
+	keyProtectorOnce.Do(func() {})
+	keyProtector = protector
+}
+
+// encodeProtectedBlob prefixes a protected payload with the protector name
+func encodeProtectedBlob(name string, payload []byte) string {
+	return name + ":" + base64.StdEncoding.EncodeToString(payload)
+}
+
+// decodeProtectedBlob checks the protector name and returns the protected payload
+func decodeProtectedBlob(name string, protected string) ([]byte, error) {
+	prefix := name + ":"
+	if !strings.HasPrefix(protected, prefix) {
+		return nil, fmt.Errorf("secret was not protected by %s", name)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(protected, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode protected secret: %v", err)
+	}
+	return payload, nil
+}
+
+// FileKeyProtector wraps secrets with a random key kept in a separate file
+// readable only by the current user. The key file lives next to the database,
+// so it only keeps secrets out of the database itself; anyone who can read the
+// data directory can unwrap them. It is used on platforms without a native
+// secret store and in tests.
+type FileKeyProtector struct {
+	Path string
+}
+
+// NewFileKeyProtector creates a file-backed key protector
+func NewFileKeyProtector(path string) *FileKeyProtector {
+	return &FileKeyProtector{Path: path}
+}
+
+// Name returns the protector name
+func (p *FileKeyProtector) Name() string {
+	return "file"
+}
+
+// OSBacked reports false: the file key sits beside the protected secrets
+func (p *FileKeyProtector) OSBacked() bool {
+	return false
+}
+
+// Protect wraps the secret with the file key
+func (p *FileKeyProtector) Protect(secret []byte) (string, error) {
+	fileKey, err := p.loadKey(true)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := WrapKey(secret, fileKey)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeProtectedBlob(p.Name(), []byte(wrapped)), nil
+}
+
+// Unprotect unwraps a secret with the file key
+func (p *FileKeyProtector) Unprotect(protected string) ([]byte, error) {
+	payload, err := decodeProtectedBlob(p.Name(), protected)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := p.loadKey(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnwrapKey(string(payload), fileKey)
+}
+
+// loadKey reads the protector key, creating it if requested
+func (p *FileKeyProtector) loadKey(create bool) ([]byte, error) {
+	data, err := os.ReadFile(p.Path)
+	if err == nil {
+		if len(data) != keySize {
+			return nil, fmt.Errorf("invalid key file: %s", p.Path)
+		}
+		return data, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %v", err)
+	}
+
+	key, err := GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(p.Path, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %v", err)
+	}
+
+	return key, nil
+}
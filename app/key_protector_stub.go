@@ -0,0 +1,11 @@
+//go:build !windows
+
+package app
+
+import "path/filepath"
+
+// newPlatformKeyProtector returns the file key protector: non-Windows systems
+// have no native secret store wired up yet
+func newPlatformKeyProtector() KeyProtector {
+	return NewFileKeyProtector(filepath.Join("data", "biometric.key"))
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileKeyProtectorRoundTrip(t *testing.T) {
+	protector := NewFileKeyProtector(filepath.Join(t.TempDir(), "data", "test.key"))
+	if protector.OSBacked() {
+		t.Error("file key protector reports being OS backed")
+	}
+
+	secret := mustGenerateDataKey(t)
+	protected, err := protector.Protect(secret)
+	if err != nil {
+		t.Fatalf("Protect: %v", err)
+	}
+	if !strings.HasPrefix(protected, protector.Name()+":") {
+		t.Errorf("protected secret %q lacks the %q prefix", protected, protector.Name())
+	}
+
+	got, err := protector.Unprotect(protected)
+	if err != nil {
+		t.Fatalf("Unprotect: %v", err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Error("Unprotect did not return the original secret")
+	}
+
+	if _, err := protector.Unprotect("dpapi:" + strings.TrimPrefix(protected, "file:")); err == nil {
+		t.Error("Unprotect accepted a blob of another protector")
+	}
+
+	other := NewFileKeyProtector(filepath.Join(t.TempDir(), "other.key"))
+	if _, err := other.Unprotect(protected); err == nil {
+		t.Error("Unprotect succeeded without the key file")
+	}
+}
+
+func TestEnableBiometricRefusedWithoutOSProtector(t *testing.T) {
+	setupTestDatabase(t)
+	user, _ := createTestUser(t, "alice", "correct horse")
+
+	if support, _ := BiometricSupport(); support.Supported && GetKeyProtector().OSBacked() {
+		t.Skip("biometric unlock is available on this system")
+	}
+
+	if err := EnableBiometricForUser(user.ID, "correct horse"); err == nil {
+		t.Fatal("EnableBiometricForUser succeeded without a secure key protector")
+	}
+
+	stored, err := GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if stored.BiometricEnabled || stored.BiometricProtectedKey != "" {
+		t.Error("biometric unlock was enrolled")
+	}
+}
@@ -0,0 +1,117 @@
+//go:build windows
+
+package app
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Windows DPAPI exports
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+const cryptProtectUIForbidden = 0x1
+
+// dataBlob mirrors the Win32 DATA_BLOB structure
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+func newDataBlob(data []byte) *dataBlob {
+	if len(data) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{cbData: uint32(len(data)), pbData: &data[0]}
+}
+
+func (b *dataBlob) bytes() []byte {
+	out := make([]byte, b.cbData)
+	copy(out, unsafe.Slice(b.pbData, b.cbData))
+	return out
+}
+
+// dpapiEntropy binds protected secrets to this application. The value
+// predates the protector's rename and must not change.
+var dpapiEntropy = []byte("MoodStack Windows Hello unlock")
+
+// legacyDPAPIProtectorName prefixed blobs written before the protector was renamed
+const legacyDPAPIProtectorName = "windows-hello"
+
+// DPAPIKeyProtector protects secrets with DPAPI so they can only be recovered
+// by the current Windows account. DPAPI itself does not involve Windows Hello;
+// the biometric unlock only unprotects its secret after Windows Hello
+// verification has succeeded.
+type DPAPIKeyProtector struct{}
+
+// newPlatformKeyProtector returns the DPAPI protector
+func newPlatformKeyProtector() KeyProtector {
+	return &DPAPIKeyProtector{}
+}
+
+// Name returns the protector name
+func (p *DPAPIKeyProtector) Name() string {
+	return "dpapi"
+}
+
+// OSBacked reports true: DPAPI keys are managed by Windows
+func (p *DPAPIKeyProtector) OSBacked() bool {
+	return true
+}
+
+// Protect encrypts the secret with CryptProtectData
+func (p *DPAPIKeyProtector) Protect(secret []byte) (string, error) {
+	var out dataBlob
+	r1, _, err := procCryptProtectData.Call(
+		uintptr(unsafe.Pointer(newDataBlob(secret))),
+		0,
+		uintptr(unsafe.Pointer(newDataBlob(dpapiEntropy))),
+		0,
+		0,
+		cryptProtectUIForbidden,
+		uintptr(unsafe.Pointer(&out)),
+	)
+	if r1 == 0 {
+		return "", fmt.Errorf("CryptProtectData失败: %v", err)
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+
+	return encodeProtectedBlob(p.Name(), out.bytes()), nil
+}
+
+// Unprotect decrypts a secret with CryptUnprotectData
+func (p *DPAPIKeyProtector) Unprotect(protected string) ([]byte, error) {
+	name := p.Name()
+	if strings.HasPrefix(protected, legacyDPAPIProtectorName+":") {
+		name = legacyDPAPIProtectorName
+	}
+	payload, err := decodeProtectedBlob(name, protected)
+	if err != nil {
+		return nil, err
+	}
+
+	var out dataBlob
+	r1, _, callErr := procCryptUnprotectData.Call(
+		uintptr(unsafe.Pointer(newDataBlob(payload))),
+		0,
+		uintptr(unsafe.Pointer(newDataBlob(dpapiEntropy))),
+		0,
+		0,
+		cryptProtectUIForbidden,
+		uintptr(unsafe.Pointer(&out)),
+	)
+	if r1 == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData失败: %v", callErr)
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+
+	return out.bytes(), nil
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Envelope encryption
+//
+// Each user has a random master data key. The master key is never stored in
+// clear text: it is wrapped by the key derived from the login password
+// (User.WrappedMasterKey) and, when biometric unlock is enabled, separately
+// by a biometric unlock secret (User.BiometricWrappedKey). Every diary is
+// encrypted with its own random data key which is wrapped by the master key
+// (or by the individual password key for individual-mode diaries) and stored
+// in EncryptedDiary.WrappedKey. Changing the password or enrolling biometric
+// unlock therefore only re-wraps the master key.
+
+// GenerateDataKey generates a new random data key
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a key with a key-encryption key and returns base64(iv || ciphertext)
+func WrapKey(key []byte, kek []byte) (string, error) {
+	ciphertext, iv, err := EncryptData(key, kek)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(append(iv, ciphertext...)), nil
+}
+
+// UnwrapKey decrypts a key produced by WrapKey
+func UnwrapKey(wrapped string, kek []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped key: %v", err)
+	}
+
+	// AES-GCM standard nonce size
+	nonceSize := 12
+	if len(data) <= nonceSize {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+
+	key, err := DecryptData(data[nonceSize:], kek, data[:nonceSize])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %v", err)
+	}
+
+	return key, nil
+}
+
+// WipeKey overwrites a key in memory with zeros
+func WipeKey(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+// UnlockMasterKey returns the user's master data key using the login password.
+// Users created before envelope encryption get a master key on first unlock.
+func UnlockMasterKey(user *User, password string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(user.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %v", err)
+	}
+
+	return unlockMasterKeyWithKEK(user, DeriveKey(password, salt))
+}
+
+// UnlockMasterKeyWithBiometric returns the user's master data key using the biometric unlock secret
+func UnlockMasterKeyWithBiometric(user *User) ([]byte, error) {
+	secret, err := loadBiometricSecret(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.BiometricWrappedKey != "" {
+		return UnwrapKey(user.BiometricWrappedKey, secret)
+	}
+
+	// Legacy biometric enrollment stored the password-derived key itself
+	masterKey, err := unlockMasterKeyWithKEK(user, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.BiometricWrappedKey == "" {
+		if err := enrollBiometricKey(gormDB, user, masterKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return masterKey, nil
+}
+
+// loadBiometricSecret recovers the biometric unlock secret from the key protector
+func loadBiometricSecret(user *User) ([]byte, error) {
+	if user.BiometricProtectedKey != "" {
+		secret, err := GetKeyProtector().Unprotect(user.BiometricProtectedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unprotect biometric key: %v", err)
+		}
+		return secret, nil
+	}
+
+	// Plain value that has not been migrated yet
+	if user.BiometricKey != "" {
+		secret, err := base64.StdEncoding.DecodeString(user.BiometricKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode biometric key: %v", err)
+		}
+		return secret, nil
+	}
+
+	return nil, fmt.Errorf("biometric unlock is not configured")
+}
+
+// unlockMasterKeyWithKEK unwraps the master key, creating it for legacy users
+func unlockMasterKeyWithKEK(user *User, kek []byte) ([]byte, error) {
+	if user.WrappedMasterKey == "" {
+		return initializeMasterKey(user, kek)
+	}
+
+	masterKey, err := UnwrapKey(user.WrappedMasterKey, kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock master key: %v", err)
+	}
+
+	return masterKey, nil
+}
+
+// initializeMasterKey creates a master key for a user and adopts existing diaries.
+// Unified and biometric diaries written before envelope encryption were encrypted
+// directly with the password-derived key, so that key becomes their wrapped data key.
+func initializeMasterKey(user *User, kek []byte) ([]byte, error) {
+	masterKey, err := GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedMasterKey, err := WrapKey(masterKey, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gormDB.Transaction(func(tx *gorm.DB) error {
+		var legacyDiaries []EncryptedDiary
+		if err := tx.Select("id").
+			Where("user_id = ? AND encryption_mode IN ? AND (wrapped_key IS NULL OR wrapped_key = '')", user.ID, []string{"unified", "biometric"}).
+			Find(&legacyDiaries).Error; err != nil {
+			return fmt.Errorf("failed to query legacy diaries: %v", err)
+		}
+
+		for _, legacy := range legacyDiaries {
+			wrappedKey, err := WrapKey(kek, masterKey)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&EncryptedDiary{}).Where("id = ?", legacy.ID).Update("wrapped_key", wrappedKey).Error; err != nil {
+				return fmt.Errorf("failed to update diary %s: %v", legacy.ID, err)
+			}
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Update("wrapped_master_key", wrappedMasterKey).Error; err != nil {
+			return fmt.Errorf("failed to store master key: %v", err)
+		}
+
+		if user.BiometricEnabled {
+			return enrollBiometricKey(tx, user, masterKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize master key: %v", err)
+	}
+
+	user.WrappedMasterKey = wrappedMasterKey
+	return masterKey, nil
+}
+
+// enrollBiometricKey generates a new biometric unlock secret and wraps the master key with it
+func enrollBiometricKey(tx *gorm.DB, user *User, masterKey []byte) error {
+	secret, err := GenerateDataKey()
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := WrapKey(masterKey, secret)
+	if err != nil {
+		return err
+	}
+
+	protector := GetKeyProtector()
+	if !protector.OSBacked() {
+		fmt.Printf("Warning: biometric key of user %d is protected by %s, whose key is stored with the application data\n", user.ID, protector.Name())
+	}
+	protectedKey, err := protector.Protect(secret)
+	if err != nil {
+		return fmt.Errorf("failed to protect biometric key: %v", err)
+	}
+
+	if err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"biometric_enabled":       true,
+		"biometric_key":           "",
+		"biometric_wrapped_key":   wrappedKey,
+		"biometric_protected_key": protectedKey,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to store biometric key: %v", err)
+	}
+
+	user.BiometricEnabled = true
+	user.BiometricKey = ""
+	user.BiometricWrappedKey = wrappedKey
+	user.BiometricProtectedKey = protectedKey
+	return nil
+}
+
+// resolveDiaryKey returns the data key used to encrypt a diary's content.
+// Diaries saved before envelope encryption have no wrapped key and were encrypted with kek directly.
+func resolveDiaryKey(encDiary *EncryptedDiary, kek []byte) ([]byte, error) {
+	if encDiary.WrappedKey == "" {
+		return kek, nil
+	}
+	return UnwrapKey(encDiary.WrappedKey, kek)
+}
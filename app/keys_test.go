@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapKeyRoundTrip(t *testing.T) {
+	kek, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"data key", mustGenerateDataKey(t)},
+		{"short key", []byte{1, 2, 3}},
+		{"zero key", make([]byte, keySize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := WrapKey(tt.key, kek)
+			if err != nil {
+				t.Fatalf("WrapKey: %v", err)
+			}
+			key, err := UnwrapKey(wrapped, kek)
+			if err != nil {
+				t.Fatalf("UnwrapKey: %v", err)
+			}
+			if !bytes.Equal(key, tt.key) {
+				t.Errorf("UnwrapKey = %x, want %x", key, tt.key)
+			}
+		})
+	}
+}
+
+func TestUnwrapKeyRejects(t *testing.T) {
+	kek := mustGenerateDataKey(t)
+	wrapped, err := WrapKey(mustGenerateDataKey(t), kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		wrapped string
+		kek     []byte
+	}{
+		{"wrong key-encryption key", wrapped, mustGenerateDataKey(t)},
+		{"not base64", "not base64!", kek},
+		{"too short", "AAAA", kek},
+		{"tampered", wrapped[:len(wrapped)-4] + "AAAA", kek},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnwrapKey(tt.wrapped, tt.kek); err == nil {
+				t.Error("UnwrapKey succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestSealDiaryUsesFreshDataKeys(t *testing.T) {
+	masterKey := mustGenerateDataKey(t)
+	diary := &Diary{ID: "diary-1", Title: "标题", Content: "今天很开心", Tags: []string{"生活"}}
+
+	for _, encryptMetadata := range []bool{false, true} {
+		first, err := sealDiary(diary, 1, "unified", masterKey, encryptMetadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := sealDiary(diary, 1, "unified", masterKey, encryptMetadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		firstKey, err := UnwrapKey(first.WrappedKey, masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secondKey, err := UnwrapKey(second.WrappedKey, masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(firstKey, secondKey) || bytes.Equal(firstKey, masterKey) {
+			t.Errorf("encryptMetadata=%v: diaries share a data key", encryptMetadata)
+		}
+
+		encDiary := &EncryptedDiary{ID: diary.ID, UserID: 1, EncryptionMode: "unified"}
+		first.apply(encDiary)
+		opened, err := openEncryptedDiary(encDiary, masterKey)
+		if err != nil {
+			t.Fatalf("encryptMetadata=%v: openEncryptedDiary: %v", encryptMetadata, err)
+		}
+		if opened.Title != diary.Title || opened.Content != diary.Content || len(opened.Tags) != 1 || opened.Tags[0] != "生活" {
+			t.Errorf("encryptMetadata=%v: opened %+v, want %+v", encryptMetadata, opened, diary)
+		}
+		if encryptMetadata && encDiary.Title != "" {
+			t.Errorf("title stored in clear text: %q", encDiary.Title)
+		}
+	}
+}
+
+func TestResolveDiaryKeyLegacy(t *testing.T) {
+	kek := mustGenerateDataKey(t)
+
+	key, err := resolveDiaryKey(&EncryptedDiary{}, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, kek) {
+		t.Error("diaries without a wrapped key should use the key-encryption key directly")
+	}
+}
+
+func mustGenerateDataKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
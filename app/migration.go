@@ -2,6 +2,8 @@ package app
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -114,9 +116,92 @@ func RunMigrations() error {
 		return fmt.Errorf("failed to add emotion analysis table: %v", err)
 	}
 
+	if err := protectBiometricKeys(); err != nil {
+		return fmt.Errorf("failed to protect biometric keys: %v", err)
+	}
+
+	if err := removeLegacySessions(); err != nil {
+		return fmt.Errorf("failed to remove legacy sessions: %v", err)
+	}
+
+	if err := markTaggedDiaries(); err != nil {
+		return fmt.Errorf("failed to mark tagged diaries: %v", err)
+	}
+
+	return nil
+}
+
+// protectBiometricKeys moves plain biometric_key values into the key protector
+// and clears the plaintext column
+func protectBiometricKeys() error {
+	var users []User
+	if err := gormDB.Where("biometric_key IS NOT NULL AND biometric_key <> ''").Find(&users).Error; err != nil {
+		return fmt.Errorf("failed to query users: %v", err)
+	}
+
+	for _, user := range users {
+		secret, err := base64.StdEncoding.DecodeString(user.BiometricKey)
+		if err != nil {
+			fmt.Printf("Failed to decode biometric key for user %d: %v\n", user.ID, err)
+			continue
+		}
+
+		protectedKey, err := GetKeyProtector().Protect(secret)
+		if err != nil {
+			return err
+		}
+
+		if err := gormDB.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"biometric_key":           "",
+			"biometric_protected_key": protectedKey,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update user %d: %v", user.ID, err)
+		}
+
+		fmt.Printf("Protected biometric key for user %d\n", user.ID)
+	}
+
 	return nil
 }
 
+// markTaggedDiaries sets TagsAssigned on diaries saved before it existed that
+// have tags, so auto-tagging does not refill them once the user clears them
+func markTaggedDiaries() error {
+	return gormDB.Unscoped().Model(&EncryptedDiary{}).
+		Where("tags_assigned = ? AND id IN (?)", false, gormDB.Model(&DiaryTag{}).Select("diary_id")).
+		Update("tags_assigned", true).Error
+}
+
+// removeLegacySessions deletes sessions whose key was stored in clear text.
+// Such sessions were never resumable, so nothing is lost.
+func removeLegacySessions() error {
+	return gormDB.Where("session_key NOT LIKE ?", sessionTokenHashPrefix+"%").Delete(&Session{}).Error
+}
+
+// RunUserMigrations runs data migrations that need the user's unlocked master key.
+// It is called after every successful login. A failing step does not stop the
+// later ones; their errors are returned together.
+func RunUserMigrations(userID uint, masterKey []byte) error {
+	steps := []struct {
+		name    string
+		migrate func(userID uint, masterKey []byte) error
+	}{
+		{"migrate diary metadata", migrateDiaryMetadata},
+		{"migrate emotion analyses", migrateEmotionAnalyses},
+		{"migrate diary tags", migrateDiaryTags},
+		{"migrate diary summaries", migrateDiarySummaries},
+		{"build search index", buildSearchIndex},
+	}
+
+	var errs []error
+	for _, step := range steps {
+		if err := step.migrate(userID, masterKey); err != nil {
+			errs = append(errs, fmt.Errorf("failed to %s: %v", step.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // addEncryptionModeColumns adds encryption_mode and encryption_salt columns to encrypted_diaries table
 func addEncryptionModeColumns() error {
 	// Check if table exists first
@@ -3,6 +3,8 @@ package app
 import (
 	"encoding/json"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // User represents a user in the system
@@ -16,6 +18,21 @@ type User struct {
 	CreatedAt        time.Time `json:"createdAt"`
 	UpdatedAt        time.Time `json:"updatedAt"`
 
+	// Envelope encryption: the user's master data key wrapped by the
+	// password-derived key and by the biometric unlock secret
+	WrappedMasterKey    string `json:"-"`
+	BiometricWrappedKey string `json:"-"`
+
+	// Biometric unlock secret protected by the platform KeyProtector.
+	// BiometricKey only holds legacy plain values until they are migrated.
+	BiometricProtectedKey string `json:"-"`
+
+	// Whether diary titles, tags and file names are encrypted
+	EncryptMetadata bool `gorm:"default:false" json:"encryptMetadata"`
+
+	// Master key wrapped by the recovery key, empty when recovery is disabled
+	RecoveryWrappedKey string `json:"-"`
+
 	// Associations
 	Sessions         []Session        `json:"-"`
 	EncryptedDiaries []EncryptedDiary `json:"-"`
@@ -25,28 +42,43 @@ type User struct {
 type Session struct {
 	ID         string    `gorm:"primaryKey" json:"id"`
 	UserID     uint      `gorm:"not null;index" json:"userId"`
-	SessionKey string    `gorm:"not null" json:"sessionKey"`
+	SessionKey string    `gorm:"not null" json:"-"` // SHA-256 hash of the session token
+	RememberMe bool      `gorm:"default:false" json:"rememberMe"`
+	WrappedKey string    `json:"-"` // Master key wrapped by the session token (remember me only)
 	ExpiresAt  time.Time `gorm:"not null;index" json:"expiresAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
 	CreatedAt  time.Time `json:"createdAt"`
 
+	// Clear-text token, only returned when the session is created
+	Token string `gorm:"-" json:"token,omitempty"`
+	// Whether this is the session of the current app instance
+	Current bool `gorm:"-" json:"current"`
+
 	// Associations
 	User User `json:"-"`
 }
 
 // EncryptedDiary represents an encrypted diary entry in the database
 type EncryptedDiary struct {
-	ID               string    `gorm:"primaryKey" json:"id"`
-	UserID           uint      `gorm:"not null;index" json:"userId"`
-	Title            string    `gorm:"not null" json:"title"`
-	EncryptedContent []byte    `gorm:"not null" json:"-"`
-	IV               string    `gorm:"not null" json:"-"`
-	FileName         string    `json:"fileName"`
-	FileType         string    `json:"fileType"`
-	Tags             string    `gorm:"type:text" json:"-"`
-	EncryptionMode   string    `gorm:"not null;default:'unified'" json:"encryptionMode"`
-	EncryptionSalt   string    `json:"-"`
-	CreatedAt        time.Time `gorm:"index" json:"createdAt"`
-	UpdatedAt        time.Time `json:"updatedAt"`
+	ID                string         `gorm:"primaryKey" json:"id"`
+	UserID            uint           `gorm:"not null;index" json:"userId"`
+	Title             string         `gorm:"not null" json:"title"`
+	EncryptedContent  []byte         `gorm:"not null" json:"-"`
+	IV                string         `gorm:"not null" json:"-"`
+	FileName          string         `json:"fileName"`
+	FileType          string         `json:"fileType"`
+	Tags              string         `gorm:"type:text" json:"-"`
+	EncryptionMode    string         `gorm:"not null;default:'unified'" json:"encryptionMode"`
+	EncryptionSalt    string         `json:"-"`
+	WrappedKey        string         `json:"-"` // Per-diary data key wrapped by the master key or individual key
+	EncryptedMetadata []byte         `json:"-"` // Title, FileName and Tags sealed as one envelope
+	MetadataIV        string         `json:"-"` // Set when metadata is encrypted; plain columns are then empty
+	EncryptedSummary  []byte         `json:"-"` // Excerpt and word count sealed with the data key, for listings
+	SummaryIV         string         `json:"-"`
+	TagsAssigned      bool           `gorm:"not null;default:false" json:"-"` // Set once the diary has had tags; auto-tagging then leaves it alone
+	CreatedAt         time.Time      `gorm:"index" json:"createdAt"`
+	UpdatedAt         time.Time      `gorm:"index" json:"updatedAt"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"` // Set while the diary is in the trash
 
 	// Associations
 	User User `json:"-"`
@@ -84,6 +116,82 @@ type AppSetting struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// Tag is a user's tag. The name is encrypted with a key derived from the
+// master key; NameHash is a keyed hash of the normalized name for lookups.
+type Tag struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	UserID        uint      `gorm:"not null;uniqueIndex:idx_tag_user_name" json:"userId"`
+	NameHash      string    `gorm:"not null;uniqueIndex:idx_tag_user_name" json:"-"`
+	EncryptedName []byte    `gorm:"not null" json:"-"`
+	NameIV        string    `gorm:"not null" json:"-"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// DiaryTag links a diary to a tag
+type DiaryTag struct {
+	DiaryID   string    `gorm:"primaryKey"`
+	TagID     string    `gorm:"primaryKey;index"`
+	UserID    uint      `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// DiaryRevision is an encrypted snapshot of a diary as it was saved. The sealed
+// columns are copied from the diary row, so a revision opens with the same key.
+type DiaryRevision struct {
+	ID                string    `gorm:"primaryKey" json:"id"`
+	DiaryID           string    `gorm:"not null;index" json:"diaryId"`
+	UserID            uint      `gorm:"not null;index" json:"userId"`
+	Title             string    `json:"-"`
+	EncryptedContent  []byte    `gorm:"not null" json:"-"`
+	IV                string    `gorm:"not null" json:"-"`
+	FileName          string    `json:"-"`
+	FileType          string    `json:"fileType"`
+	Tags              string    `gorm:"type:text" json:"-"`
+	EncryptionMode    string    `gorm:"not null" json:"encryptionMode"`
+	EncryptionSalt    string    `json:"-"`
+	WrappedKey        string    `json:"-"`
+	EncryptedMetadata []byte    `json:"-"`
+	MetadataIV        string    `json:"-"`
+	CreatedAt         time.Time `gorm:"index" json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"` // Last coalesced save
+}
+
+// SearchPosting is an entry of the search index: a keyed hash of a term and how
+// often it occurs in a diary. Terms themselves are never stored.
+type SearchPosting struct {
+	UserID   uint   `gorm:"primaryKey;autoIncrement:false"`
+	Token    string `gorm:"primaryKey"`
+	DiaryID  string `gorm:"primaryKey;index"`
+	TermFreq int    `gorm:"not null"`
+}
+
+// SearchDocument records that a diary is indexed and its length in terms
+type SearchDocument struct {
+	DiaryID string `gorm:"primaryKey"`
+	UserID  uint   `gorm:"not null;index"`
+	Length  int    `gorm:"not null"`
+}
+
+// AuthThrottle tracks consecutive failed password attempts for a user or an
+// individually encrypted diary
+type AuthThrottle struct {
+	Subject       string    `gorm:"primaryKey" json:"subject"` // "user:<username>" or "diary:<id>"
+	Failures      int       `gorm:"not null;default:0" json:"failures"`
+	LockedUntil   time.Time `json:"lockedUntil"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// AuthAttempt is an audit record of a password attempt
+type AuthAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Subject   string    `gorm:"not null;index" json:"subject"`
+	UserID    uint      `gorm:"index" json:"userId"` // 0 when the username is unknown
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+}
+
 // TableName overrides the table name for EncryptedDiary
 func (EncryptedDiary) TableName() string {
 	return "encrypted_diaries"
@@ -123,6 +231,15 @@ type EmotionAnalysis struct {
 	// Analysis method used
 	AnalysisMethod string `gorm:"not null" json:"analysisMethod"` // "programmatic", "ai"
 
+	// All analysis fields above sealed with the user's master key. When set, the
+	// plain columns are left empty and filled in memory after decryption.
+	EncryptedPayload []byte `json:"-"`
+	PayloadIV        string `json:"-"`
+
+	// Sentence timeline, sealed separately so it is only decrypted when requested
+	EncryptedTimeline []byte `json:"-"`
+	TimelineIV        string `json:"-"`
+
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 
@@ -156,6 +273,41 @@ func (ea *EmotionAnalysis) SetKeywords(keywords []string) error {
 	return nil
 }
 
+// TableName overrides the table name for Tag
+func (Tag) TableName() string {
+	return "tags"
+}
+
+// TableName overrides the table name for DiaryTag
+func (DiaryTag) TableName() string {
+	return "diary_tags"
+}
+
+// TableName overrides the table name for DiaryRevision
+func (DiaryRevision) TableName() string {
+	return "diary_revisions"
+}
+
+// TableName overrides the table name for SearchPosting
+func (SearchPosting) TableName() string {
+	return "search_postings"
+}
+
+// TableName overrides the table name for SearchDocument
+func (SearchDocument) TableName() string {
+	return "search_documents"
+}
+
+// TableName overrides the table name for AuthThrottle
+func (AuthThrottle) TableName() string {
+	return "auth_throttles"
+}
+
+// TableName overrides the table name for AuthAttempt
+func (AuthAttempt) TableName() string {
+	return "auth_attempts"
+}
+
 // TableName overrides the table name for EmotionAnalysis
 func (EmotionAnalysis) TableName() string {
 	return "emotion_analyses"
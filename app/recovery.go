@@ -0,0 +1,239 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+)
+
+// Recovery keys
+//
+// A recovery key is a random secret shown to the user once, formatted as
+// groups of base32 characters so it can be printed or written down. It wraps
+// the user's master data key independently of the password
+// (User.RecoveryWrappedKey), so a forgotten password can be reset without
+// losing any unified or biometric diary. Individually encrypted diaries keep
+// their own passwords and are not affected.
+const (
+	recoveryKeySize  = 20
+	recoveryGroupLen = 4
+)
+
+// recoveryEncoding is the base32 alphabet used to display recovery keys
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// recoveryThrottleSubject throttles recovery attempts, which are not tied to a username
+const recoveryThrottleSubject = "recovery"
+
+// CreateRecoveryKey generates a new recovery key for the user and wraps the
+// master key with it, replacing any previous recovery key.
+// The returned key is not stored and must be shown to the user.
+func CreateRecoveryKey(userID uint, masterKey []byte) (string, error) {
+	secret := make([]byte, recoveryKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate recovery key: %v", err)
+	}
+
+	kek, err := recoveryWrappingKey(secret)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := WrapKey(masterKey, kek)
+	if err != nil {
+		return "", err
+	}
+
+	if err := gormDB.Model(&User{}).Where("id = ?", userID).Update("recovery_wrapped_key", wrappedKey).Error; err != nil {
+		return "", fmt.Errorf("failed to store recovery key: %v", err)
+	}
+
+	return formatRecoveryKey(secret), nil
+}
+
+// RegenerateRecoveryKey verifies the password and replaces the user's recovery key
+func RegenerateRecoveryKey(userID uint, password string) (string, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return "", fmt.Errorf("用户不存在: %v", err)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(user.Salt)
+	if err != nil {
+		return "", fmt.Errorf("解码salt失败: %v", err)
+	}
+
+	if valid, _ := VerifyPassword(password, user.PasswordHash, salt); !valid {
+		return "", fmt.Errorf("密码验证失败")
+	}
+
+	masterKey, err := UnlockMasterKey(user, password)
+	if err != nil {
+		return "", fmt.Errorf("解锁主密钥失败: %v", err)
+	}
+	defer WipeKey(masterKey)
+
+	return CreateRecoveryKey(user.ID, masterKey)
+}
+
+// HasRecoveryKey reports whether the user has a recovery key
+func HasRecoveryKey(userID uint) (bool, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.RecoveryWrappedKey != "", nil
+}
+
+// RemoveRecoveryKey disables account recovery for the user
+func RemoveRecoveryKey(userID uint) error {
+	if err := gormDB.Model(&User{}).Where("id = ?", userID).Update("recovery_wrapped_key", "").Error; err != nil {
+		return fmt.Errorf("failed to remove recovery key: %v", err)
+	}
+	return nil
+}
+
+// RecoverAccount resets the password of the user owning the recovery key.
+// The master key is re-wrapped with the new password and all sessions are
+// invalidated; diaries are left untouched.
+func RecoverAccount(recoveryKey, newPassword string) (*User, error) {
+	if newPassword == "" {
+		return nil, fmt.Errorf("新密码不能为空")
+	}
+
+	remaining, err := throttleRemaining(recoveryThrottleSubject)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("%s", lockoutMessage(remaining))
+	}
+
+	user, masterKey, err := findRecoveryUser(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		lockout, err := recordFailedAttempt(recoveryThrottleSubject, 0, "wrong recovery key")
+		if err != nil {
+			return nil, err
+		}
+		if lockout > 0 {
+			return nil, fmt.Errorf("%s", lockoutMessage(lockout))
+		}
+		return nil, fmt.Errorf("恢复密钥无效")
+	}
+	defer WipeKey(masterKey)
+
+	// Derive the new key-encryption key and password hash
+	newSalt := make([]byte, saltSize)
+	if _, err := rand.Read(newSalt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	passwordHash, err := HashPassword(newPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	wrappedMasterKey, err := WrapKey(masterKey, DeriveKey(newPassword, newSalt))
+	if err != nil {
+		return nil, err
+	}
+
+	err = gormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"password_hash":      passwordHash,
+			"salt":               base64.StdEncoding.EncodeToString(newSalt),
+			"wrapped_master_key": wrappedMasterKey,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update password: %v", err)
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&Session{}).Error; err != nil {
+			return fmt.Errorf("failed to invalidate sessions: %v", err)
+		}
+
+		// The forgotten password may have locked the user out
+		return tx.Where("subject = ?", userThrottleSubject(user.Username)).Delete(&AuthThrottle{}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("重置密码失败: %v", err)
+	}
+
+	if err := recordSuccessfulAttempt(recoveryThrottleSubject, user.ID); err != nil {
+		return nil, err
+	}
+
+	return GetUserByID(user.ID)
+}
+
+// findRecoveryUser returns the user whose master key is unwrapped by the
+// recovery key, or nil if the key matches no user
+func findRecoveryUser(recoveryKey string) (*User, []byte, error) {
+	secret, err := parseRecoveryKey(recoveryKey)
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	kek, err := recoveryWrappingKey(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var users []User
+	if err := gormDB.Where("recovery_wrapped_key IS NOT NULL AND recovery_wrapped_key <> ''").Find(&users).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to query users: %v", err)
+	}
+
+	for i := range users {
+		if masterKey, err := UnwrapKey(users[i].RecoveryWrappedKey, kek); err == nil {
+			return &users[i], masterKey, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// formatRecoveryKey renders a recovery key as dash-separated groups
+func formatRecoveryKey(secret []byte) string {
+	encoded := recoveryEncoding.EncodeToString(secret)
+
+	var groups []string
+	for i := 0; i < len(encoded); i += recoveryGroupLen {
+		end := i + recoveryGroupLen
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		groups = append(groups, encoded[i:end])
+	}
+	return strings.Join(groups, "-")
+}
+
+// parseRecoveryKey decodes a recovery key, ignoring case, dashes and spaces
+func parseRecoveryKey(recoveryKey string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(recoveryKey))
+
+	secret, err := recoveryEncoding.DecodeString(normalized)
+	if err != nil || len(secret) != recoveryKeySize {
+		return nil, fmt.Errorf("invalid recovery key format")
+	}
+	return secret, nil
+}
+
+// recoveryWrappingKey derives the key that wraps the master key for recovery
+func recoveryWrappingKey(secret []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	reader := hkdf.New(sha256.New, secret, nil, []byte("moodstack/recovery-wrap"))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return nil, fmt.Errorf("failed to derive recovery key: %v", err)
+	}
+	return key, nil
+}
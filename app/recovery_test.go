@@ -0,0 +1,147 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseRecoveryKey(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x5a}, recoveryKeySize)
+	formatted := formatRecoveryKey(secret)
+
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"formatted", formatted, true},
+		{"lower case", strings.ToLower(formatted), true},
+		{"without dashes", strings.ReplaceAll(formatted, "-", ""), true},
+		{"spaces", strings.ReplaceAll(formatted, "-", " "), true},
+		{"too short", formatted[:len(formatted)-5], false},
+		{"invalid characters", strings.Replace(formatted, formatted[:1], "1", 1), false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseRecoveryKey(tt.key)
+			if !tt.ok {
+				if err == nil {
+					t.Error("parseRecoveryKey succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRecoveryKey: %v", err)
+			}
+			if !bytes.Equal(parsed, secret) {
+				t.Errorf("parseRecoveryKey = %x, want %x", parsed, secret)
+			}
+		})
+	}
+}
+
+func TestRecoverAccountResetsPassword(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+
+	diary := &Diary{ID: "diary-1", Title: "标题", Content: "忘记密码之前写的日记", Tags: []string{}}
+	if err := SaveEncryptedDiary(diary, user.ID, masterKey); err != nil {
+		t.Fatal(err)
+	}
+	recoveryKey, err := CreateRecoveryKey(user.ID, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := AuthenticateWithPassword("alice", "password123"); err != nil || !result.Success {
+		t.Fatalf("AuthenticateWithPassword: %v %v", err, result)
+	}
+
+	recovered, err := RecoverAccount(strings.ToLower(recoveryKey), "new-password")
+	if err != nil {
+		t.Fatalf("RecoverAccount: %v", err)
+	}
+	if recovered.ID != user.ID {
+		t.Fatalf("recovered user %d, want %d", recovered.ID, user.ID)
+	}
+
+	if _, err := UnlockMasterKey(recovered, "password123"); err == nil {
+		t.Error("old password still unlocks the master key")
+	}
+	unlocked, err := UnlockMasterKey(recovered, "new-password")
+	if err != nil {
+		t.Fatalf("new password does not unlock: %v", err)
+	}
+	if !bytes.Equal(unlocked, masterKey) {
+		t.Error("new password unlocks a different master key")
+	}
+
+	opened, err := GetEncryptedDiaryByID(diary.ID, user.ID, unlocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Content != diary.Content {
+		t.Errorf("content = %q, want %q", opened.Content, diary.Content)
+	}
+
+	var sessions int64
+	if err := gormDB.Model(&Session{}).Where("user_id = ?", user.ID).Count(&sessions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 0 {
+		t.Errorf("%d sessions left after recovery, want 0", sessions)
+	}
+
+	// The recovery key keeps working for a later reset
+	if _, err := RecoverAccount(recoveryKey, "another-password"); err != nil {
+		t.Errorf("second RecoverAccount: %v", err)
+	}
+}
+
+func TestRecoverAccountRejects(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+
+	recoveryKey, err := CreateRecoveryKey(user.ID, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := formatRecoveryKey(bytes.Repeat([]byte{1}, recoveryKeySize))
+
+	tests := []struct {
+		name        string
+		recoveryKey string
+		password    string
+		setup       func()
+	}{
+		{"empty password", recoveryKey, "", nil},
+		{"malformed key", "not-a-key", "new-password", nil},
+		{"other key", otherKey, "new-password", nil},
+		{"removed key", recoveryKey, "new-password", func() {
+			if err := RemoveRecoveryKey(user.ID); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			if _, err := RecoverAccount(tt.recoveryKey, tt.password); err == nil {
+				t.Fatal("RecoverAccount succeeded, want an error")
+			}
+
+			unchanged, err := GetUserByID(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := UnlockMasterKey(unchanged, "password123"); err != nil {
+				t.Errorf("password changed by a rejected recovery: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Diary revisions
+//
+// Every save of a diary also stores its sealed row as a DiaryRevision. Saves
+// within revisionCoalesceWindow of the previous one overwrite the latest
+// revision instead of adding a new one, as long as that revision is younger
+// than revisionMaxCoalesce, so autosaves do not flood the history. Only the
+// newest maxRevisionsPerDiary revisions are kept.
+//
+// When a diary is switched to individual encryption, its revisions sealed
+// under the master key are deleted, and the history of an individually
+// encrypted diary is never opened with the master key.
+const (
+	revisionCoalesceWindow = 2 * time.Minute
+	revisionMaxCoalesce    = 15 * time.Minute
+	maxRevisionsPerDiary   = 50
+)
+
+// DiaryRevisionInfo describes a revision in the history list
+type DiaryRevisionInfo struct {
+	ID             string    `json:"id"`
+	DiaryID        string    `json:"diaryId"`
+	Title          string    `json:"title"`
+	EncryptionMode string    `json:"encryptionMode"`
+	Size           int       `json:"size"` // Content length in characters, 0 when locked
+	Current        bool      `json:"current"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// recordDiaryRevision snapshots a freshly saved diary row
+func recordDiaryRevision(tx *gorm.DB, encDiary *EncryptedDiary) error {
+	now := time.Now()
+
+	// Content re-protected with a password must not stay readable in older revisions
+	if encDiary.EncryptionMode == "individual" {
+		if err := tx.Where("diary_id = ? AND user_id = ? AND encryption_mode <> ?", encDiary.ID, encDiary.UserID, "individual").
+			Delete(&DiaryRevision{}).Error; err != nil {
+			return fmt.Errorf("failed to delete master key revisions: %v", err)
+		}
+	}
+
+	var latest DiaryRevision
+	err := tx.Where("diary_id = ? AND user_id = ?", encDiary.ID, encDiary.UserID).Order("created_at DESC").First(&latest).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("failed to get latest revision: %v", err)
+	}
+
+	revision := &DiaryRevision{
+		DiaryID:           encDiary.ID,
+		UserID:            encDiary.UserID,
+		Title:             encDiary.Title,
+		EncryptedContent:  encDiary.EncryptedContent,
+		IV:                encDiary.IV,
+		FileName:          encDiary.FileName,
+		FileType:          encDiary.FileType,
+		Tags:              encDiary.Tags,
+		EncryptionMode:    encDiary.EncryptionMode,
+		EncryptionSalt:    encDiary.EncryptionSalt,
+		WrappedKey:        encDiary.WrappedKey,
+		EncryptedMetadata: encDiary.EncryptedMetadata,
+		MetadataIV:        encDiary.MetadataIV,
+		UpdatedAt:         now,
+	}
+
+	// Coalesce rapid saves into the latest revision
+	if err == nil && latest.EncryptionMode == encDiary.EncryptionMode &&
+		now.Sub(latest.UpdatedAt) < revisionCoalesceWindow &&
+		now.Sub(latest.CreatedAt) < revisionMaxCoalesce {
+		revision.ID = latest.ID
+		revision.CreatedAt = latest.CreatedAt
+		if err := tx.Save(revision).Error; err != nil {
+			return fmt.Errorf("failed to update revision: %v", err)
+		}
+		return nil
+	}
+
+	revisionID, err := GenerateID()
+	if err != nil {
+		return fmt.Errorf("failed to generate revision ID: %v", err)
+	}
+	revision.ID = revisionID
+	revision.CreatedAt = now
+
+	if err := tx.Create(revision).Error; err != nil {
+		return fmt.Errorf("failed to create revision: %v", err)
+	}
+
+	// Drop the oldest revisions beyond the limit
+	var stale []string
+	if err := tx.Model(&DiaryRevision{}).Where("diary_id = ? AND user_id = ?", encDiary.ID, encDiary.UserID).
+		Order("created_at DESC").Offset(maxRevisionsPerDiary).Pluck("id", &stale).Error; err != nil {
+		return fmt.Errorf("failed to query old revisions: %v", err)
+	}
+	if len(stale) > 0 {
+		if err := tx.Where("id IN ?", stale).Delete(&DiaryRevision{}).Error; err != nil {
+			return fmt.Errorf("failed to prune revisions: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ListDiaryRevisions returns the revisions of a diary, newest first
+func ListDiaryRevisions(diaryID string, userID uint, masterKey []byte) ([]DiaryRevisionInfo, error) {
+	if _, err := revisionsDiary(diaryID, userID); err != nil {
+		return nil, err
+	}
+
+	var revisions []DiaryRevision
+	if err := gormDB.Where("diary_id = ? AND user_id = ?", diaryID, userID).Order("created_at DESC").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("failed to query revisions: %v", err)
+	}
+
+	infos := make([]DiaryRevisionInfo, 0, len(revisions))
+	for i, revision := range revisions {
+		encDiary := revision.encryptedDiary()
+
+		diary := lockedDiary(encDiary)
+		size := 0
+		if revision.EncryptionMode != "individual" {
+			if opened, err := openEncryptedDiary(encDiary, masterKey); err == nil {
+				diary = opened
+				size = len([]rune(opened.Content))
+			}
+		}
+
+		infos = append(infos, DiaryRevisionInfo{
+			ID:             revision.ID,
+			DiaryID:        revision.DiaryID,
+			Title:          diary.Title,
+			EncryptionMode: revision.EncryptionMode,
+			Size:           size,
+			Current:        i == 0,
+			CreatedAt:      revision.CreatedAt,
+			UpdatedAt:      revision.UpdatedAt,
+		})
+	}
+
+	return infos, nil
+}
+
+// DiffDiaryRevisions returns a line-level diff from one revision to another
+func DiffDiaryRevisions(diaryID string, userID uint, fromRevisionID, toRevisionID string, masterKey []byte) ([]DiffLine, error) {
+	from, err := openDiaryRevision(diaryID, userID, fromRevisionID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := openDiaryRevision(diaryID, userID, toRevisionID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffLines(from.Content, to.Content), nil
+}
+
+// RestoreDiaryRevision makes an older revision the current content of the diary.
+// The restore is saved as a new revision, so it can be undone.
+func RestoreDiaryRevision(diaryID string, userID uint, revisionID string, masterKey []byte) (*Diary, error) {
+	current, err := revisionsDiary(diaryID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	diary, err := openDiaryRevision(diaryID, userID, revisionID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tags are not versioned; the restored diary keeps its current tags
+	tags, err := diaryTagNames(userID, []string{diaryID}, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	diary.Tags = tags[diaryID]
+	if diary.Tags == nil {
+		diary.Tags = []string{}
+	}
+
+	diary.CreatedAt = current.CreatedAt
+	diary.UpdatedAt = time.Now()
+
+	if err := SaveEncryptedDiaryWithOptions(diary, userID, masterKey, &DiaryEncryptionOptions{
+		Mode: current.EncryptionMode,
+	}); err != nil {
+		return nil, err
+	}
+
+	return diary, nil
+}
+
+// revisionsDiary returns the current row of a diary whose history may be
+// opened with the master key. Individually encrypted diaries are refused, as
+// their older revisions may still be sealed under the master key.
+func revisionsDiary(diaryID string, userID uint) (*EncryptedDiary, error) {
+	var current EncryptedDiary
+	if err := gormDB.Where("id = ? AND user_id = ?", diaryID, userID).First(&current).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("diary not found")
+		}
+		return nil, fmt.Errorf("failed to get diary: %v", err)
+	}
+
+	if current.EncryptionMode == "individual" {
+		return nil, fmt.Errorf("单独加密的日记不支持查看历史版本")
+	}
+	return &current, nil
+}
+
+// openDiaryRevision decrypts a single revision
+func openDiaryRevision(diaryID string, userID uint, revisionID string, masterKey []byte) (*Diary, error) {
+	if _, err := revisionsDiary(diaryID, userID); err != nil {
+		return nil, err
+	}
+
+	var revision DiaryRevision
+	if err := gormDB.Where("id = ? AND diary_id = ? AND user_id = ?", revisionID, diaryID, userID).First(&revision).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("revision not found")
+		}
+		return nil, fmt.Errorf("failed to get revision: %v", err)
+	}
+
+	if revision.EncryptionMode == "individual" {
+		return nil, fmt.Errorf("单独加密的历史版本需要单独密码解锁")
+	}
+
+	diary, err := openEncryptedDiary(revision.encryptedDiary(), masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt revision: %v", err)
+	}
+
+	return diary, nil
+}
+
+// encryptedDiary rebuilds the diary row a revision was taken from
+func (r *DiaryRevision) encryptedDiary() *EncryptedDiary {
+	return &EncryptedDiary{
+		ID:                r.DiaryID,
+		UserID:            r.UserID,
+		Title:             r.Title,
+		EncryptedContent:  r.EncryptedContent,
+		IV:                r.IV,
+		FileName:          r.FileName,
+		FileType:          r.FileType,
+		Tags:              r.Tags,
+		EncryptionMode:    r.EncryptionMode,
+		EncryptionSalt:    r.EncryptionSalt,
+		WrappedKey:        r.WrappedKey,
+		EncryptedMetadata: r.EncryptedMetadata,
+		MetadataIV:        r.MetadataIV,
+		CreatedAt:         r.CreatedAt,
+		UpdatedAt:         r.UpdatedAt,
+	}
+}
@@ -0,0 +1,47 @@
+package app
+
+import "testing"
+
+func TestRevisionsRefusedAfterSwitchToIndividual(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+
+	diary := &Diary{ID: "secret", Title: "秘密", Content: "以后要单独加密的内容", Tags: []string{}}
+	if err := SaveEncryptedDiaryWithOptions(diary, user.ID, masterKey, &DiaryEncryptionOptions{Mode: "unified"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := ListDiaryRevisions(diary.ID, user.ID, masterKey)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("ListDiaryRevisions = %v, %v; want one revision", revisions, err)
+	}
+	unifiedID := revisions[0].ID
+	if _, err := DiffDiaryRevisions(diary.ID, user.ID, unifiedID, unifiedID, masterKey); err != nil {
+		t.Fatalf("DiffDiaryRevisions before the switch: %v", err)
+	}
+
+	if err := SaveEncryptedDiaryWithOptions(diary, user.ID, masterKey, &DiaryEncryptionOptions{
+		Mode:               "individual",
+		IndividualPassword: "diary-password",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DiffDiaryRevisions(diary.ID, user.ID, unifiedID, unifiedID, masterKey); err == nil {
+		t.Error("DiffDiaryRevisions opened a revision of an individually encrypted diary")
+	}
+	if _, err := ListDiaryRevisions(diary.ID, user.ID, masterKey); err == nil {
+		t.Error("ListDiaryRevisions listed the history of an individually encrypted diary")
+	}
+	if _, err := RestoreDiaryRevision(diary.ID, user.ID, unifiedID, masterKey); err == nil {
+		t.Error("RestoreDiaryRevision restored into an individually encrypted diary")
+	}
+
+	var remaining int64
+	if err := gormDB.Model(&DiaryRevision{}).Where("diary_id = ? AND encryption_mode <> ?", diary.ID, "individual").Count(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("%d revisions sealed under the master key remain after the switch", remaining)
+	}
+}
@@ -0,0 +1,449 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+)
+
+// Search index
+//
+// Unified and biometric diaries are indexed in an inverted index whose terms
+// are stored as HMAC-SHA256 tokens keyed by a key derived from the user's
+// master key, so the index reveals neither the words nor the content. Words
+// of alphabetic scripts are indexed whole; runs of CJK characters, which are
+// not separated by spaces, are indexed as single characters and bigrams.
+// Results are ranked with BM25. Individually encrypted diaries are not indexed
+// because their content is only available with their own password.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// titleTermWeight counts a term in the title as this many occurrences
+	titleTermWeight = 3
+
+	// defaultSearchLimit and maxSearchLimit bound how many ranked diaries
+	// are decrypted per page
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchOptions selects a page of search results
+type SearchOptions struct {
+	Offset int `json:"offset,omitempty"` // Results to skip
+	Limit  int `json:"limit,omitempty"`
+}
+
+// SearchPage is a page of search results, best match first
+type SearchPage struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"` // Diaries matching the query
+}
+
+// searchIndexKey derives the key used to hash index terms
+func searchIndexKey(masterKey []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	reader := hkdf.New(sha256.New, masterKey, nil, []byte("moodstack/search-index"))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return nil, fmt.Errorf("failed to derive search index key: %v", err)
+	}
+	return key, nil
+}
+
+// termToken returns the stored token of a term
+func termToken(indexKey []byte, term string) string {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(term))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// isCJK reports whether r belongs to a script written without spaces
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// splitSearchRuns splits lowercased text into alphabetic words and CJK runs
+func splitSearchRuns(text string) (words []string, cjkRuns [][]rune) {
+	var word []rune
+	var run []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+		if len(run) > 0 {
+			cjkRuns = append(cjkRuns, run)
+			run = nil
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(run) > 0 {
+				cjkRuns = append(cjkRuns, run)
+				run = nil
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return words, cjkRuns
+}
+
+// indexTerms returns the terms of a text with their frequencies
+func indexTerms(text string) map[string]int {
+	terms := make(map[string]int)
+
+	words, cjkRuns := splitSearchRuns(text)
+	for _, word := range words {
+		terms[word]++
+	}
+	for _, run := range cjkRuns {
+		for i := range run {
+			terms[string(run[i])]++
+			if i+1 < len(run) {
+				terms[string(run[i:i+2])]++
+			}
+		}
+	}
+
+	return terms
+}
+
+// queryTerms returns the distinct terms to look up for a query.
+// CJK runs are looked up by their bigrams, single characters on their own.
+func queryTerms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	words, cjkRuns := splitSearchRuns(query)
+	for _, word := range words {
+		add(word)
+	}
+	for _, run := range cjkRuns {
+		if len(run) == 1 {
+			add(string(run))
+			continue
+		}
+		for i := 0; i+1 < len(run); i++ {
+			add(string(run[i : i+2]))
+		}
+	}
+
+	return terms
+}
+
+// indexDiary replaces a diary's postings. Individually encrypted diaries are
+// removed from the index instead.
+func indexDiary(tx *gorm.DB, diary *Diary, userID uint, mode string, masterKey []byte) error {
+	if err := unindexDiaries(tx, []string{diary.ID}); err != nil {
+		return err
+	}
+
+	if mode == "individual" {
+		return nil
+	}
+
+	indexKey, err := searchIndexKey(masterKey)
+	if err != nil {
+		return err
+	}
+
+	terms := indexTerms(diary.Content)
+	for term, count := range indexTerms(diary.Title) {
+		terms[term] += count * titleTermWeight
+	}
+
+	length := 0
+	postings := make([]SearchPosting, 0, len(terms))
+	for term, count := range terms {
+		length += count
+		postings = append(postings, SearchPosting{
+			UserID:   userID,
+			Token:    termToken(indexKey, term),
+			DiaryID:  diary.ID,
+			TermFreq: count,
+		})
+	}
+
+	if len(postings) > 0 {
+		if err := tx.CreateInBatches(postings, 200).Error; err != nil {
+			return fmt.Errorf("failed to index diary: %v", err)
+		}
+	}
+
+	if err := tx.Create(&SearchDocument{DiaryID: diary.ID, UserID: userID, Length: length}).Error; err != nil {
+		return fmt.Errorf("failed to index diary: %v", err)
+	}
+
+	return nil
+}
+
+// unindexDiaries removes diaries from the search index
+func unindexDiaries(tx *gorm.DB, diaryIDs []string) error {
+	if err := tx.Where("diary_id IN ?", diaryIDs).Delete(&SearchPosting{}).Error; err != nil {
+		return fmt.Errorf("failed to remove search postings: %v", err)
+	}
+	if err := tx.Where("diary_id IN ?", diaryIDs).Delete(&SearchDocument{}).Error; err != nil {
+		return fmt.Errorf("failed to remove search document: %v", err)
+	}
+	return nil
+}
+
+// buildSearchIndex indexes the user's unified and biometric diaries that are
+// not in the index yet, e.g. diaries saved before the index existed
+func buildSearchIndex(userID uint, masterKey []byte) error {
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Unscoped().
+		Where("user_id = ? AND encryption_mode IN ?", userID, []string{"unified", "biometric"}).
+		Where("id NOT IN (?)", gormDB.Model(&SearchDocument{}).Select("diary_id").Where("user_id = ?", userID)).
+		Find(&encDiaries).Error; err != nil {
+		return fmt.Errorf("failed to query unindexed diaries: %v", err)
+	}
+
+	if len(encDiaries) == 0 {
+		return nil
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		for _, encDiary := range encDiaries {
+			diary, err := openEncryptedDiary(&encDiary, masterKey)
+			if err != nil {
+				fmt.Printf("Failed to index diary %s: %v\n", encDiary.ID, err)
+				continue
+			}
+			if err := indexDiary(tx, diary, userID, encDiary.EncryptionMode, masterKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rankedDiary is a diary ID with its BM25 score
+type rankedDiary struct {
+	DiaryID string
+	Score   float64
+}
+
+// rankDiaries scores the user's indexed diaries against a query with BM25
+func rankDiaries(query string, userID uint, masterKey []byte) ([]rankedDiary, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	indexKey, err := searchIndexKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]string, len(terms))
+	for i, term := range terms {
+		tokens[i] = termToken(indexKey, term)
+	}
+
+	// Trashed diaries keep their index entries until they are purged, so
+	// postings and statistics are limited to the diaries not in the trash
+	live := gormDB.Model(&EncryptedDiary{}).Select("id").Where("user_id = ?", userID)
+
+	var postings []SearchPosting
+	if err := gormDB.Where("user_id = ? AND token IN ? AND diary_id IN (?)", userID, tokens, live).Find(&postings).Error; err != nil {
+		return nil, fmt.Errorf("failed to query search index: %v", err)
+	}
+
+	if len(postings) == 0 {
+		return nil, nil
+	}
+
+	// Collection statistics
+	var stats struct {
+		Count     int64
+		AvgLength float64
+	}
+	if err := gormDB.Model(&SearchDocument{}).Select("COUNT(*) AS count, AVG(length) AS avg_length").
+		Where("user_id = ? AND diary_id IN (?)", userID, live).Scan(&stats).Error; err != nil {
+		return nil, fmt.Errorf("failed to query search statistics: %v", err)
+	}
+	if stats.AvgLength == 0 {
+		stats.AvgLength = 1
+	}
+
+	docFreq := make(map[string]int)
+	diaryIDs := make(map[string]bool)
+	for _, posting := range postings {
+		docFreq[posting.Token]++
+		diaryIDs[posting.DiaryID] = true
+	}
+
+	ids := make([]string, 0, len(diaryIDs))
+	for id := range diaryIDs {
+		ids = append(ids, id)
+	}
+
+	var documents []SearchDocument
+	if err := gormDB.Where("diary_id IN ?", ids).Find(&documents).Error; err != nil {
+		return nil, fmt.Errorf("failed to query search documents: %v", err)
+	}
+	lengths := make(map[string]int, len(documents))
+	for _, document := range documents {
+		lengths[document.DiaryID] = document.Length
+	}
+
+	scores := make(map[string]float64)
+	n := float64(stats.Count)
+	for _, posting := range postings {
+		df := float64(docFreq[posting.Token])
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		tf := float64(posting.TermFreq)
+		norm := 1 - bm25B + bm25B*float64(lengths[posting.DiaryID])/stats.AvgLength
+		scores[posting.DiaryID] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+	}
+
+	ranked := make([]rankedDiary, 0, len(scores))
+	for id, score := range scores {
+		ranked = append(ranked, rankedDiary{DiaryID: id, Score: score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].DiaryID < ranked[j].DiaryID
+	})
+
+	return ranked, nil
+}
+
+// searchPageOf returns the items of the page selected by opts
+func searchPageOf[T any](items []T, opts SearchOptions) []T {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	offset := opts.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	if offset >= len(items) {
+		return nil
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+// searchIndexedDiaries returns a page of the decrypted diaries matching a query,
+// best match first, and the number of matching diaries. Trashed diaries are skipped.
+func searchIndexedDiaries(query string, userID uint, masterKey []byte, opts SearchOptions) ([]Diary, int, error) {
+	ranked, err := rankDiaries(query, userID, masterKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(ranked)
+
+	ranked = searchPageOf(ranked, opts)
+	if len(ranked) == 0 {
+		return []Diary{}, total, nil
+	}
+
+	ids := make([]string, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.DiaryID
+	}
+
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Where("user_id = ? AND id IN ?", userID, ids).Find(&encDiaries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to query diaries: %v", err)
+	}
+	byID := make(map[string]*EncryptedDiary, len(encDiaries))
+	for i := range encDiaries {
+		byID[encDiaries[i].ID] = &encDiaries[i]
+	}
+
+	diaries := make([]Diary, 0, len(ranked))
+	for _, r := range ranked {
+		encDiary, ok := byID[r.DiaryID]
+		if !ok {
+			continue
+		}
+		diary, err := openEncryptedDiary(encDiary, masterKey)
+		if err != nil {
+			continue
+		}
+		diaries = append(diaries, *diary)
+	}
+
+	if err := attachTags(userID, diaries, masterKey); err != nil {
+		return nil, 0, err
+	}
+
+	return diaries, total, nil
+}
+
+// searchSnippets extracts snippets for the whole query, falling back to its words
+func searchSnippets(content, query string) []string {
+	snippets := extractSnippets(content, query, 100)
+	if len(snippets) > 0 {
+		return snippets
+	}
+
+	for _, word := range strings.Fields(query) {
+		snippets = append(snippets, extractSnippets(content, word, 100)...)
+		if len(snippets) >= 3 {
+			return snippets[:3]
+		}
+	}
+
+	if snippets == nil {
+		snippets = []string{}
+	}
+	return snippets
+}
+
+// containsAnyWord reports whether text contains the query or any of its words
+func containsAnyWord(text, query string) bool {
+	lowerText := strings.ToLower(text)
+	lowerQuery := strings.ToLower(query)
+	if strings.Contains(lowerText, lowerQuery) {
+		return true
+	}
+	for _, word := range strings.Fields(lowerQuery) {
+		if strings.Contains(lowerText, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchPageOf(t *testing.T) {
+	items := make([]int, 150)
+	for i := range items {
+		items[i] = i
+	}
+
+	tests := []struct {
+		name      string
+		opts      SearchOptions
+		wantFirst int
+		wantLen   int
+	}{
+		{"default limit", SearchOptions{}, 0, defaultSearchLimit},
+		{"offset", SearchOptions{Offset: 40, Limit: 10}, 40, 10},
+		{"limit capped", SearchOptions{Limit: 1000}, 0, maxSearchLimit},
+		{"negative offset", SearchOptions{Offset: -5, Limit: 3}, 0, 3},
+		{"last page", SearchOptions{Offset: 140, Limit: 20}, 140, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchPageOf(items, tt.opts)
+			if len(got) != tt.wantLen {
+				t.Fatalf("searchPageOf(%+v) returned %d items, want %d", tt.opts, len(got), tt.wantLen)
+			}
+			if got[0] != tt.wantFirst {
+				t.Errorf("searchPageOf(%+v) starts at %d, want %d", tt.opts, got[0], tt.wantFirst)
+			}
+		})
+	}
+
+	if got := searchPageOf(items, SearchOptions{Offset: 150}); len(got) != 0 {
+		t.Errorf("searchPageOf past the end = %v, want empty", got)
+	}
+	if got := searchPageOf([]int{1, 2}, SearchOptions{}); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("searchPageOf(short list) = %v, want [1 2]", got)
+	}
+}
+
+func TestSearchSkipsTrashedDiaries(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+
+	for _, id := range []string{"a", "b", "c"} {
+		diary := &Diary{ID: id, Title: "Fruit " + id, Content: "apple pie notes", Tags: []string{}}
+		if err := SaveEncryptedDiaryWithOptions(diary, user.ID, masterKey, &DiaryEncryptionOptions{Mode: "unified"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DeleteEncryptedDiary("b", user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	diaries, total, err := SearchEncryptedDiaries("apple", user.ID, masterKey, SearchOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+	if len(diaries) != 1 {
+		t.Fatalf("first page has %d diaries, want 1", len(diaries))
+	}
+
+	rest, _, err := SearchEncryptedDiaries("apple", user.ID, masterKey, SearchOptions{Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].ID == "b" || rest[0].ID == diaries[0].ID {
+		t.Errorf("second page = %v, want the other diary that is not in the trash", rest)
+	}
+}
@@ -0,0 +1,470 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Structured search queries
+//
+// A query is a list of terms combined with AND (the default between terms),
+// OR and NOT (or a leading "-"), with parentheses for grouping. A term is a
+// word, a "quoted phrase" or a field filter:
+//
+//	tag:work               diary has the tag
+//	emotion:joy            dominant emotion of the analysis
+//	sentiment:negative     sentiment label of the analysis
+//	after:2024-01-01       created on or after the date
+//	before:2024-02-01      created before the date
+//	mode:individual        encryption mode
+//	type:.docx             original file type
+//
+// Words and phrases match the title and content case-insensitively.
+// Individually encrypted diaries only expose their metadata.
+
+// TextRange is a range of rune offsets into a diary's content
+type TextRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// queryDoc is a diary prepared for query evaluation
+type queryDoc struct {
+	diary        Diary
+	mode         string
+	locked       bool
+	analysis     *EmotionAnalysis
+	lowerTitle   string
+	lowerContent string
+}
+
+// queryNode is a node of a parsed query
+type queryNode interface {
+	match(doc *queryDoc) bool
+}
+
+type andNode []queryNode
+type orNode []queryNode
+type notNode struct{ child queryNode }
+
+// textNode matches a word or phrase in the title or content
+type textNode struct{ text string }
+
+// fieldNode matches a field filter
+type fieldNode struct {
+	field string
+	value string
+	date  time.Time
+}
+
+func (n andNode) match(doc *queryDoc) bool {
+	for _, child := range n {
+		if !child.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n orNode) match(doc *queryDoc) bool {
+	for _, child := range n {
+		if child.match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n notNode) match(doc *queryDoc) bool {
+	return !n.child.match(doc)
+}
+
+func (n textNode) match(doc *queryDoc) bool {
+	if strings.Contains(doc.lowerTitle, n.text) {
+		return true
+	}
+	return !doc.locked && strings.Contains(doc.lowerContent, n.text)
+}
+
+func (n fieldNode) match(doc *queryDoc) bool {
+	switch n.field {
+	case "tag":
+		for _, tag := range doc.diary.Tags {
+			if strings.ToLower(tag) == n.value {
+				return true
+			}
+		}
+		return false
+	case "emotion":
+		return doc.analysis != nil && strings.ToLower(doc.analysis.DominantEmotion) == n.value
+	case "sentiment":
+		return doc.analysis != nil && strings.ToLower(doc.analysis.SentimentLabel) == n.value
+	case "after":
+		return !doc.diary.CreatedAt.Before(n.date)
+	case "before":
+		return doc.diary.CreatedAt.Before(n.date)
+	case "mode":
+		return doc.mode == n.value
+	case "type":
+		return strings.ToLower(doc.diary.FileType) == n.value
+	}
+	return false
+}
+
+// queryToken is a lexical token of a query
+type queryToken struct {
+	kind  string // "word", "phrase", "(", ")"
+	value string
+}
+
+// lexQuery splits a query into words, quoted phrases and parentheses.
+// A quoted value directly after a field prefix or "-" stays part of that word.
+// Empty quotes are dropped together with their prefix, since an empty phrase
+// would match every diary.
+func lexQuery(query string) []queryToken {
+	var tokens []queryToken
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, queryToken{kind: string(r)})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if phrase := string(runes[i+1 : end]); strings.TrimSpace(phrase) != "" {
+				tokens = append(tokens, queryToken{kind: "phrase", value: phrase})
+			}
+			i = end + 1
+		default:
+			var word []rune
+			empty := false
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] == '"' && len(word) > 0 && (word[len(word)-1] == ':' || string(word) == "-") {
+					end := i + 1
+					for end < len(runes) && runes[end] != '"' {
+						end++
+					}
+					quoted := runes[i+1 : end]
+					empty = strings.TrimSpace(string(quoted)) == ""
+					word = append(word, quoted...)
+					i = end + 1
+					continue
+				}
+				word = append(word, runes[i])
+				i++
+			}
+			if !empty {
+				tokens = append(tokens, queryToken{kind: "word", value: string(word)})
+			}
+		}
+	}
+
+	return tokens
+}
+
+// queryParser is a recursive descent parser over query tokens
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	terms  []string // Positive words and phrases, used for highlighting
+}
+
+// parseQuery parses a structured query. An empty query matches everything.
+func parseQuery(query string) (queryNode, []string, error) {
+	p := &queryParser{tokens: lexQuery(query)}
+	if len(p.tokens) == 0 {
+		return andNode{}, nil, nil
+	}
+
+	node, err := p.parseOr(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("查询语法错误: 多余的 \")\"")
+	}
+	return node, p.terms, nil
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *queryParser) isOperator(op string) bool {
+	token := p.peek()
+	return token != nil && token.kind == "word" && token.value == op
+}
+
+func (p *queryParser) parseOr(negated bool) (queryNode, error) {
+	left, err := p.parseAnd(negated)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := orNode{left}
+	for p.isOperator("OR") {
+		p.pos++
+		right, err := p.parseAnd(negated)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd(negated bool) (queryNode, error) {
+	var nodes andNode
+	for {
+		token := p.peek()
+		if token == nil || token.kind == ")" || p.isOperator("OR") {
+			break
+		}
+		if p.isOperator("AND") {
+			p.pos++
+			continue
+		}
+
+		node, err := p.parseUnary(negated)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("查询语法错误: 缺少搜索条件")
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseUnary(negated bool) (queryNode, error) {
+	if p.isOperator("NOT") {
+		p.pos++
+		child, err := p.parseUnary(!negated)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	}
+
+	token := p.peek()
+	if token == nil || token.kind == ")" {
+		return nil, fmt.Errorf("查询语法错误: 缺少搜索条件")
+	}
+	if token.kind == "word" && strings.HasPrefix(token.value, "-") && len(token.value) > 1 {
+		token.value = token.value[1:]
+		child, err := p.parseUnary(!negated)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	}
+
+	return p.parsePrimary(negated)
+}
+
+func (p *queryParser) parsePrimary(negated bool) (queryNode, error) {
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token.kind {
+	case "(":
+		node, err := p.parseOr(negated)
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != ")" {
+			return nil, fmt.Errorf("查询语法错误: 缺少 \")\"")
+		}
+		p.pos++
+		return node, nil
+	case "phrase":
+		return p.textNode(token.value, negated), nil
+	}
+
+	if field, value, ok := strings.Cut(token.value, ":"); ok && value != "" {
+		node, err := newFieldNode(strings.ToLower(field), value)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			return node, nil
+		}
+	}
+
+	return p.textNode(token.value, negated), nil
+}
+
+// textNode creates a word or phrase node and remembers positive terms for highlighting
+func (p *queryParser) textNode(text string, negated bool) queryNode {
+	if !negated && text != "" {
+		p.terms = append(p.terms, text)
+	}
+	return textNode{text: strings.ToLower(text)}
+}
+
+// newFieldNode creates a field filter, or returns nil for unknown fields
+func newFieldNode(field, value string) (queryNode, error) {
+	value = strings.ToLower(value)
+
+	switch field {
+	case "tag", "emotion", "sentiment", "mode":
+		return fieldNode{field: field, value: value}, nil
+	case "type":
+		if !strings.HasPrefix(value, ".") {
+			value = "." + value
+		}
+		return fieldNode{field: field, value: value}, nil
+	case "before", "after":
+		date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("无效的日期 %s，请使用 YYYY-MM-DD 格式", value)
+		}
+		return fieldNode{field: field, date: date}, nil
+	}
+	return nil, nil
+}
+
+// QueryEncryptedDiaries evaluates a structured query against the user's
+// diaries and their emotion analyses, newest first
+func QueryEncryptedDiaries(query string, userID uint, masterKey []byte) ([]SearchResult, error) {
+	node, terms, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Where("user_id = ?", userID).Order("created_at DESC").Find(&encDiaries).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	analyses, err := GetUserEmotionTrends(userID, 0, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	analysisByDiary := make(map[string]*EmotionAnalysis, len(analyses))
+	for i := range analyses {
+		analysisByDiary[analyses[i].DiaryID] = &analyses[i]
+	}
+
+	tagsByDiary, err := diaryTagNames(userID, nil, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	for _, encDiary := range encDiaries {
+		doc := &queryDoc{
+			mode:     encDiary.EncryptionMode,
+			analysis: analysisByDiary[encDiary.ID],
+		}
+
+		if encDiary.EncryptionMode == "individual" {
+			doc.diary = *lockedDiary(&encDiary)
+			doc.locked = true
+		} else {
+			diary, err := openEncryptedDiary(&encDiary, masterKey)
+			if err != nil {
+				continue
+			}
+			doc.diary = *diary
+			doc.lowerContent = strings.ToLower(diary.Content)
+		}
+		if tags, ok := tagsByDiary[encDiary.ID]; ok {
+			doc.diary.Tags = tags
+		}
+		doc.lowerTitle = strings.ToLower(doc.diary.Title)
+
+		if !node.match(doc) {
+			continue
+		}
+
+		results = append(results, queryResult(doc, terms))
+	}
+
+	return results, nil
+}
+
+// queryResult builds the search result of a matched diary
+func queryResult(doc *queryDoc, terms []string) SearchResult {
+	result := SearchResult{
+		Diary:           doc.diary,
+		MatchedSnippets: []string{},
+		Highlights:      []TextRange{},
+	}
+
+	titleMatch, contentMatch := false, false
+	for _, term := range terms {
+		lowerTerm := strings.ToLower(term)
+		if strings.Contains(doc.lowerTitle, lowerTerm) {
+			titleMatch = true
+		}
+		if doc.locked || !strings.Contains(doc.lowerContent, lowerTerm) {
+			continue
+		}
+
+		contentMatch = true
+		if len(result.MatchedSnippets) < 3 {
+			result.MatchedSnippets = append(result.MatchedSnippets, extractSnippets(doc.diary.Content, term, 100)...)
+		}
+		result.Highlights = append(result.Highlights, findRanges(doc.diary.Content, term)...)
+	}
+
+	if len(result.MatchedSnippets) > 3 {
+		result.MatchedSnippets = result.MatchedSnippets[:3]
+	}
+	sort.Slice(result.Highlights, func(i, j int) bool {
+		return result.Highlights[i].Start < result.Highlights[j].Start
+	})
+
+	// Determine match type; filter-only queries count as content matches
+	switch {
+	case titleMatch && contentMatch:
+		result.MatchType = "both"
+	case titleMatch:
+		result.MatchType = "title"
+	default:
+		result.MatchType = "content"
+	}
+
+	return result
+}
+
+// findRanges returns the rune ranges of all case-insensitive occurrences of term in text
+func findRanges(text, term string) []TextRange {
+	textRunes := []rune(strings.ToLower(text))
+	termRunes := []rune(strings.ToLower(term))
+	if len(termRunes) == 0 || len(textRunes) != len([]rune(text)) {
+		return nil
+	}
+
+	var ranges []TextRange
+	for i := 0; i+len(termRunes) <= len(textRunes); i++ {
+		if string(textRunes[i:i+len(termRunes)]) == string(termRunes) {
+			ranges = append(ranges, TextRange{Start: i, End: i + len(termRunes)})
+			i += len(termRunes) - 1
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLexQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []queryToken
+	}{
+		{`工作  日记`, []queryToken{{"word", "工作"}, {"word", "日记"}}},
+		{`"long day" office`, []queryToken{{"phrase", "long day"}, {"word", "office"}}},
+		{`"unterminated phrase`, []queryToken{{"phrase", "unterminated phrase"}}},
+		{`tag:"my work"`, []queryToken{{"word", "tag:my work"}}},
+		{`-"long day"`, []queryToken{{"word", "-long day"}}},
+		{`(a OR b)`, []queryToken{{"(", ""}, {"word", "a"}, {"word", "OR"}, {"word", "b"}, {")", ""}}},
+		{`say"hi"`, []queryToken{{"word", `say"hi"`}}},
+		{`""`, nil},
+		{`-"" office`, []queryToken{{"word", "office"}}},
+		{`tag:" " "  "`, nil},
+		{``, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := lexQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lexQuery(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	docs := map[string]*queryDoc{
+		"work": queryTestDoc(Diary{
+			Title:     "Work notes",
+			Content:   "A long day at the office",
+			FileType:  ".md",
+			Tags:      []string{"Work"},
+			CreatedAt: time.Date(2024, 1, 15, 10, 0, 0, 0, time.Local),
+		}, "unified", &EmotionAnalysis{DominantEmotion: "joy", SentimentLabel: "positive"}),
+		"hike": queryTestDoc(Diary{
+			Title:     "周末",
+			Content:   "和朋友去爬山 hiking trip",
+			FileType:  ".docx",
+			Tags:      []string{"life"},
+			CreatedAt: time.Date(2024, 2, 10, 9, 0, 0, 0, time.Local),
+		}, "unified", &EmotionAnalysis{DominantEmotion: "sadness", SentimentLabel: "negative"}),
+		"locked": queryTestDoc(Diary{
+			Title:     "[加密日记]",
+			Content:   "secret office",
+			FileType:  ".md",
+			CreatedAt: time.Date(2024, 3, 1, 8, 0, 0, 0, time.Local),
+		}, "individual", nil),
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+		terms []string
+	}{
+		{``, []string{"hike", "locked", "work"}, nil},
+		{`OFFICE`, []string{"work"}, []string{"OFFICE"}},
+		{`"long day"`, []string{"work"}, []string{"long day"}},
+		{`"long office"`, nil, []string{"long office"}},
+		{`爬山 AND hiking`, []string{"hike"}, []string{"爬山", "hiking"}},
+		{`day -tag:life`, []string{"work"}, []string{"day"}},
+		{`-"long day"`, []string{"hike", "locked"}, nil},
+		{`"" office`, []string{"work"}, []string{"office"}},
+		{`-""`, []string{"hike", "locked", "work"}, nil},
+		{`NOT tag:work`, []string{"hike", "locked"}, nil},
+		{`NOT NOT tag:work`, []string{"work"}, nil},
+		{`NOT (office OR 爬山)`, []string{"locked"}, nil},
+		{`tag:"work"`, []string{"work"}, nil},
+		{`tag:work OR emotion:sadness`, []string{"hike", "work"}, nil},
+		{`(tag:work OR tag:life) sentiment:negative`, []string{"hike"}, nil},
+		{`after:2024-02-01`, []string{"hike", "locked"}, nil},
+		{`before:2024-02-01`, []string{"work"}, nil},
+		{`after:2024-01-15 before:2024-02-10`, []string{"work"}, nil},
+		{`mode:individual`, []string{"locked"}, nil},
+		{`type:docx`, []string{"hike"}, nil},
+		{`foo:bar`, nil, []string{"foo:bar"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			node, terms, err := parseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("parseQuery: %v", err)
+			}
+
+			var got []string
+			for name, doc := range docs {
+				if node.match(doc) {
+					got = append(got, name)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(terms, tt.terms) {
+				t.Errorf("terms = %v, want %v", terms, tt.terms)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`(office`,
+		`office)`,
+		`()`,
+		`OR`,
+		`office OR`,
+		`NOT`,
+		`after:2024-13-01`,
+		`before:yesterday`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			if _, _, err := parseQuery(query); err == nil {
+				t.Errorf("parseQuery(%q) succeeded, want an error", query)
+			}
+		})
+	}
+}
+
+// queryTestDoc prepares a diary for query evaluation
+func queryTestDoc(diary Diary, mode string, analysis *EmotionAnalysis) *queryDoc {
+	doc := &queryDoc{
+		diary:      diary,
+		mode:       mode,
+		locked:     mode == "individual",
+		analysis:   analysis,
+		lowerTitle: strings.ToLower(diary.Title),
+	}
+	if !doc.locked {
+		doc.lowerContent = strings.ToLower(diary.Content)
+	}
+	return doc
+}
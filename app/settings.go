@@ -0,0 +1,144 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Setting keys
+const (
+	SettingAutoLockMinutes    = "auto_lock_minutes"
+	SettingTrashRetentionDays = "trash_retention_days"
+	SettingAutoTag            = "auto_tag"
+	SettingEmotionPipeline    = "emotion_pipeline"
+)
+
+// Setting defaults
+const (
+	DefaultAutoLockMinutes    = 10
+	DefaultTrashRetentionDays = 30
+)
+
+// GetSetting returns a setting value, or def if the setting does not exist
+func GetSetting(key, def string) (string, error) {
+	var setting AppSetting
+	if err := gormDB.Where("key = ?", key).First(&setting).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return def, nil
+		}
+		return "", fmt.Errorf("failed to get setting %s: %v", key, err)
+	}
+	return setting.Value, nil
+}
+
+// SetSetting creates or updates a setting
+func SetSetting(key, value string) error {
+	setting := &AppSetting{Key: key, Value: value}
+	if err := gormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(setting).Error; err != nil {
+		return fmt.Errorf("failed to save setting %s: %v", key, err)
+	}
+	return nil
+}
+
+// GetAutoLockMinutes returns the idle timeout in minutes; 0 disables auto-lock
+func GetAutoLockMinutes() (int, error) {
+	value, err := GetSetting(SettingAutoLockMinutes, strconv.Itoa(DefaultAutoLockMinutes))
+	if err != nil {
+		return 0, err
+	}
+
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 0 {
+		return DefaultAutoLockMinutes, nil
+	}
+	return minutes, nil
+}
+
+// SetAutoLockMinutes stores the idle timeout in minutes; 0 disables auto-lock
+func SetAutoLockMinutes(minutes int) error {
+	if minutes < 0 {
+		return fmt.Errorf("自动锁定时间不能为负数")
+	}
+	return SetSetting(SettingAutoLockMinutes, strconv.Itoa(minutes))
+}
+
+// GetTrashRetentionDays returns how many days deleted diaries stay in the trash
+func GetTrashRetentionDays() (int, error) {
+	value, err := GetSetting(SettingTrashRetentionDays, strconv.Itoa(DefaultTrashRetentionDays))
+	if err != nil {
+		return 0, err
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return DefaultTrashRetentionDays, nil
+	}
+	return days, nil
+}
+
+// SetTrashRetentionDays stores how many days deleted diaries stay in the trash
+func SetTrashRetentionDays(days int) error {
+	if days < 1 {
+		return fmt.Errorf("回收站保留天数至少为1天")
+	}
+	return SetSetting(SettingTrashRetentionDays, strconv.Itoa(days))
+}
+
+// GetAutoTagEnabled reports whether suggested tags are applied to untagged diaries on save
+func GetAutoTagEnabled() (bool, error) {
+	value, err := GetSetting(SettingAutoTag, "false")
+	if err != nil {
+		return false, err
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, nil
+	}
+	return enabled, nil
+}
+
+// SetAutoTagEnabled enables or disables applying suggested tags on save
+func SetAutoTagEnabled(enabled bool) error {
+	return SetSetting(SettingAutoTag, strconv.FormatBool(enabled))
+}
+
+// GetEmotionPipeline returns the configured emotion analysis pipeline, or
+// DefaultEmotionPipeline when none is configured or the stored one is invalid
+func GetEmotionPipeline() (*EmotionPipelineConfig, error) {
+	value, err := GetSetting(SettingEmotionPipeline, "")
+	if err != nil {
+		return nil, err
+	}
+
+	config := copyEmotionPipeline(DefaultEmotionPipeline)
+	if value == "" {
+		return &config, nil
+	}
+
+	var stored EmotionPipelineConfig
+	if err := json.Unmarshal([]byte(value), &stored); err != nil || validateEmotionPipeline(&stored) != nil {
+		return &config, nil
+	}
+	return &stored, nil
+}
+
+// SetEmotionPipeline stores the emotion analysis pipeline
+func SetEmotionPipeline(config EmotionPipelineConfig) error {
+	if err := validateEmotionPipeline(&config); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal emotion pipeline: %v", err)
+	}
+	return SetSetting(SettingEmotionPipeline, string(data))
+}
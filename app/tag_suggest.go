@@ -0,0 +1,357 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tag suggestions
+//
+// Candidate tags for a diary come from three sources:
+//
+//	existing   the user's tags whose name appears in the diary
+//	emotion    emotion keywords matched by the emotion engine
+//	content    distinctive terms, scored with TF-IDF against the user's diaries
+//
+// Document frequencies are read from the search index, so scoring needs no
+// decryption of other diaries. Tags the diary already has are not suggested.
+const (
+	// defaultTagSuggestions is the number of suggestions returned by default
+	defaultTagSuggestions = 5
+
+	// autoTagLimit and autoTagMinScore control which suggestions are applied on save
+	autoTagLimit    = 3
+	autoTagMinScore = 0.5
+
+	// autoTagMinLength is the content length in characters below which no tags are applied
+	autoTagMinLength = 50
+)
+
+// TagSuggestion is a suggested tag for a diary
+type TagSuggestion struct {
+	Tag    string  `json:"tag"`
+	Score  float64 `json:"score"`  // Relative score in (0, 1]
+	Source string  `json:"source"` // 'existing', 'emotion', 'content'
+}
+
+// Source weights; an existing tag is a better suggestion than a new one
+var tagSourceWeights = map[string]float64{
+	"existing": 1.0,
+	"emotion":  0.8,
+	"content":  0.6,
+}
+
+// tagStopWords are common words that make poor tags
+var tagStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "that": true, "this": true, "with": true,
+	"was": true, "were": true, "are": true, "have": true, "has": true, "had": true,
+	"but": true, "not": true, "you": true, "your": true, "they": true, "them": true,
+	"from": true, "what": true, "when": true, "then": true, "there": true, "their": true,
+	"will": true, "would": true, "could": true, "should": true, "about": true, "just": true,
+	"been": true, "into": true, "some": true, "very": true, "today": true, "also": true,
+	"我们": true, "你们": true, "他们": true, "她们": true, "自己": true, "什么": true,
+	"这个": true, "那个": true, "这样": true, "那样": true, "一个": true, "没有": true,
+	"今天": true, "昨天": true, "明天": true, "时候": true, "因为": true, "所以": true,
+	"但是": true, "然后": true, "还是": true, "已经": true, "可以": true, "就是": true,
+	"不是": true, "一些": true, "一下": true, "觉得": true, "知道": true, "现在": true,
+}
+
+// SuggestTags suggests tags for a diary, best first. Individually encrypted
+// diaries cannot be read without their password and get no suggestions.
+func SuggestTags(diaryID string, userID uint, masterKey []byte, limit int) ([]TagSuggestion, error) {
+	info, err := GetDiaryEncryptionInfo(diaryID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode == "individual" {
+		return nil, fmt.Errorf("单独加密的日记不支持推荐标签")
+	}
+
+	diary, err := GetEncryptedDiaryByID(diaryID, userID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer the keywords of the stored analysis, which may come from the AI model
+	var keywords []string
+	analysis, err := GetEmotionAnalysis(diaryID, userID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if analysis != nil {
+		keywords = analysis.GetKeywords()
+	} else {
+		keywords = emotionKeywordsOf(diary.Content)
+	}
+
+	return suggestTags(diary, userID, masterKey, keywords, limit)
+}
+
+// emotionKeywordsOf returns the emotion keywords matched in content by the rule-based engine
+func emotionKeywordsOf(content string) []string {
+	result, err := AnalyzeEmotionProgrammatically(content)
+	if err != nil {
+		return nil
+	}
+	return result.Keywords
+}
+
+// suggestTags scores tag candidates for a diary
+func suggestTags(diary *Diary, userID uint, masterKey []byte, keywords []string, limit int) ([]TagSuggestion, error) {
+	if limit <= 0 {
+		limit = defaultTagSuggestions
+	}
+
+	// Tag names and keywords are counted as whole words, so a tag such as
+	// "会" does not match inside "会议"
+	existing, err := ListTags(userID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, len(existing)+len(keywords))
+	for _, tag := range existing {
+		candidates = append(candidates, tag.Name)
+	}
+	candidates = append(candidates, keywords...)
+	tokenizer := tagTokenizer(candidates)
+	tokens := lowerTokens(tokenizer.Tokenize(diary.Title + "\n" + diary.Content))
+
+	current := make(map[string]bool)
+	for _, tag := range diary.Tags {
+		current[strings.ToLower(strings.TrimSpace(tag))] = true
+	}
+
+	scores := make(map[string]float64)
+	sources := make(map[string]string)
+	names := make(map[string]string)
+	add := func(name, source string, score float64) {
+		key := strings.ToLower(name)
+		if key == "" || current[key] {
+			return
+		}
+		score *= tagSourceWeights[source]
+		if score > scores[key] {
+			scores[key] = score
+			sources[key] = source
+			if names[key] == "" {
+				names[key] = name
+			}
+		}
+	}
+
+	// Existing tags mentioned in the diary
+	for _, tag := range existing {
+		if count := countPhrase(tokenizer, tokens, tag.Name); count > 0 {
+			names[strings.ToLower(tag.Name)] = tag.Name
+			add(tag.Name, "existing", math.Min(1, 0.5+0.25*float64(count)))
+		}
+	}
+
+	// Emotion keywords, scored by how often they occur
+	for _, keyword := range cleanTags(keywords) {
+		if count := countPhrase(tokenizer, tokens, keyword); count > 0 {
+			add(keyword, "emotion", math.Min(1, 0.4+0.2*float64(count)))
+		}
+	}
+
+	// Distinctive content terms
+	contentTerms, err := distinctiveTerms(diary, userID, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range contentTerms {
+		add(term.Term, "content", term.Score)
+	}
+
+	suggestions := make([]TagSuggestion, 0, len(scores))
+	for key, score := range scores {
+		suggestions = append(suggestions, TagSuggestion{
+			Tag:    names[key],
+			Score:  math.Round(score*1000) / 1000,
+			Source: sources[key],
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Tag < suggestions[j].Tag
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// tagTokenizer returns the emotion engine's tokenizer, extended with the
+// candidate words when it is a DictTokenizer so they are segmented whole
+func tagTokenizer(words []string) Tokenizer {
+	base := getEmotionTokenizer()
+	dictTokenizer, ok := base.(*DictTokenizer)
+	if !ok {
+		return base
+	}
+
+	tokenizer := dictTokenizer.Clone()
+	for _, word := range words {
+		tokenizer.AddWord(word, defaultWordFreq)
+	}
+	return tokenizer
+}
+
+// countPhrase counts the occurrences of a phrase's words in lowercased tokens
+func countPhrase(tokenizer Tokenizer, tokens []Token, phrase string) int {
+	sequence := lowerTokens(tokenizer.Tokenize(phrase))
+	if len(sequence) == 0 {
+		return 0
+	}
+
+	count := 0
+	for i := range tokens {
+		if tokensMatchAt(tokens, i, sequence) {
+			count++
+		}
+	}
+	return count
+}
+
+// scoredTerm is a content term with its scaled TF-IDF score
+type scoredTerm struct {
+	Term  string
+	Score float64
+}
+
+// distinctiveTerms returns the diary's terms ranked by TF-IDF, scaled so the
+// best term scores 1. Words of alphabetic scripts need at least three letters;
+// CJK text contributes its bigrams.
+func distinctiveTerms(diary *Diary, userID uint, masterKey []byte) ([]scoredTerm, error) {
+	counts := make(map[string]int)
+	for _, text := range []string{diary.Title, diary.Content} {
+		words, cjkRuns := splitSearchRuns(text)
+		for _, word := range words {
+			if utf8.RuneCountInString(word) >= 3 && !tagStopWords[word] && !isNumber(word) {
+				counts[word]++
+			}
+		}
+		for _, run := range cjkRuns {
+			for i := 0; i+1 < len(run); i++ {
+				if bigram := string(run[i : i+2]); !tagStopWords[bigram] {
+					counts[bigram]++
+				}
+			}
+		}
+	}
+
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	indexKey, err := searchIndexKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := gormDB.Model(&SearchDocument{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("failed to query search statistics: %v", err)
+	}
+
+	tokens := make([]string, 0, len(counts))
+	termByToken := make(map[string]string, len(counts))
+	for term := range counts {
+		token := termToken(indexKey, term)
+		tokens = append(tokens, token)
+		termByToken[token] = term
+	}
+
+	var docFreqs []struct {
+		Token string
+		Count int
+	}
+	if err := gormDB.Model(&SearchPosting{}).Select("token, COUNT(*) AS count").
+		Where("user_id = ? AND token IN ?", userID, tokens).Group("token").Scan(&docFreqs).Error; err != nil {
+		return nil, fmt.Errorf("failed to query search index: %v", err)
+	}
+	docFreq := make(map[string]int, len(docFreqs))
+	for _, df := range docFreqs {
+		docFreq[termByToken[df.Token]] = df.Count
+	}
+
+	// Terms must occur more than once, unless the corpus is too small to judge rarity
+	minCount := 2
+	if total < 5 {
+		minCount = 1
+	}
+
+	var ranked []scoredTerm
+	maxScore := 0.0
+	for term, count := range counts {
+		if count < minCount {
+			continue
+		}
+		idf := math.Log(float64(total+1)/float64(docFreq[term]+1)) + 1
+		score := (1 + math.Log(float64(count))) * idf
+		ranked = append(ranked, scoredTerm{Term: term, Score: score})
+		maxScore = math.Max(maxScore, score)
+	}
+
+	for i := range ranked {
+		ranked[i].Score /= maxScore
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Term < ranked[j].Term
+	})
+
+	return ranked, nil
+}
+
+// isNumber reports whether a word consists of digits only
+func isNumber(word string) bool {
+	_, err := strconv.Atoi(word)
+	return err == nil
+}
+
+// diaryTagsAssigned reports whether a saved diary has ever had tags
+func diaryTagsAssigned(diaryID string, userID uint) (bool, error) {
+	var assigned []bool
+	if err := gormDB.Model(&EncryptedDiary{}).Where("id = ? AND user_id = ?", diaryID, userID).
+		Pluck("tags_assigned", &assigned).Error; err != nil {
+		return false, fmt.Errorf("failed to query diary tags: %v", err)
+	}
+	return len(assigned) > 0 && assigned[0], nil
+}
+
+// autoTags returns the tags to apply to an untagged diary on save, or nil
+// when auto-tagging is disabled or the diary already has tags
+func autoTags(diary *Diary, userID uint, masterKey []byte) []string {
+	if len(cleanTags(diary.Tags)) > 0 || utf8.RuneCountInString(diary.Content) < autoTagMinLength {
+		return nil
+	}
+
+	enabled, err := GetAutoTagEnabled()
+	if err != nil || !enabled {
+		return nil
+	}
+
+	suggestions, err := suggestTags(diary, userID, masterKey, emotionKeywordsOf(diary.Content), autoTagLimit)
+	if err != nil {
+		fmt.Printf("Failed to suggest tags for diary %s: %v\n", diary.ID, err)
+		return nil
+	}
+
+	var tags []string
+	for _, suggestion := range suggestions {
+		if suggestion.Score >= autoTagMinScore {
+			tags = append(tags, suggestion.Tag)
+		}
+	}
+	return tags
+}
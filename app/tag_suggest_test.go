@@ -0,0 +1,90 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+// tagTestTokenizer knows the words of the tag examples
+func tagTestTokenizer() *DictTokenizer {
+	tokenizer := NewDictTokenizer()
+	for _, word := range []string{"今天", "下午", "开", "了", "三个", "会议", "有", "个", "会", "跑步"} {
+		tokenizer.AddWord(word, defaultWordFreq)
+	}
+	return tokenizer
+}
+
+func TestSuggestTagsMatchesWholeWords(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+	SetEmotionTokenizer(tagTestTokenizer())
+	defer SetEmotionTokenizer(nil)
+
+	tagged := &Diary{ID: "tagged", Title: "旧日记", Content: "内容", Tags: []string{"会"}}
+	if err := SaveEncryptedDiaryWithOptions(tagged, user.ID, masterKey, &DiaryEncryptionOptions{Mode: "unified"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"今天开了三个会议", false},
+		{"下午有个会", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			suggestions, err := suggestTags(&Diary{ID: "new", Content: tt.content}, user.ID, masterKey, nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, suggestion := range suggestions {
+				if suggestion.Tag == "会" && suggestion.Source == "existing" {
+					found = true
+				}
+			}
+			if found != tt.want {
+				t.Errorf("existing tag 会 suggested = %v, want %v (%+v)", found, tt.want, suggestions)
+			}
+		})
+	}
+}
+
+func TestAutoTagsNotReaddedAfterClearing(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+	SetEmotionTokenizer(tagTestTokenizer())
+	defer SetEmotionTokenizer(nil)
+	if err := SetAutoTagEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &Diary{ID: "other", Title: "运动", Content: "跑步", Tags: []string{"跑步"}}
+	if err := SaveEncryptedDiaryWithOptions(other, user.ID, masterKey, &DiaryEncryptionOptions{Mode: "unified"}); err != nil {
+		t.Fatal(err)
+	}
+
+	diary := &Diary{ID: "run", Title: "晨练", Content: strings.Repeat("今天早上去跑步，", 10), Tags: []string{}}
+	save := func() []string {
+		t.Helper()
+		if err := SaveEncryptedDiaryWithOptions(diary, user.ID, masterKey, &DiaryEncryptionOptions{Mode: "unified"}); err != nil {
+			t.Fatal(err)
+		}
+		tags, err := diaryTagNames(user.ID, []string{diary.ID}, masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tags[diary.ID]
+	}
+
+	if tags := save(); len(tags) == 0 {
+		t.Fatal("auto-tagging added no tags to a new diary")
+	}
+
+	diary.Tags = []string{}
+	if tags := save(); len(tags) != 0 {
+		t.Errorf("tags %v were added back after the user cleared them", tags)
+	}
+}
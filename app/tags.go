@@ -0,0 +1,584 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tags
+//
+// Tags live in the tags and diary_tags tables instead of inside each diary.
+// Tag names are encrypted with a key derived from the user's master key, and
+// looked up by a keyed hash of the lowercased name, so tag names stay private
+// whether or not diary metadata encryption is enabled. Tags that are no
+// longer used by any diary are deleted.
+//
+// The tags of individually encrypted diaries are protected like their
+// content: they are only returned with the diary once it is opened with its
+// password, and listings, tag counts and tag filters leave those diaries out.
+
+// TagCount is a tag with the number of diaries using it
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// tagKeys derives the keys that encrypt and hash tag names
+func tagKeys(masterKey []byte) (encKey []byte, macKey []byte, err error) {
+	reader := hkdf.New(sha256.New, masterKey, nil, []byte("moodstack/tags"))
+	encKey = make([]byte, keySize)
+	macKey = make([]byte, keySize)
+	if _, err := io.ReadFull(reader, encKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive tag key: %v", err)
+	}
+	if _, err := io.ReadFull(reader, macKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive tag key: %v", err)
+	}
+	return encKey, macKey, nil
+}
+
+// tagNameHash returns the lookup hash of a tag name; names differing only in case share a hash
+func tagNameHash(macKey []byte, name string) string {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte(strings.ToLower(name)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// tagNameAAD binds an encrypted tag name to its tag row
+func tagNameAAD(userID uint, tagID string) []byte {
+	return []byte(fmt.Sprintf("moodstack/tag/v%d|%d|%s", ciphertextVersion, userID, tagID))
+}
+
+// cleanTags trims tag names and drops empty and duplicate ones, keeping the first spelling
+func cleanTags(tags []string) []string {
+	seen := make(map[string]bool)
+	cleaned := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, tag)
+	}
+	return cleaned
+}
+
+// openTagName decrypts a tag's name
+func openTagName(tag *Tag, encKey []byte) (string, error) {
+	iv, err := base64.StdEncoding.DecodeString(tag.NameIV)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode tag IV: %v", err)
+	}
+
+	name, err := DecryptDataWithAAD(tag.EncryptedName, encKey, iv, tagNameAAD(tag.UserID, tag.ID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt tag name: %v", err)
+	}
+	return string(name), nil
+}
+
+// sealTagName encrypts a name into the tag row
+func sealTagName(tag *Tag, name string, encKey []byte) error {
+	encryptedName, iv, err := EncryptDataWithAAD([]byte(name), encKey, tagNameAAD(tag.UserID, tag.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tag name: %v", err)
+	}
+
+	tag.EncryptedName = encryptedName
+	tag.NameIV = base64.StdEncoding.EncodeToString(iv)
+	return nil
+}
+
+// findTag returns the user's tag with the given name, or nil if it does not exist
+func findTag(tx *gorm.DB, userID uint, name string, macKey []byte) (*Tag, error) {
+	var tag Tag
+	if err := tx.Where("user_id = ? AND name_hash = ?", userID, tagNameHash(macKey, name)).First(&tag).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get tag: %v", err)
+	}
+	return &tag, nil
+}
+
+// findOrCreateTag returns the user's tag with the given name, creating it if needed
+func findOrCreateTag(tx *gorm.DB, userID uint, name string, encKey, macKey []byte) (*Tag, error) {
+	tag, err := findTag(tx, userID, name, macKey)
+	if err != nil || tag != nil {
+		return tag, err
+	}
+
+	tagID, err := GenerateID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate tag ID: %v", err)
+	}
+
+	tag = &Tag{
+		ID:       tagID,
+		UserID:   userID,
+		NameHash: tagNameHash(macKey, name),
+	}
+	if err := sealTagName(tag, name, encKey); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(tag).Error; err != nil {
+		return nil, fmt.Errorf("failed to create tag: %v", err)
+	}
+	return tag, nil
+}
+
+// setDiaryTags replaces the tags of a diary
+func setDiaryTags(tx *gorm.DB, userID uint, diaryID string, tags []string, masterKey []byte) error {
+	encKey, macKey, err := tagKeys(masterKey)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Where("diary_id = ? AND user_id = ?", diaryID, userID).Delete(&DiaryTag{}).Error; err != nil {
+		return fmt.Errorf("failed to clear diary tags: %v", err)
+	}
+
+	for _, name := range cleanTags(tags) {
+		tag, err := findOrCreateTag(tx, userID, name, encKey, macKey)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&DiaryTag{DiaryID: diaryID, TagID: tag.ID, UserID: userID}).Error; err != nil {
+			return fmt.Errorf("failed to tag diary: %v", err)
+		}
+	}
+
+	return deleteUnusedTags(tx, userID)
+}
+
+// deleteUnusedTags removes the user's tags that no diary uses anymore
+func deleteUnusedTags(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ? AND id NOT IN (?)", userID, tx.Model(&DiaryTag{}).Select("tag_id").Where("user_id = ?", userID)).
+		Delete(&Tag{}).Error; err != nil {
+		return fmt.Errorf("failed to delete unused tags: %v", err)
+	}
+	return nil
+}
+
+// diaryTagNames returns the tag names of the given diaries, or of all the
+// user's diaries when diaryIDs is nil. Individually encrypted diaries are
+// left out.
+func diaryTagNames(userID uint, diaryIDs []string, masterKey []byte) (map[string][]string, error) {
+	return readDiaryTagNames(userID, diaryIDs, masterKey, false)
+}
+
+// readDiaryTagNames returns the tag names of diaries, including those of
+// individually encrypted diaries when withIndividual is set
+func readDiaryTagNames(userID uint, diaryIDs []string, masterKey []byte, withIndividual bool) (map[string][]string, error) {
+	encKey, _, err := tagKeys(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	query := gormDB.Model(&DiaryTag{}).Select("diary_tags.*").
+		Joins("JOIN encrypted_diaries ON encrypted_diaries.id = diary_tags.diary_id").
+		Where("diary_tags.user_id = ?", userID)
+	if !withIndividual {
+		query = query.Where("encrypted_diaries.encryption_mode <> ?", "individual")
+	}
+	if diaryIDs != nil {
+		query = query.Where("diary_tags.diary_id IN ?", diaryIDs)
+	}
+
+	var links []DiaryTag
+	if err := query.Order("diary_tags.created_at ASC").Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diary tags: %v", err)
+	}
+
+	names, err := tagNames(userID, encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	byDiary := make(map[string][]string)
+	for _, link := range links {
+		if name, ok := names[link.TagID]; ok {
+			byDiary[link.DiaryID] = append(byDiary[link.DiaryID], name)
+		}
+	}
+	return byDiary, nil
+}
+
+// tagNames decrypts the names of all the user's tags, keyed by tag ID
+func tagNames(userID uint, encKey []byte) (map[string]string, error) {
+	var tags []Tag
+	if err := gormDB.Where("user_id = ?", userID).Find(&tags).Error; err != nil {
+		return nil, fmt.Errorf("failed to query tags: %v", err)
+	}
+
+	names := make(map[string]string, len(tags))
+	for i := range tags {
+		name, err := openTagName(&tags[i], encKey)
+		if err != nil {
+			continue
+		}
+		names[tags[i].ID] = name
+	}
+	return names, nil
+}
+
+// attachTags fills in the tags of diaries from the tag tables. Diaries without
+// tag links keep the tags stored in the diary itself, which only legacy
+// diaries have. Placeholders of individually encrypted diaries get no tags.
+func attachTags(userID uint, diaries []Diary, masterKey []byte) error {
+	if len(diaries) == 0 {
+		return nil
+	}
+
+	var ids []string
+	if len(diaries) <= 500 {
+		ids = make([]string, len(diaries))
+		for i := range diaries {
+			ids[i] = diaries[i].ID
+		}
+	}
+
+	byDiary, err := diaryTagNames(userID, ids, masterKey)
+	if err != nil {
+		return err
+	}
+
+	for i := range diaries {
+		if tags, ok := byDiary[diaries[i].ID]; ok {
+			diaries[i].Tags = tags
+		}
+	}
+	return nil
+}
+
+// attachDiaryTags fills in the tags of a single diary
+func attachDiaryTags(userID uint, diary *Diary, masterKey []byte) error {
+	diaries := []Diary{*diary}
+	if err := attachTags(userID, diaries, masterKey); err != nil {
+		return err
+	}
+	diary.Tags = diaries[0].Tags
+	return nil
+}
+
+// ListTags returns the user's tags with the number of diaries using them,
+// most used first. Diaries in the trash and individually encrypted diaries
+// are not counted.
+func ListTags(userID uint, masterKey []byte) ([]TagCount, error) {
+	encKey, _, err := tagKeys(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		TagID string
+		Count int
+	}
+	if err := gormDB.Model(&DiaryTag{}).Select("diary_tags.tag_id AS tag_id, COUNT(*) AS count").
+		Joins("JOIN encrypted_diaries ON encrypted_diaries.id = diary_tags.diary_id AND encrypted_diaries.deleted_at IS NULL").
+		Where("diary_tags.user_id = ? AND encrypted_diaries.encryption_mode <> ?", userID, "individual").Group("diary_tags.tag_id").Scan(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to count tags: %v", err)
+	}
+
+	names, err := tagNames(userID, encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for _, count := range counts {
+		if name, ok := names[count.TagID]; ok {
+			tags = append(tags, TagCount{Name: name, Count: count.Count})
+		}
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name)
+	})
+	return tags, nil
+}
+
+// TagDiary adds a tag to a diary
+func TagDiary(diaryID string, userID uint, name string, masterKey []byte) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("标签不能为空")
+	}
+
+	if err := requireDiary(diaryID, userID); err != nil {
+		return err
+	}
+
+	encKey, macKey, err := tagKeys(masterKey)
+	if err != nil {
+		return err
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		tag, err := findOrCreateTag(tx, userID, name, encKey, macKey)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&DiaryTag{DiaryID: diaryID, TagID: tag.ID, UserID: userID}).Error; err != nil {
+			return fmt.Errorf("failed to tag diary: %v", err)
+		}
+		return nil
+	})
+}
+
+// UntagDiary removes a tag from a diary
+func UntagDiary(diaryID string, userID uint, name string, masterKey []byte) error {
+	_, macKey, err := tagKeys(masterKey)
+	if err != nil {
+		return err
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		tag, err := findTag(tx, userID, name, macKey)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			return nil
+		}
+
+		if err := tx.Where("diary_id = ? AND tag_id = ? AND user_id = ?", diaryID, tag.ID, userID).Delete(&DiaryTag{}).Error; err != nil {
+			return fmt.Errorf("failed to untag diary: %v", err)
+		}
+		return deleteUnusedTags(tx, userID)
+	})
+}
+
+// RenameTag renames a tag on all diaries. Renaming to an existing tag merges the two.
+func RenameTag(userID uint, oldName, newName string, masterKey []byte) error {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return fmt.Errorf("标签不能为空")
+	}
+
+	encKey, macKey, err := tagKeys(masterKey)
+	if err != nil {
+		return err
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		tag, err := findTag(tx, userID, oldName, macKey)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			return fmt.Errorf("标签不存在: %s", oldName)
+		}
+
+		target, err := findTag(tx, userID, newName, macKey)
+		if err != nil {
+			return err
+		}
+
+		// Same tag (e.g. only the case changes): just re-encrypt the name
+		if target == nil || target.ID == tag.ID {
+			tag.NameHash = tagNameHash(macKey, newName)
+			if err := sealTagName(tag, newName, encKey); err != nil {
+				return err
+			}
+			if err := tx.Save(tag).Error; err != nil {
+				return fmt.Errorf("failed to rename tag: %v", err)
+			}
+			return nil
+		}
+
+		return mergeTagInto(tx, tag, target)
+	})
+}
+
+// MergeTags moves every diary tagged with one of the source tags to the target tag
+func MergeTags(userID uint, sources []string, target string, masterKey []byte) error {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return fmt.Errorf("标签不能为空")
+	}
+
+	encKey, macKey, err := tagKeys(masterKey)
+	if err != nil {
+		return err
+	}
+
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		targetTag, err := findOrCreateTag(tx, userID, target, encKey, macKey)
+		if err != nil {
+			return err
+		}
+
+		for _, source := range sources {
+			sourceTag, err := findTag(tx, userID, source, macKey)
+			if err != nil {
+				return err
+			}
+			if sourceTag == nil || sourceTag.ID == targetTag.ID {
+				continue
+			}
+			if err := mergeTagInto(tx, sourceTag, targetTag); err != nil {
+				return err
+			}
+		}
+
+		return deleteUnusedTags(tx, userID)
+	})
+}
+
+// mergeTagInto relinks the diaries of source to target and deletes source
+func mergeTagInto(tx *gorm.DB, source, target *Tag) error {
+	var links []DiaryTag
+	if err := tx.Where("tag_id = ?", source.ID).Find(&links).Error; err != nil {
+		return fmt.Errorf("failed to query diary tags: %v", err)
+	}
+
+	for _, link := range links {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&DiaryTag{DiaryID: link.DiaryID, TagID: target.ID, UserID: link.UserID}).Error; err != nil {
+			return fmt.Errorf("failed to merge tag: %v", err)
+		}
+	}
+
+	if err := tx.Where("tag_id = ?", source.ID).Delete(&DiaryTag{}).Error; err != nil {
+		return fmt.Errorf("failed to merge tag: %v", err)
+	}
+	if err := tx.Delete(source).Error; err != nil {
+		return fmt.Errorf("failed to delete merged tag: %v", err)
+	}
+	return nil
+}
+
+// GetEncryptedDiariesByTag returns the user's diaries with the given tag, newest
+// first. Individually encrypted diaries are left out, as their tags are protected.
+func GetEncryptedDiariesByTag(userID uint, name string, masterKey []byte) ([]Diary, error) {
+	_, macKey, err := tagKeys(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := findTag(gormDB, userID, name, macKey)
+	if err != nil {
+		return nil, err
+	}
+	if tag == nil {
+		return []Diary{}, nil
+	}
+
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Where("user_id = ? AND encryption_mode <> ? AND id IN (?)", userID, "individual", gormDB.Model(&DiaryTag{}).Select("diary_id").Where("tag_id = ?", tag.ID)).
+		Order("created_at DESC").Find(&encDiaries).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	diaries := make([]Diary, 0, len(encDiaries))
+	for _, encDiary := range encDiaries {
+		diary, err := openEncryptedDiary(&encDiary, masterKey)
+		if err != nil {
+			continue
+		}
+		diaries = append(diaries, *diary)
+	}
+
+	if err := attachTags(userID, diaries, masterKey); err != nil {
+		return nil, err
+	}
+	return diaries, nil
+}
+
+// requireDiary checks that a diary exists and belongs to the user
+func requireDiary(diaryID string, userID uint) error {
+	var count int64
+	if err := gormDB.Model(&EncryptedDiary{}).Where("id = ? AND user_id = ?", diaryID, userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get diary: %v", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("diary not found")
+	}
+	return nil
+}
+
+// migrateDiaryTags moves tags stored inside diaries into the tag tables.
+// Unified and biometric diaries are re-sealed without tags. Individually
+// encrypted diaries only give up plain tag columns; tags sealed in their
+// metadata are moved the next time they are saved with their password.
+// A diary that cannot be opened or resealed is skipped, as its tags could not
+// be read anyway, and the migration completes for the others.
+func migrateDiaryTags(userID uint, masterKey []byte) error {
+	settingKey := fmt.Sprintf("tags_migrated_user_%d", userID)
+	if done, err := GetSetting(settingKey, ""); err != nil || done != "" {
+		return err
+	}
+
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Unscoped().Where("user_id = ? AND encryption_mode IN ?", userID, []string{"unified", "biometric"}).
+		Find(&encDiaries).Error; err != nil {
+		return fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	err := gormDB.Transaction(func(tx *gorm.DB) error {
+		for _, encDiary := range encDiaries {
+			diary, err := openEncryptedDiary(&encDiary, masterKey)
+			if err != nil {
+				fmt.Printf("Skipping tag migration of diary %s: failed to decrypt: %v\n", encDiary.ID, err)
+				continue
+			}
+			if len(diary.Tags) == 0 {
+				continue
+			}
+
+			tags := diary.Tags
+			diary.Tags = nil
+			sealed, err := sealDiary(diary, userID, encDiary.EncryptionMode, masterKey, encDiary.MetadataIV != "")
+			if err != nil {
+				fmt.Printf("Skipping tag migration of diary %s: %v\n", encDiary.ID, err)
+				continue
+			}
+
+			if err := setDiaryTags(tx, userID, diary.ID, tags, masterKey); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&EncryptedDiary{}).Where("id = ?", encDiary.ID).Updates(sealed.columns()).Error; err != nil {
+				return fmt.Errorf("failed to update diary %s: %v", encDiary.ID, err)
+			}
+		}
+
+		var individual []EncryptedDiary
+		if err := tx.Unscoped().Where("user_id = ? AND encryption_mode = ? AND tags NOT IN ?", userID, "individual", []string{"", "[]"}).
+			Find(&individual).Error; err != nil {
+			return fmt.Errorf("failed to query diaries: %v", err)
+		}
+		for _, encDiary := range individual {
+			if err := setDiaryTags(tx, userID, encDiary.ID, encDiary.GetTags(), masterKey); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&EncryptedDiary{}).Where("id = ?", encDiary.ID).Update("tags", "[]").Error; err != nil {
+				return fmt.Errorf("failed to update diary %s: %v", encDiary.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return SetSetting(settingKey, "1")
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrateDiaryTagsSkipsUnreadableDiaries(t *testing.T) {
+	setupTestDatabase(t)
+	user, masterKey := createTestUser(t, "alice", "password123")
+
+	// Diaries saved before the tag tables kept their tags in the sealed row
+	for _, id := range []string{"broken", "good"} {
+		diary := &Diary{ID: id, Title: id, Content: "内容 " + id, Tags: []string{"旅行"}}
+		sealed, err := sealDiary(diary, user.ID, "unified", masterKey, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encDiary := &EncryptedDiary{ID: id, UserID: user.ID, EncryptionMode: "unified", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+		sealed.apply(encDiary)
+		if id == "broken" {
+			encDiary.WrappedKey = "corrupted"
+		}
+		if err := gormDB.Create(encDiary).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateDiaryTags(user.ID, masterKey); err != nil {
+		t.Fatalf("migrateDiaryTags: %v", err)
+	}
+
+	tags, err := diaryTagNames(user.ID, []string{"good"}, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags["good"], []string{"旅行"}) {
+		t.Errorf("tags of the readable diary = %v, want [旅行]", tags["good"])
+	}
+
+	if done, err := GetSetting(fmt.Sprintf("tags_migrated_user_%d", user.ID), ""); err != nil || done == "" {
+		t.Errorf("migration not marked as done: %q, %v", done, err)
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Password attempt throttling
+//
+// Failed password attempts are counted per user and per individually
+// encrypted diary. After throttleFreeAttempts failures every further failure
+// locks the subject for an exponentially growing period, capped at
+// throttleMaxLockout. A successful attempt resets the counter. Every attempt
+// is also written to the auth_attempts audit table.
+const (
+	throttleFreeAttempts = 5
+	throttleBaseLockout  = 30 * time.Second
+	throttleMaxLockout   = time.Hour
+)
+
+// userThrottleSubject returns the throttle subject of a login name
+func userThrottleSubject(username string) string {
+	return "user:" + username
+}
+
+// diaryThrottleSubject returns the throttle subject of an individually encrypted diary
+func diaryThrottleSubject(diaryID string) string {
+	return "diary:" + diaryID
+}
+
+// throttleRemaining returns how long the subject is still locked out
+func throttleRemaining(subject string) (time.Duration, error) {
+	var throttle AuthThrottle
+	if err := gormDB.Where("subject = ?", subject).First(&throttle).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get attempt counter: %v", err)
+	}
+
+	if remaining := time.Until(throttle.LockedUntil); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// recordFailedAttempt counts a failed attempt and returns the resulting lockout
+func recordFailedAttempt(subject string, userID uint, reason string) (time.Duration, error) {
+	var lockout time.Duration
+
+	err := gormDB.Transaction(func(tx *gorm.DB) error {
+		var throttle AuthThrottle
+		if err := tx.Where("subject = ?", subject).First(&throttle).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return fmt.Errorf("failed to get attempt counter: %v", err)
+			}
+			throttle = AuthThrottle{Subject: subject}
+		}
+
+		now := time.Now()
+		throttle.Failures++
+		throttle.LastFailureAt = now
+
+		lockout = throttleLockout(throttle.Failures)
+		if lockout > 0 {
+			throttle.LockedUntil = now.Add(lockout)
+		}
+
+		if err := tx.Save(&throttle).Error; err != nil {
+			return fmt.Errorf("failed to update attempt counter: %v", err)
+		}
+
+		return auditAttempt(tx, subject, userID, false, reason)
+	})
+
+	return lockout, err
+}
+
+// recordSuccessfulAttempt resets the subject's counter and audits the attempt
+func recordSuccessfulAttempt(subject string, userID uint) error {
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subject = ?", subject).Delete(&AuthThrottle{}).Error; err != nil {
+			return fmt.Errorf("failed to reset attempt counter: %v", err)
+		}
+		return auditAttempt(tx, subject, userID, true, "")
+	})
+}
+
+// auditAttempt writes an audit record of a password attempt
+func auditAttempt(tx *gorm.DB, subject string, userID uint, success bool, reason string) error {
+	attempt := &AuthAttempt{
+		Subject: subject,
+		UserID:  userID,
+		Success: success,
+		Reason:  reason,
+	}
+	if err := tx.Create(attempt).Error; err != nil {
+		return fmt.Errorf("failed to audit attempt: %v", err)
+	}
+	return nil
+}
+
+// throttleLockout returns the lockout period after the given number of consecutive failures
+func throttleLockout(failures int) time.Duration {
+	if failures < throttleFreeAttempts {
+		return 0
+	}
+
+	lockout := throttleBaseLockout
+	for i := throttleFreeAttempts; i < failures; i++ {
+		lockout *= 2
+		if lockout >= throttleMaxLockout {
+			return throttleMaxLockout
+		}
+	}
+	return lockout
+}
+
+// lockoutMessage describes a lockout to the user
+func lockoutMessage(remaining time.Duration) string {
+	if remaining < time.Minute {
+		return fmt.Sprintf("密码错误次数过多，请在%d秒后重试", int((remaining+time.Second-1)/time.Second))
+	}
+	return fmt.Sprintf("密码错误次数过多，请在%d分钟后重试", int((remaining+time.Minute-1)/time.Minute))
+}
+
+// ListAuthAttempts returns the most recent password attempts of a user,
+// including attempts on the user's individually encrypted diaries
+func ListAuthAttempts(userID uint, limit int) ([]AuthAttempt, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var attempts []AuthAttempt
+	if err := gormDB.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&attempts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list attempts: %v", err)
+	}
+	return attempts, nil
+}
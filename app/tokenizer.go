@@ -0,0 +1,300 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Tokenizer splits text into tokens with their positions
+type Tokenizer interface {
+	Tokenize(text string) []Token
+}
+
+// Token is a word or punctuation mark of a text. Whitespace other than line
+// breaks is not tokenized.
+type Token struct {
+	Text  string `json:"text"`
+	Start int    `json:"start"` // Rune offset into the text
+	End   int    `json:"end"`   // Rune offset after the token
+	Punct bool   `json:"punct"` // Punctuation or another symbol rather than a word
+}
+
+// Segmentation frequencies of words without a frequency of their own
+const (
+	// defaultWordFreq is used for dictionary words listed without a frequency
+	defaultWordFreq = 1000
+
+	// unknownCharFreq is used for single Han characters not in the dictionary
+	unknownCharFreq = 1
+)
+
+// DictTokenizer segments Han text with a word dictionary and splits other
+// scripts on letters and digits. A run of Han characters is cut along the
+// most probable route through the DAG of all dictionary words it contains,
+// where a word's probability is its frequency over the dictionary's total.
+type DictTokenizer struct {
+	freq   map[string]float64
+	total  float64
+	maxLen int // Longest word in runes
+}
+
+// NewDictTokenizer returns a tokenizer with an empty dictionary
+func NewDictTokenizer() *DictTokenizer {
+	return &DictTokenizer{freq: make(map[string]float64), maxLen: 1}
+}
+
+// AddWord adds a word to the dictionary, keeping the higher frequency of duplicates
+func (t *DictTokenizer) AddWord(word string, freq float64) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" || freq <= 0 {
+		return
+	}
+
+	if old, ok := t.freq[word]; ok {
+		if freq <= old {
+			return
+		}
+		t.total -= old
+	}
+	t.freq[word] = freq
+	t.total += freq
+
+	if length := len([]rune(word)); length > t.maxLen {
+		t.maxLen = length
+	}
+}
+
+// Clone returns a tokenizer with a copy of the dictionary
+func (t *DictTokenizer) Clone() *DictTokenizer {
+	clone := &DictTokenizer{freq: make(map[string]float64, len(t.freq)), total: t.total, maxLen: t.maxLen}
+	for word, freq := range t.freq {
+		clone.freq[word] = freq
+	}
+	return clone
+}
+
+// LoadDictionary reads words from r, one per line as "word [frequency] [tag]".
+// Empty lines and lines starting with # are skipped.
+func (t *DictTokenizer) LoadDictionary(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		freq := float64(defaultWordFreq)
+		if len(fields) > 1 {
+			parsed, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid frequency on line %d: %v", line, err)
+			}
+			freq = parsed
+		}
+		t.AddWord(fields[0], freq)
+	}
+	return scanner.Err()
+}
+
+// LoadDictionaryFile reads words from a dictionary file
+func (t *DictTokenizer) LoadDictionaryFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := t.LoadDictionary(file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Tokenize splits text into words and punctuation
+func (t *DictTokenizer) Tokenize(text string) []Token {
+	runes := []rune(text)
+	var tokens []Token
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			// Line breaks end clauses, so they are kept as punctuation
+			tokens = append(tokens, Token{Text: "\n", Start: i, End: i + 1, Punct: true})
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case unicode.Is(unicode.Han, r):
+			end := i
+			for end < len(runes) && unicode.Is(unicode.Han, runes[end]) {
+				end++
+			}
+			for _, cut := range t.segment(runes[i:end]) {
+				tokens = append(tokens, Token{Text: string(runes[i+cut[0] : i+cut[1]]), Start: i + cut[0], End: i + cut[1]})
+			}
+			i = end
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && isWordRune(runes, end) {
+				end++
+			}
+			tokens = append(tokens, Token{Text: string(runes[i:end]), Start: i, End: end})
+			i = end
+		default:
+			tokens = append(tokens, Token{Text: string(r), Start: i, End: i + 1, Punct: true})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// isWordRune reports whether runes[i] continues a non-Han word. Apostrophes
+// inside words ("don't") are part of the word.
+func isWordRune(runes []rune, i int) bool {
+	r := runes[i]
+	if unicode.Is(unicode.Han, r) {
+		return false
+	}
+	if unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return true
+	}
+	return (r == '\'' || r == '’') && i+1 < len(runes) && unicode.IsLetter(runes[i+1])
+}
+
+// segment returns the [start, end) cuts of a Han run along its most probable route
+func (t *DictTokenizer) segment(run []rune) [][2]int {
+	n := len(run)
+	logTotal := math.Log(math.Max(t.total, 1))
+
+	// best[i] is the log probability of the best route through run[i:],
+	// next[i] the end of its first word
+	best := make([]float64, n+1)
+	next := make([]int, n+1)
+	for i := n - 1; i >= 0; i-- {
+		best[i] = math.Inf(-1)
+		for j := i + 1; j <= n && j-i <= t.maxLen; j++ {
+			freq, ok := t.freq[string(run[i:j])]
+			if !ok {
+				if j-i > 1 {
+					continue
+				}
+				freq = unknownCharFreq
+			}
+			if score := math.Log(freq) - logTotal + best[j]; score > best[i] {
+				best[i] = score
+				next[i] = j
+			}
+		}
+	}
+
+	var cuts [][2]int
+	for i := 0; i < n; i = next[i] {
+		cuts = append(cuts, [2]int{i, next[i]})
+	}
+	return cuts
+}
+
+// wordTokens returns the number of tokens that are words
+func wordTokens(tokens []Token) int {
+	count := 0
+	for _, token := range tokens {
+		if !token.Punct {
+			count++
+		}
+	}
+	return count
+}
+
+var (
+	// emotionTokenizer replaces the default tokenizer when set, guarded by emotionTokenizerMu
+	emotionTokenizer   Tokenizer
+	emotionTokenizerMu sync.RWMutex
+
+	// defaultEmotionTokenizer is built once, on first use
+	defaultEmotionTokenizer     *DictTokenizer
+	defaultEmotionTokenizerOnce sync.Once
+
+	// segmentDictionaryPaths are searched for an optional segmentation dictionary
+	segmentDictionaryPaths = []string{
+		"emotion_dictionaries/segment_dict.txt",
+		"./segment_dict.txt",
+		"../emotion_dictionaries/segment_dict.txt",
+	}
+)
+
+// SetEmotionTokenizer replaces the tokenizer of the emotion engine; nil restores the default
+func SetEmotionTokenizer(tokenizer Tokenizer) {
+	emotionTokenizerMu.Lock()
+	emotionTokenizer = tokenizer
+	emotionTokenizerMu.Unlock()
+}
+
+// getEmotionTokenizer returns the emotion engine's tokenizer. The default is
+// a DictTokenizer that knows every keyword and modifier of the emotion and
+// sentiment dictionaries, plus the words of the segmentation dictionary when
+// one exists.
+func getEmotionTokenizer() Tokenizer {
+	emotionTokenizerMu.RLock()
+	tokenizer := emotionTokenizer
+	emotionTokenizerMu.RUnlock()
+	if tokenizer != nil {
+		return tokenizer
+	}
+
+	defaultEmotionTokenizerOnce.Do(func() {
+		defaultEmotionTokenizer = newEmotionTokenizer()
+	})
+	return defaultEmotionTokenizer
+}
+
+// newEmotionTokenizer builds the default tokenizer, loading the enhanced
+// dictionary first so its keywords, sentiment words and modifiers are segmented whole
+func newEmotionTokenizer() *DictTokenizer {
+	tokenizer := NewDictTokenizer()
+	for _, path := range segmentDictionaryPaths {
+		if err := tokenizer.LoadDictionaryFile(path); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			fmt.Printf("Failed to load segmentation dictionary: %v\n", err)
+		}
+	}
+
+	if err := initializeEnhancedDictionary(); err != nil {
+		fmt.Printf("Failed to load emotion dictionary: %v\n", err)
+	}
+	if enhancedDict != nil {
+		addEmotionDictionaryWords(tokenizer, enhancedDict)
+	}
+
+	return tokenizer
+}
+
+// addEmotionDictionaryWords adds the keywords, sentiment words and modifiers of
+// an emotion dictionary. Intensity phrases such as "有点难过" are left out, so
+// their keyword is still a token.
+func addEmotionDictionaryWords(tokenizer *DictTokenizer, dict *EnhancedDictionary) {
+	for _, category := range dict.Emotions {
+		for _, word := range category.Keywords {
+			tokenizer.AddWord(word, defaultWordFreq)
+		}
+	}
+	for _, category := range dict.Sentiment {
+		for _, word := range category.Keywords {
+			tokenizer.AddWord(word, defaultWordFreq)
+		}
+	}
+	modifiers := dict.ContextModifiers
+	for _, words := range [][]string{modifiers.Negation, modifiers.EmphaticNegation, modifiers.Intensifiers, modifiers.Diminishers} {
+		for _, word := range words {
+			tokenizer.AddWord(word, defaultWordFreq)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDictTokenizerSegments(t *testing.T) {
+	tokenizer := NewDictTokenizer()
+	err := tokenizer.LoadDictionary(strings.NewReader(`
+# word frequency
+今天 2000
+我
+很
+开心
+难过
+不
+知道
+他
+为什么
+但是
+还好
+研究 1000
+研究生 500
+生命 1000
+起源 1000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"今天我很开心", []string{"今天", "我", "很", "开心"}},
+		{"我不知道他为什么开心", []string{"我", "不", "知道", "他", "为什么", "开心"}},
+		{"研究生命起源", []string{"研究", "生命", "起源"}},
+		{"I don't feel well, 但是还好。", []string{"I", "don't", "feel", "well", ",", "但是", "还好", "。"}},
+		{"开心\n难过", []string{"开心", "\n", "难过"}},
+		{"3.5分", []string{"3", ".", "5", "分"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []string
+			for _, token := range tokenizer.Tokenize(tt.text) {
+				got = append(got, token.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDictTokenizerOffsets(t *testing.T) {
+	tokenizer := NewDictTokenizer()
+	tokenizer.AddWord("开心", defaultWordFreq)
+
+	want := []Token{
+		{Text: "ok", Start: 0, End: 2},
+		{Text: "，", Start: 2, End: 3, Punct: true},
+		{Text: "开心", Start: 5, End: 7},
+		{Text: "!", Start: 7, End: 8, Punct: true},
+	}
+	if got := tokenizer.Tokenize("ok，  开心!"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize = %+v, want %+v", got, want)
+	}
+}
+
+func TestDictTokenizerLoadDictionaryErrors(t *testing.T) {
+	tokenizer := NewDictTokenizer()
+	if err := tokenizer.LoadDictionary(strings.NewReader("开心 many\n")); err == nil {
+		t.Error("LoadDictionary accepted an invalid frequency")
+	}
+}
+
+func TestEmotionTokenizerKeepsKeywordsWhole(t *testing.T) {
+	SetEmotionTokenizer(nil)
+	tokenizer := getEmotionTokenizer()
+	if enhancedDict == nil {
+		t.Fatal("emotion dictionary not loaded by the tokenizer")
+	}
+
+	for emotion, category := range enhancedDict.Emotions {
+		for _, keyword := range category.Keywords {
+			keyword = strings.ToLower(keyword)
+			if tokens := tokenizer.Tokenize(keyword); len(tokens) != 1 {
+				t.Errorf("%s keyword %q split into %d tokens", emotion, keyword, len(tokens))
+			}
+		}
+	}
+}
+
+func TestEmotionDictionaryWordsStayWhole(t *testing.T) {
+	dict, err := loadEnhancedDictionary("../emotion_dictionaries/emotion_keywords.json")
+	if err != nil {
+		t.Fatalf("loadEnhancedDictionary: %v", err)
+	}
+	tokenizer := NewDictTokenizer()
+	addEmotionDictionaryWords(tokenizer, dict)
+
+	tests := []struct {
+		text string
+		word string
+	}{
+		{"这次考试失败了", "失败"}, // sentiment only
+		{"感觉糟糕透了", "糟糕透了"},
+		{"今天很开心", "开心"}, // emotion keyword
+		{"一点也不好", "一点也不"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []string
+			for _, token := range tokenizer.Tokenize(tt.text) {
+				got = append(got, token.Text)
+			}
+			for _, text := range got {
+				if text == tt.word {
+					return
+				}
+			}
+			t.Errorf("Tokenize(%q) = %q, want %q kept whole", tt.text, got, tt.word)
+		})
+	}
+}
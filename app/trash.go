@@ -0,0 +1,163 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Trash
+//
+// Deleting a diary only sets EncryptedDiary.DeletedAt, which hides it from
+// every regular query. Trashed diaries are restorable until the retention
+// period (SettingTrashRetentionDays) has passed; they are then purged together
+// with their emotion analysis, revisions and search index entries. Purging runs VACUUM so freed pages are
+// rewritten, and the database is opened with secure_delete so deleted
+// ciphertext is overwritten rather than left in free pages.
+
+// TrashedDiary is a diary in the trash
+type TrashedDiary struct {
+	Diary     Diary     `json:"diary"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"` // When the diary will be deleted permanently
+}
+
+// ListTrashedDiaries returns the user's trashed diaries, most recently deleted first
+func ListTrashedDiaries(userID uint, masterKey []byte) ([]TrashedDiary, error) {
+	retentionDays, err := GetTrashRetentionDays()
+	if err != nil {
+		return nil, err
+	}
+
+	var encDiaries []EncryptedDiary
+	if err := gormDB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).Order("deleted_at DESC").Find(&encDiaries).Error; err != nil {
+		return nil, fmt.Errorf("failed to query trash: %v", err)
+	}
+
+	trashed := make([]TrashedDiary, 0, len(encDiaries))
+	for _, encDiary := range encDiaries {
+		diary := lockedDiary(&encDiary)
+		if encDiary.EncryptionMode != "individual" {
+			if opened, err := openEncryptedDiary(&encDiary, masterKey); err == nil {
+				diary = opened
+			}
+		}
+
+		trashed = append(trashed, TrashedDiary{
+			Diary:     *diary,
+			DeletedAt: encDiary.DeletedAt.Time,
+			PurgeAt:   encDiary.DeletedAt.Time.AddDate(0, 0, retentionDays),
+		})
+	}
+
+	diaries := make([]Diary, len(trashed))
+	for i := range trashed {
+		diaries[i] = trashed[i].Diary
+	}
+	if err := attachTags(userID, diaries, masterKey); err != nil {
+		return nil, err
+	}
+	for i := range trashed {
+		trashed[i].Diary.Tags = diaries[i].Tags
+	}
+
+	return trashed, nil
+}
+
+// RestoreEncryptedDiary moves a diary out of the trash
+func RestoreEncryptedDiary(diaryID string, userID uint) error {
+	result := gormDB.Unscoped().Model(&EncryptedDiary{}).
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", diaryID, userID).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore diary: %v", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("diary not found in trash")
+	}
+
+	return nil
+}
+
+// EmptyTrash permanently deletes all of the user's trashed diaries
+func EmptyTrash(userID uint) (int, error) {
+	return purgeTrash("user_id = ?", userID)
+}
+
+// PurgeExpiredTrash permanently deletes diaries that have been in the trash
+// longer than the retention period
+func PurgeExpiredTrash() (int, error) {
+	retentionDays, err := GetTrashRetentionDays()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	return purgeTrash("deleted_at < ?", cutoff)
+}
+
+// purgeTrash permanently deletes the trashed diaries matching the condition
+// and their emotion analyses, then compacts the database
+func purgeTrash(condition string, args ...interface{}) (int, error) {
+	var trashed []EncryptedDiary
+	if err := gormDB.Unscoped().Select("id", "user_id").Where("deleted_at IS NOT NULL").Where(condition, args...).Find(&trashed).Error; err != nil {
+		return 0, fmt.Errorf("failed to query trash: %v", err)
+	}
+
+	if len(trashed) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, len(trashed))
+	userIDs := make(map[uint]bool)
+	for i, encDiary := range trashed {
+		ids[i] = encDiary.ID
+		userIDs[encDiary.UserID] = true
+	}
+
+	var purged int64
+	err := gormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("diary_id IN ?", ids).Delete(&EmotionAnalysis{}).Error; err != nil {
+			return fmt.Errorf("failed to delete emotion analyses: %v", err)
+		}
+
+		if err := tx.Where("diary_id IN ?", ids).Delete(&DiaryRevision{}).Error; err != nil {
+			return fmt.Errorf("failed to delete revisions: %v", err)
+		}
+
+		if err := unindexDiaries(tx, ids); err != nil {
+			return err
+		}
+
+		if err := tx.Where("diary_id IN ?", ids).Delete(&DiaryTag{}).Error; err != nil {
+			return fmt.Errorf("failed to delete diary tags: %v", err)
+		}
+		for userID := range userIDs {
+			if err := deleteUnusedTags(tx, userID); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&EncryptedDiary{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete diaries: %v", result.Error)
+		}
+		purged = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	// Rewrite the database file so freed pages do not keep old ciphertext
+	if err := gormDB.Exec("VACUUM").Error; err != nil {
+		return int(purged), fmt.Errorf("failed to vacuum database: %v", err)
+	}
+
+	return int(purged), nil
+}
@@ -0,0 +1,44 @@
+package app
+
+import "testing"
+
+func TestEmptyTrashKeepsOtherUsersTags(t *testing.T) {
+	setupTestDatabase(t)
+	alice, aliceKey := createTestUser(t, "alice", "password123")
+	bob, _ := createTestUser(t, "bob", "password456")
+
+	diary := &Diary{ID: "trashed", Title: "标题", Content: "内容", Tags: []string{"旅行"}}
+	if err := SaveEncryptedDiaryWithOptions(diary, alice.ID, aliceKey, &DiaryEncryptionOptions{Mode: "unified"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteEncryptedDiary(diary.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tag of another user that no diary uses
+	if err := gormDB.Create(&Tag{ID: "bob-tag", UserID: bob.ID, NameHash: "hash", EncryptedName: []byte("name"), NameIV: "iv"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := EmptyTrash(alice.ID)
+	if err != nil {
+		t.Fatalf("EmptyTrash: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d diaries, want 1", purged)
+	}
+
+	var aliceTags, bobTags int64
+	gormDB.Model(&Tag{}).Where("user_id = ?", alice.ID).Count(&aliceTags)
+	gormDB.Model(&Tag{}).Where("user_id = ?", bob.ID).Count(&bobTags)
+	if aliceTags != 0 {
+		t.Errorf("alice has %d tags left, want 0", aliceTags)
+	}
+	if bobTags != 1 {
+		t.Errorf("bob has %d tags left, want 1", bobTags)
+	}
+
+	if purged, err := EmptyTrash(alice.ID); err != nil || purged != 0 {
+		t.Errorf("EmptyTrash of an empty trash = %d, %v", purged, err)
+	}
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// Writing statistics
+//
+// Statistics are computed from the diaries' creation times and the word
+// counts in their summary envelopes, so no content is decrypted. Word counts
+// of individually encrypted diaries are unknown; those diaries count as
+// entries but add no words. Days, weeks and hours are in local time.
+
+// recentWeeks is the number of weeks in WritingStatistics.Weekly
+const recentWeeks = 12
+
+// WritingStatistics describes a user's journaling habits
+type WritingStatistics struct {
+	TotalEntries      int     `json:"totalEntries"`
+	TotalWords        int     `json:"totalWords"`
+	AverageWords      float64 `json:"averageWords"` // Per entry with a known word count
+	LongestEntryWords int     `json:"longestEntryWords"`
+	LockedEntries     int     `json:"lockedEntries"` // Individually encrypted, word count unknown
+	ActiveDays        int     `json:"activeDays"`
+
+	CurrentStreak      int    `json:"currentStreak"` // Consecutive days with entries up to today, or yesterday
+	LongestStreak      int    `json:"longestStreak"`
+	LongestStreakStart string `json:"longestStreakStart,omitempty"` // YYYY-MM-DD
+	LongestStreakEnd   string `json:"longestStreakEnd,omitempty"`   // YYYY-MM-DD
+
+	Weekly  []PeriodStats `json:"weekly"`  // The last recentWeeks ISO weeks, oldest first
+	Monthly []PeriodStats `json:"monthly"` // Every month since the first entry, oldest first
+
+	HourDistribution    []int `json:"hourDistribution"`    // Entries per hour of day, 0-23
+	WeekdayDistribution []int `json:"weekdayDistribution"` // Entries per weekday, 0 = Sunday
+	MostActiveHour      int   `json:"mostActiveHour"`      // -1 without entries
+	MostActiveWeekday   int   `json:"mostActiveWeekday"`   // -1 without entries
+}
+
+// PeriodStats holds the entries and words of a week or month, with running totals for growth
+type PeriodStats struct {
+	Period            string `json:"period"` // YYYY-Www for weeks, YYYY-MM for months
+	Entries           int    `json:"entries"`
+	Words             int    `json:"words"`
+	CumulativeEntries int    `json:"cumulativeEntries"`
+	CumulativeWords   int    `json:"cumulativeWords"`
+}
+
+// GetWritingStatistics computes the user's writing statistics over all diaries
+func GetWritingStatistics(userID uint, masterKey []byte) (*WritingStatistics, error) {
+	var rows []EncryptedDiary
+	if err := gormDB.Select(diarySummaryColumns).Where("user_id = ?", userID).Order("created_at ASC").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to query diaries: %v", err)
+	}
+
+	stats := &WritingStatistics{
+		Weekly:              []PeriodStats{},
+		Monthly:             []PeriodStats{},
+		HourDistribution:    make([]int, 24),
+		WeekdayDistribution: make([]int, 7),
+		MostActiveHour:      -1,
+		MostActiveWeekday:   -1,
+	}
+
+	now := time.Now()
+	activeDays := make(map[string]bool)
+	weekly := make(map[string]*PeriodStats)
+	monthly := make(map[string]*PeriodStats)
+	counted := 0
+	wordCounts := make([]int, len(rows))
+
+	for i := range rows {
+		created := rows[i].CreatedAt.Local()
+
+		words := 0
+		if rows[i].EncryptionMode == "individual" {
+			stats.LockedEntries++
+		} else if summary, err := openDiarySummary(&rows[i], masterKey); err == nil {
+			words = summary.WordCount
+			counted++
+		}
+
+		wordCounts[i] = words
+		stats.TotalEntries++
+		stats.TotalWords += words
+		if words > stats.LongestEntryWords {
+			stats.LongestEntryWords = words
+		}
+
+		activeDays[created.Format("2006-01-02")] = true
+		stats.HourDistribution[created.Hour()]++
+		stats.WeekdayDistribution[created.Weekday()]++
+
+		addPeriod(weekly, isoWeek(created), words)
+		addPeriod(monthly, created.Format("2006-01"), words)
+	}
+
+	if counted > 0 {
+		stats.AverageWords = float64(stats.TotalWords) / float64(counted)
+	}
+	stats.ActiveDays = len(activeDays)
+
+	if stats.TotalEntries > 0 {
+		stats.MostActiveHour = maxIndex(stats.HourDistribution)
+		stats.MostActiveWeekday = maxIndex(stats.WeekdayDistribution)
+	}
+
+	stats.CurrentStreak, stats.LongestStreak, stats.LongestStreakStart, stats.LongestStreakEnd = writingStreaks(activeDays, now)
+
+	// Weeks: the last recentWeeks ISO weeks up to this one, with running totals
+	// that include the entries before them
+	windowStart := startOfISOWeek(now.AddDate(0, 0, -7*(recentWeeks-1)))
+	cumulativeEntries, cumulativeWords := 0, 0
+	for i := range rows {
+		if rows[i].CreatedAt.Before(windowStart) {
+			cumulativeEntries++
+			cumulativeWords += wordCounts[i]
+		}
+	}
+	for i := 0; i < recentWeeks; i++ {
+		period := periodOrEmpty(weekly, isoWeek(windowStart.AddDate(0, 0, 7*i)))
+		cumulativeEntries += period.Entries
+		cumulativeWords += period.Words
+		period.CumulativeEntries = cumulativeEntries
+		period.CumulativeWords = cumulativeWords
+		stats.Weekly = append(stats.Weekly, period)
+	}
+
+	// Months: every month since the first entry
+	if len(rows) > 0 {
+		first := rows[0].CreatedAt.Local()
+		month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.Local)
+		cumulativeEntries, cumulativeWords = 0, 0
+		for !month.After(now) {
+			period := periodOrEmpty(monthly, month.Format("2006-01"))
+			cumulativeEntries += period.Entries
+			cumulativeWords += period.Words
+			period.CumulativeEntries = cumulativeEntries
+			period.CumulativeWords = cumulativeWords
+			stats.Monthly = append(stats.Monthly, period)
+			month = month.AddDate(0, 1, 0)
+		}
+	}
+
+	return stats, nil
+}
+
+// addPeriod adds an entry to the stats of a period
+func addPeriod(periods map[string]*PeriodStats, key string, words int) {
+	period, ok := periods[key]
+	if !ok {
+		period = &PeriodStats{Period: key}
+		periods[key] = period
+	}
+	period.Entries++
+	period.Words += words
+}
+
+// periodOrEmpty returns a copy of a period's stats, or empty stats when it has no entries
+func periodOrEmpty(periods map[string]*PeriodStats, key string) PeriodStats {
+	if period, ok := periods[key]; ok {
+		return *period
+	}
+	return PeriodStats{Period: key}
+}
+
+// isoWeek returns the ISO week of t as YYYY-Www
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// startOfISOWeek returns midnight of the Monday of t's week
+func startOfISOWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	day := t.AddDate(0, 0, -offset)
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// maxIndex returns the index of the largest value, the first on ties
+func maxIndex(values []int) int {
+	best := 0
+	for i, value := range values {
+		if value > values[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// writingStreaks computes the current and longest runs of consecutive days
+// with entries. The current streak is not broken until a day without entries
+// has fully passed, so it also counts when the last entry was yesterday.
+func writingStreaks(activeDays map[string]bool, now time.Time) (current, longest int, longestStart, longestEnd string) {
+	if len(activeDays) == 0 {
+		return 0, 0, "", ""
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	day := today
+	if !activeDays[day.Format("2006-01-02")] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for activeDays[day.Format("2006-01-02")] {
+		current++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	for date := range activeDays {
+		start, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			continue
+		}
+		// Only count runs from their first day
+		if activeDays[start.AddDate(0, 0, -1).Format("2006-01-02")] {
+			continue
+		}
+
+		length := 0
+		end := start
+		for activeDays[end.Format("2006-01-02")] {
+			length++
+			end = end.AddDate(0, 0, 1)
+		}
+
+		lastDay := end.AddDate(0, 0, -1).Format("2006-01-02")
+		if length > longest || (length == longest && lastDay > longestEnd) {
+			longest = length
+			longestStart = date
+			longestEnd = lastDay
+		}
+	}
+
+	return current, longest, longestStart, longestEnd
+}
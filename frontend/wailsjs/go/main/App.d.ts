@@ -13,6 +13,8 @@ export function AuthenticateUser(arg1:string,arg2:string):Promise<app.AuthResult
 
 export function AuthenticateWithBiometric(arg1:string):Promise<app.AuthResult>;
 
+export function ChangePassword(arg1:string,arg2:string):Promise<void>;
+
 export function CheckAuthStatus():Promise<main.AuthStatusResult>;
 
 export function CheckBiometricSupport():Promise<app.BiometricInfo>;
@@ -23,41 +25,129 @@ export function CreateDiary(arg1:string,arg2:string):Promise<app.Diary>;
 
 export function CreateDiaryWithEncryption(arg1:string,arg2:string,arg3:app.DiaryEncryptionOptions):Promise<app.Diary>;
 
-export function CreateFirstUser(arg1:string,arg2:string):Promise<app.AuthResult>;
+export function CreateFirstUser(arg1:string,arg2:string,arg3:boolean):Promise<app.AuthResult>;
+
+export function DeleteDiary(arg1:string):Promise<void>;
+
+export function DiffDiaryRevisions(arg1:string,arg2:string,arg3:string):Promise<Array<app.DiffLine>>;
 
 export function DisableBiometric():Promise<void>;
 
+export function EmptyTrash():Promise<number>;
+
 export function EnableBiometric(arg1:string):Promise<void>;
 
+export function GetAuthAttempts(arg1:number):Promise<Array<app.AuthAttempt>>;
+
+export function GetAutoLockTimeout():Promise<number>;
+
+export function GetAutoTagEnabled():Promise<boolean>;
+
 export function GetCurrentUser():Promise<app.User>;
 
 export function GetDiariesList():Promise<Array<app.Diary>>;
 
+export function GetDiariesListByTag(arg1:string):Promise<Array<app.Diary>>;
+
+export function GetDiariesOnDate(arg1:string):Promise<Array<app.DiarySummary>>;
+
 export function GetDiaryByID(arg1:string):Promise<app.Diary>;
 
+export function GetDiaryCalendar(arg1:number,arg2:number):Promise<Array<app.CalendarDay>>;
+
 export function GetDiaryEmotionAnalysis(arg1:string):Promise<app.EmotionAnalysis>;
 
+export function GetDiaryEmotionTimeline(arg1:string):Promise<Array<app.EmotionSegment>>;
+
 export function GetDiaryEncryptionInfo(arg1:string):Promise<app.DiaryEncryptionInfo>;
 
 export function GetDiaryWithPassword(arg1:string,arg2:string):Promise<app.Diary>;
 
 export function GetEmotionAnalysisHistory():Promise<Array<app.EmotionAnalysis>>;
 
+export function GetEmotionPipeline():Promise<app.EmotionPipelineConfig>;
+
 export function GetFirstUser():Promise<app.User>;
 
+export function GetOnThisDay(arg1:string):Promise<Array<app.OnThisDayGroup>>;
+
+export function GetTrashRetentionDays():Promise<number>;
+
 export function GetUserEmotionStatistics(arg1:number):Promise<Record<string, any>>;
 
 export function GetUserEmotionTrends(arg1:number):Promise<Array<app.EmotionAnalysis>>;
 
+export function GetWritingStatistics():Promise<app.WritingStatistics>;
+
 export function Greet(arg1:string):Promise<string>;
 
+export function HasRecoveryKey():Promise<boolean>;
+
+export function ListDiaries(arg1:app.DiaryListOptions):Promise<app.DiaryPage>;
+
+export function ListDiaryRevisions(arg1:string):Promise<Array<app.DiaryRevisionInfo>>;
+
+export function ListEmotionAnalyzers():Promise<Array<app.AnalyzerInfo>>;
+
+export function ListSessions():Promise<Array<app.Session>>;
+
+export function ListTags():Promise<Array<app.TagCount>>;
+
+export function ListTrash():Promise<Array<app.TrashedDiary>>;
+
+export function Lock():Promise<void>;
+
 export function Logout():Promise<void>;
 
+export function MergeTags(arg1:Array<string>,arg2:string):Promise<void>;
+
 export function MigrateData():Promise<void>;
 
-export function SearchDiaries(arg1:string):Promise<Array<app.Diary>>;
+export function QueryDiaries(arg1:string):Promise<Array<app.SearchResult>>;
+
+export function RecoverAccount(arg1:string,arg2:string):Promise<app.AuthResult>;
+
+export function RegenerateRecoveryKey(arg1:string):Promise<string>;
+
+export function RememberSession():Promise<app.Session>;
+
+export function RemoveRecoveryKey():Promise<void>;
+
+export function RenameTag(arg1:string,arg2:string):Promise<void>;
+
+export function ReportActivity():Promise<void>;
+
+export function RestoreDiary(arg1:string):Promise<void>;
+
+export function RestoreDiaryRevision(arg1:string,arg2:string):Promise<app.Diary>;
+
+export function ResumeSession(arg1:string,arg2:string):Promise<app.AuthResult>;
+
+export function RevokeSession(arg1:string):Promise<void>;
+
+export function SearchDiaries(arg1:string,arg2:app.SearchOptions):Promise<Array<app.Diary>>;
+
+export function SearchDiariesWithContext(arg1:string,arg2:app.SearchOptions):Promise<app.SearchPage>;
+
+export function SetAutoLockTimeout(arg1:number):Promise<void>;
+
+export function SetAutoTagEnabled(arg1:boolean):Promise<void>;
+
+export function SetEmotionPipeline(arg1:app.EmotionPipelineConfig):Promise<void>;
+
+export function SetMetadataEncryption(arg1:boolean):Promise<void>;
+
+export function SetTrashRetentionDays(arg1:number):Promise<void>;
+
+export function SuggestTags(arg1:string):Promise<Array<app.TagSuggestion>>;
+
+export function TagDiary(arg1:string,arg2:string):Promise<void>;
+
+export function UnlockWithBiometric():Promise<app.AuthResult>;
+
+export function UnlockWithPassword(arg1:string):Promise<app.AuthResult>;
 
-export function SearchDiariesWithContext(arg1:string):Promise<Array<app.SearchResult>>;
+export function UntagDiary(arg1:string,arg2:string):Promise<void>;
 
 export function UpdateDiary(arg1:app.Diary):Promise<void>;
 
@@ -22,6 +22,10 @@ export function AuthenticateWithBiometric(arg1) {
   return window['go']['main']['App']['AuthenticateWithBiometric'](arg1);
 }
 
+export function ChangePassword(arg1, arg2) {
+  return window['go']['main']['App']['ChangePassword'](arg1, arg2);
+}
+
 export function CheckAuthStatus() {
   return window['go']['main']['App']['CheckAuthStatus']();
 }
@@ -42,18 +46,42 @@ export function CreateDiaryWithEncryption(arg1, arg2, arg3) {
   return window['go']['main']['App']['CreateDiaryWithEncryption'](arg1, arg2, arg3);
 }
 
-export function CreateFirstUser(arg1, arg2) {
-  return window['go']['main']['App']['CreateFirstUser'](arg1, arg2);
+export function CreateFirstUser(arg1, arg2, arg3) {
+  return window['go']['main']['App']['CreateFirstUser'](arg1, arg2, arg3);
+}
+
+export function DeleteDiary(arg1) {
+  return window['go']['main']['App']['DeleteDiary'](arg1);
+}
+
+export function DiffDiaryRevisions(arg1, arg2, arg3) {
+  return window['go']['main']['App']['DiffDiaryRevisions'](arg1, arg2, arg3);
 }
 
 export function DisableBiometric() {
   return window['go']['main']['App']['DisableBiometric']();
 }
 
+export function EmptyTrash() {
+  return window['go']['main']['App']['EmptyTrash']();
+}
+
 export function EnableBiometric(arg1) {
   return window['go']['main']['App']['EnableBiometric'](arg1);
 }
 
+export function GetAuthAttempts(arg1) {
+  return window['go']['main']['App']['GetAuthAttempts'](arg1);
+}
+
+export function GetAutoLockTimeout() {
+  return window['go']['main']['App']['GetAutoLockTimeout']();
+}
+
+export function GetAutoTagEnabled() {
+  return window['go']['main']['App']['GetAutoTagEnabled']();
+}
+
 export function GetCurrentUser() {
   return window['go']['main']['App']['GetCurrentUser']();
 }
@@ -62,14 +90,30 @@ export function GetDiariesList() {
   return window['go']['main']['App']['GetDiariesList']();
 }
 
+export function GetDiariesListByTag(arg1) {
+  return window['go']['main']['App']['GetDiariesListByTag'](arg1);
+}
+
+export function GetDiariesOnDate(arg1) {
+  return window['go']['main']['App']['GetDiariesOnDate'](arg1);
+}
+
 export function GetDiaryByID(arg1) {
   return window['go']['main']['App']['GetDiaryByID'](arg1);
 }
 
+export function GetDiaryCalendar(arg1, arg2) {
+  return window['go']['main']['App']['GetDiaryCalendar'](arg1, arg2);
+}
+
 export function GetDiaryEmotionAnalysis(arg1) {
   return window['go']['main']['App']['GetDiaryEmotionAnalysis'](arg1);
 }
 
+export function GetDiaryEmotionTimeline(arg1) {
+  return window['go']['main']['App']['GetDiaryEmotionTimeline'](arg1);
+}
+
 export function GetDiaryEncryptionInfo(arg1) {
   return window['go']['main']['App']['GetDiaryEncryptionInfo'](arg1);
 }
@@ -82,10 +126,22 @@ export function GetEmotionAnalysisHistory() {
   return window['go']['main']['App']['GetEmotionAnalysisHistory']();
 }
 
+export function GetEmotionPipeline() {
+  return window['go']['main']['App']['GetEmotionPipeline']();
+}
+
 export function GetFirstUser() {
   return window['go']['main']['App']['GetFirstUser']();
 }
 
+export function GetOnThisDay(arg1) {
+  return window['go']['main']['App']['GetOnThisDay'](arg1);
+}
+
+export function GetTrashRetentionDays() {
+  return window['go']['main']['App']['GetTrashRetentionDays']();
+}
+
 export function GetUserEmotionStatistics(arg1) {
   return window['go']['main']['App']['GetUserEmotionStatistics'](arg1);
 }
@@ -94,24 +150,148 @@ export function GetUserEmotionTrends(arg1) {
   return window['go']['main']['App']['GetUserEmotionTrends'](arg1);
 }
 
+export function GetWritingStatistics() {
+  return window['go']['main']['App']['GetWritingStatistics']();
+}
+
 export function Greet(arg1) {
   return window['go']['main']['App']['Greet'](arg1);
 }
 
+export function HasRecoveryKey() {
+  return window['go']['main']['App']['HasRecoveryKey']();
+}
+
+export function ListDiaries(arg1) {
+  return window['go']['main']['App']['ListDiaries'](arg1);
+}
+
+export function ListDiaryRevisions(arg1) {
+  return window['go']['main']['App']['ListDiaryRevisions'](arg1);
+}
+
+export function ListEmotionAnalyzers() {
+  return window['go']['main']['App']['ListEmotionAnalyzers']();
+}
+
+export function ListSessions() {
+  return window['go']['main']['App']['ListSessions']();
+}
+
+export function ListTags() {
+  return window['go']['main']['App']['ListTags']();
+}
+
+export function ListTrash() {
+  return window['go']['main']['App']['ListTrash']();
+}
+
+export function Lock() {
+  return window['go']['main']['App']['Lock']();
+}
+
 export function Logout() {
   return window['go']['main']['App']['Logout']();
 }
 
+export function MergeTags(arg1, arg2) {
+  return window['go']['main']['App']['MergeTags'](arg1, arg2);
+}
+
 export function MigrateData() {
   return window['go']['main']['App']['MigrateData']();
 }
 
-export function SearchDiaries(arg1) {
-  return window['go']['main']['App']['SearchDiaries'](arg1);
+export function QueryDiaries(arg1) {
+  return window['go']['main']['App']['QueryDiaries'](arg1);
+}
+
+export function RecoverAccount(arg1, arg2) {
+  return window['go']['main']['App']['RecoverAccount'](arg1, arg2);
+}
+
+export function RegenerateRecoveryKey(arg1) {
+  return window['go']['main']['App']['RegenerateRecoveryKey'](arg1);
+}
+
+export function RememberSession() {
+  return window['go']['main']['App']['RememberSession']();
+}
+
+export function RemoveRecoveryKey() {
+  return window['go']['main']['App']['RemoveRecoveryKey']();
+}
+
+export function RenameTag(arg1, arg2) {
+  return window['go']['main']['App']['RenameTag'](arg1, arg2);
+}
+
+export function ReportActivity() {
+  return window['go']['main']['App']['ReportActivity']();
+}
+
+export function RestoreDiary(arg1) {
+  return window['go']['main']['App']['RestoreDiary'](arg1);
+}
+
+export function RestoreDiaryRevision(arg1, arg2) {
+  return window['go']['main']['App']['RestoreDiaryRevision'](arg1, arg2);
+}
+
+export function ResumeSession(arg1, arg2) {
+  return window['go']['main']['App']['ResumeSession'](arg1, arg2);
+}
+
+export function RevokeSession(arg1) {
+  return window['go']['main']['App']['RevokeSession'](arg1);
+}
+
+export function SearchDiaries(arg1, arg2) {
+  return window['go']['main']['App']['SearchDiaries'](arg1, arg2);
+}
+
+export function SearchDiariesWithContext(arg1, arg2) {
+  return window['go']['main']['App']['SearchDiariesWithContext'](arg1, arg2);
+}
+
+export function SetAutoLockTimeout(arg1) {
+  return window['go']['main']['App']['SetAutoLockTimeout'](arg1);
+}
+
+export function SetAutoTagEnabled(arg1) {
+  return window['go']['main']['App']['SetAutoTagEnabled'](arg1);
+}
+
+export function SetEmotionPipeline(arg1) {
+  return window['go']['main']['App']['SetEmotionPipeline'](arg1);
+}
+
+export function SetMetadataEncryption(arg1) {
+  return window['go']['main']['App']['SetMetadataEncryption'](arg1);
+}
+
+export function SetTrashRetentionDays(arg1) {
+  return window['go']['main']['App']['SetTrashRetentionDays'](arg1);
+}
+
+export function SuggestTags(arg1) {
+  return window['go']['main']['App']['SuggestTags'](arg1);
+}
+
+export function TagDiary(arg1, arg2) {
+  return window['go']['main']['App']['TagDiary'](arg1, arg2);
+}
+
+export function UnlockWithBiometric() {
+  return window['go']['main']['App']['UnlockWithBiometric']();
+}
+
+export function UnlockWithPassword(arg1) {
+  return window['go']['main']['App']['UnlockWithPassword'](arg1);
 }
 
-export function SearchDiariesWithContext(arg1) {
-  return window['go']['main']['App']['SearchDiariesWithContext'](arg1);
+export function UntagDiary(arg1, arg2) {
+  return window['go']['main']['App']['UntagDiary'](arg1, arg2);
 }
 
 export function UpdateDiary(arg1) {
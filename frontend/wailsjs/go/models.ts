@@ -3,11 +3,15 @@ export namespace app {
 	export class Session {
 	    id: string;
 	    userId: number;
-	    sessionKey: string;
+	    rememberMe: boolean;
 	    // Go type: time
 	    expiresAt: any;
 	    // Go type: time
+	    lastUsedAt: any;
+	    // Go type: time
 	    createdAt: any;
+	    token?: string;
+	    current: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new Session(source);
@@ -17,9 +21,12 @@ export namespace app {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.id = source["id"];
 	        this.userId = source["userId"];
-	        this.sessionKey = source["sessionKey"];
+	        this.rememberMe = source["rememberMe"];
 	        this.expiresAt = this.convertValues(source["expiresAt"], null);
+	        this.lastUsedAt = this.convertValues(source["lastUsedAt"], null);
 	        this.createdAt = this.convertValues(source["createdAt"], null);
+	        this.token = source["token"];
+	        this.current = source["current"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -48,6 +55,7 @@ export namespace app {
 	    createdAt: any;
 	    // Go type: time
 	    updatedAt: any;
+	    encryptMetadata: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new User(source);
@@ -60,6 +68,64 @@ export namespace app {
 	        this.biometricEnabled = source["biometricEnabled"];
 	        this.createdAt = this.convertValues(source["createdAt"], null);
 	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	        this.encryptMetadata = source["encryptMetadata"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class AnalyzerInfo {
+	    name: string;
+	    version: string;
+	    requireAi: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new AnalyzerInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.version = source["version"];
+	        this.requireAi = source["requireAi"];
+	    }
+	}
+	export class AuthAttempt {
+	    id: number;
+	    subject: string;
+	    userId: number;
+	    success: boolean;
+	    reason: string;
+	    // Go type: time
+	    createdAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new AuthAttempt(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.subject = source["subject"];
+	        this.userId = source["userId"];
+	        this.success = source["success"];
+	        this.reason = source["reason"];
+	        this.createdAt = this.convertValues(source["createdAt"], null);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -86,6 +152,7 @@ export namespace app {
 	    session?: Session;
 	    message: string;
 	    requireSetup: boolean;
+	    recoveryKey?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new AuthResult(source);
@@ -98,6 +165,7 @@ export namespace app {
 	        this.session = this.convertValues(source["session"], Session);
 	        this.message = source["message"];
 	        this.requireSetup = source["requireSetup"];
+	        this.recoveryKey = source["recoveryKey"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -132,6 +200,26 @@ export namespace app {
 	        this.message = source["message"];
 	    }
 	}
+	export class CalendarDay {
+	    date: string;
+	    count: number;
+	    dominantEmotion?: string;
+	    avgSentiment: number;
+	    analyzed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CalendarDay(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.date = source["date"];
+	        this.count = source["count"];
+	        this.dominantEmotion = source["dominantEmotion"];
+	        this.avgSentiment = source["avgSentiment"];
+	        this.analyzed = source["analyzed"];
+	    }
+	}
 	export class Diary {
 	    id: string;
 	    title: string;
@@ -181,6 +269,7 @@ export namespace app {
 	export class DiaryEncryptionInfo {
 	    mode: string;
 	    hasSalt: boolean;
+	    metadataEncrypted: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new DiaryEncryptionInfo(source);
@@ -190,6 +279,7 @@ export namespace app {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.mode = source["mode"];
 	        this.hasSalt = source["hasSalt"];
+	        this.metadataEncrypted = source["metadataEncrypted"];
 	    }
 	}
 	export class DiaryEncryptionOptions {
@@ -206,6 +296,180 @@ export namespace app {
 	        this.individualPassword = source["individualPassword"];
 	    }
 	}
+	export class DiaryListOptions {
+	    cursor?: string;
+	    limit?: number;
+	    sortBy?: string;
+	    order?: string;
+	    from?: string;
+	    to?: string;
+	    tag?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiaryListOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.cursor = source["cursor"];
+	        this.limit = source["limit"];
+	        this.sortBy = source["sortBy"];
+	        this.order = source["order"];
+	        this.from = source["from"];
+	        this.to = source["to"];
+	        this.tag = source["tag"];
+	    }
+	}
+	export class DiarySummary {
+	    id: string;
+	    title: string;
+	    excerpt: string;
+	    wordCount: number;
+	    fileType: string;
+	    encryptionMode: string;
+	    locked: boolean;
+	    tags: string[];
+	    dominantEmotion?: string;
+	    // Go type: time
+	    createdAt: any;
+	    // Go type: time
+	    updatedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiarySummary(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.title = source["title"];
+	        this.excerpt = source["excerpt"];
+	        this.wordCount = source["wordCount"];
+	        this.fileType = source["fileType"];
+	        this.encryptionMode = source["encryptionMode"];
+	        this.locked = source["locked"];
+	        this.tags = source["tags"];
+	        this.dominantEmotion = source["dominantEmotion"];
+	        this.createdAt = this.convertValues(source["createdAt"], null);
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DiaryPage {
+	    items: DiarySummary[];
+	    nextCursor?: string;
+	    total: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiaryPage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.items = this.convertValues(source["items"], DiarySummary);
+	        this.nextCursor = source["nextCursor"];
+	        this.total = source["total"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DiaryRevisionInfo {
+	    id: string;
+	    diaryId: string;
+	    title: string;
+	    encryptionMode: string;
+	    size: number;
+	    current: boolean;
+	    // Go type: time
+	    createdAt: any;
+	    // Go type: time
+	    updatedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiaryRevisionInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.diaryId = source["diaryId"];
+	        this.title = source["title"];
+	        this.encryptionMode = source["encryptionMode"];
+	        this.size = source["size"];
+	        this.current = source["current"];
+	        this.createdAt = this.convertValues(source["createdAt"], null);
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DiffLine {
+	    type: string;
+	    text: string;
+	    oldLine: number;
+	    newLine: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiffLine(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.text = source["text"];
+	        this.oldLine = source["oldLine"];
+	        this.newLine = source["newLine"];
+	    }
+	}
 	export class EmotionAnalysis {
 	    id: string;
 	    diaryId: string;
@@ -270,6 +534,48 @@ export namespace app {
 		    return a;
 		}
 	}
+	export class EmotionSegment {
+	    index: number;
+	    paragraph: number;
+	    start: number;
+	    end: number;
+	    joy: number;
+	    sadness: number;
+	    anger: number;
+	    fear: number;
+	    love: number;
+	    surprise: number;
+	    disgust: number;
+	    dominantEmotion: string;
+	    confidence: number;
+	    sentimentScore: number;
+	    sentimentLabel: string;
+	    keywords: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new EmotionSegment(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.index = source["index"];
+	        this.paragraph = source["paragraph"];
+	        this.start = source["start"];
+	        this.end = source["end"];
+	        this.joy = source["joy"];
+	        this.sadness = source["sadness"];
+	        this.anger = source["anger"];
+	        this.fear = source["fear"];
+	        this.love = source["love"];
+	        this.surprise = source["surprise"];
+	        this.disgust = source["disgust"];
+	        this.dominantEmotion = source["dominantEmotion"];
+	        this.confidence = source["confidence"];
+	        this.sentimentScore = source["sentimentScore"];
+	        this.sentimentLabel = source["sentimentLabel"];
+	        this.keywords = source["keywords"];
+	    }
+	}
 	export class EmotionAnalysisResult {
 	    joy: number;
 	    sadness: number;
@@ -284,6 +590,7 @@ export namespace app {
 	    sentimentLabel: string;
 	    keywords: string[];
 	    analysisMethod: string;
+	    timeline?: EmotionSegment[];
 	
 	    static createFrom(source: any = {}) {
 	        return new EmotionAnalysisResult(source);
@@ -304,8 +611,75 @@ export namespace app {
 	        this.sentimentLabel = source["sentimentLabel"];
 	        this.keywords = source["keywords"];
 	        this.analysisMethod = source["analysisMethod"];
+	        this.timeline = this.convertValues(source["timeline"], EmotionSegment);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class EmotionPipelineStep {
+	    name: string;
+	    weight: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new EmotionPipelineStep(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.weight = source["weight"];
 	    }
 	}
+	export class EmotionPipelineConfig {
+	    mode: string;
+	    analyzers: EmotionPipelineStep[];
+	    fallbacks: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new EmotionPipelineConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.mode = source["mode"];
+	        this.analyzers = this.convertValues(source["analyzers"], EmotionPipelineStep);
+	        this.fallbacks = source["fallbacks"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class EncryptedDiary {
 	    id: string;
 	    userId: number;
@@ -366,10 +740,93 @@ export namespace app {
 	        this.diaryCount = source["diaryCount"];
 	    }
 	}
+	export class OnThisDayGroup {
+	    year: number;
+	    yearsAgo: number;
+	    entries: DiarySummary[];
+	
+	    static createFrom(source: any = {}) {
+	        return new OnThisDayGroup(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.year = source["year"];
+	        this.yearsAgo = source["yearsAgo"];
+	        this.entries = this.convertValues(source["entries"], DiarySummary);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TextRange {
+	    start: number;
+	    end: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TextRange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.start = source["start"];
+	        this.end = source["end"];
+	    }
+	}
+	export class PeriodStats {
+	    period: string;
+	    entries: number;
+	    words: number;
+	    cumulativeEntries: number;
+	    cumulativeWords: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PeriodStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.period = source["period"];
+	        this.entries = source["entries"];
+	        this.words = source["words"];
+	        this.cumulativeEntries = source["cumulativeEntries"];
+	        this.cumulativeWords = source["cumulativeWords"];
+	    }
+	}
+	export class SearchOptions {
+	    offset?: number;
+	    limit?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SearchOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.offset = source["offset"];
+	        this.limit = source["limit"];
+	    }
+	}
 	export class SearchResult {
 	    diary: Diary;
 	    matchedSnippets: string[];
 	    matchType: string;
+	    highlights?: TextRange[];
 	
 	    static createFrom(source: any = {}) {
 	        return new SearchResult(source);
@@ -380,6 +837,165 @@ export namespace app {
 	        this.diary = this.convertValues(source["diary"], Diary);
 	        this.matchedSnippets = source["matchedSnippets"];
 	        this.matchType = source["matchType"];
+	        this.highlights = this.convertValues(source["highlights"], TextRange);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SearchPage {
+	    results: SearchResult[];
+	    total: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SearchPage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.results = this.convertValues(source["results"], SearchResult);
+	        this.total = source["total"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TagCount {
+	    name: string;
+	    count: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TagCount(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.count = source["count"];
+	    }
+	}
+	export class TagSuggestion {
+	    tag: string;
+	    score: number;
+	    source: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new TagSuggestion(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.tag = source["tag"];
+	        this.score = source["score"];
+	        this.source = source["source"];
+	    }
+	}
+	export class TrashedDiary {
+	    diary: Diary;
+	    // Go type: time
+	    deletedAt: any;
+	    // Go type: time
+	    purgeAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new TrashedDiary(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.diary = this.convertValues(source["diary"], Diary);
+	        this.deletedAt = this.convertValues(source["deletedAt"], null);
+	        this.purgeAt = this.convertValues(source["purgeAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class WritingStatistics {
+	    totalEntries: number;
+	    totalWords: number;
+	    averageWords: number;
+	    longestEntryWords: number;
+	    lockedEntries: number;
+	    activeDays: number;
+	    currentStreak: number;
+	    longestStreak: number;
+	    longestStreakStart?: string;
+	    longestStreakEnd?: string;
+	    weekly: PeriodStats[];
+	    monthly: PeriodStats[];
+	    hourDistribution: number[];
+	    weekdayDistribution: number[];
+	    mostActiveHour: number;
+	    mostActiveWeekday: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new WritingStatistics(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.totalEntries = source["totalEntries"];
+	        this.totalWords = source["totalWords"];
+	        this.averageWords = source["averageWords"];
+	        this.longestEntryWords = source["longestEntryWords"];
+	        this.lockedEntries = source["lockedEntries"];
+	        this.activeDays = source["activeDays"];
+	        this.currentStreak = source["currentStreak"];
+	        this.longestStreak = source["longestStreak"];
+	        this.longestStreakStart = source["longestStreakStart"];
+	        this.longestStreakEnd = source["longestStreakEnd"];
+	        this.weekly = this.convertValues(source["weekly"], PeriodStats);
+	        this.monthly = this.convertValues(source["monthly"], PeriodStats);
+	        this.hourDistribution = source["hourDistribution"];
+	        this.weekdayDistribution = source["weekdayDistribution"];
+	        this.mostActiveHour = source["mostActiveHour"];
+	        this.mostActiveWeekday = source["mostActiveWeekday"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -410,6 +1026,8 @@ export namespace main {
 	    isAuthenticated: boolean;
 	    hasUsers: boolean;
 	    requireSetup: boolean;
+	    isLocked: boolean;
+	    lockedUsername?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new AuthStatusResult(source);
@@ -420,6 +1038,8 @@ export namespace main {
 	        this.isAuthenticated = source["isAuthenticated"];
 	        this.hasUsers = source["hasUsers"];
 	        this.requireSetup = source["requireSetup"];
+	        this.isLocked = source["isLocked"];
+	        this.lockedUsername = source["lockedUsername"];
 	    }
 	}
 